@@ -0,0 +1,115 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"paperless-backup/internal/archive"
+	"paperless-backup/internal/backup"
+	"paperless-backup/internal/config"
+)
+
+// liveTree names the live volumes as the second side of a diff
+const liveTree = "live"
+
+// runDiff compares two backups, or a backup and the live volumes. Like
+// diff(1) it returns 0 when the trees are identical, 1 when they differ and
+// 2 on errors.
+func runDiff(cfg *config.Config, args []string) int {
+	flags := flag.NewFlagSet("diff", flag.ContinueOnError)
+	jsonOutput := flags.Bool("json", false, "print the changes as JSON")
+	identityFile := flags.String("identity", cfg.EncryptionIdentityFile, "age identity file for encrypted archives")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() < 2 {
+		usage()
+		return 2
+	}
+
+	encryptor, err := archive.NewEncryptor(cfg.EncryptionRecipients, cfg.EncryptionPassphraseFile, *identityFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 2
+	}
+
+	patterns := flags.Args()[2:]
+	old, err := readBackupTree(cfg, encryptor, flags.Arg(0), patterns)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 2
+	}
+
+	var result *archive.DiffResult
+	if flags.Arg(1) == liveTree {
+		sources, err := backup.LiveSources(cfg)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 2
+		}
+		if result, err = archive.DiffTree(old, sources, patterns); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 2
+		}
+	} else {
+		current, err := readBackupTree(cfg, encryptor, flags.Arg(1), patterns)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 2
+		}
+		result = archive.Diff(old, current)
+	}
+
+	if *jsonOutput {
+		if err := printJSON(result); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 2
+		}
+	} else {
+		printDiff(result)
+	}
+
+	if !result.Empty() {
+		return 1
+	}
+	return 0
+}
+
+// readBackupTree returns the entries a backup restores to, replaying the
+// chain of incremental backups
+func readBackupTree(cfg *config.Config, encryptor *archive.Encryptor, name string, patterns []string) ([]*archive.Entry, error) {
+	backupPath, err := backup.ResolveBackup(cfg.BackupDir, name)
+	if err != nil {
+		return nil, err
+	}
+	chain, err := backup.Chain(backupPath)
+	if err != nil {
+		return nil, err
+	}
+	return archive.ReadTree(chain, encryptor, patterns)
+}
+
+// printDiff prints one line per changed path and a summary per source
+func printDiff(result *archive.DiffResult) {
+	markers := map[string]string{
+		archive.ChangeAdded:    "+",
+		archive.ChangeRemoved:  "-",
+		archive.ChangeModified: "M",
+		archive.ChangeMetadata: "m",
+	}
+	for _, change := range result.Changes {
+		line := markers[change.Kind] + " " + change.Path
+		if len(change.Fields) > 0 {
+			line += " (" + strings.Join(change.Fields, ", ") + ")"
+		}
+		fmt.Println(line)
+	}
+
+	for _, summary := range result.Sources {
+		fmt.Printf("%-8s %d added (%.2fMB), %d removed (%.2fMB), %d modified, %d metadata changed\n",
+			summary.Source+":", summary.Added, float64(summary.AddedBytes)/1024/1024,
+			summary.Removed, float64(summary.RemovedBytes)/1024/1024, summary.Modified, summary.Metadata)
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"paperless-backup/internal/archive"
+	"paperless-backup/internal/backup"
+	"paperless-backup/internal/config"
+)
+
+// runExtract restores an archive into a destination directory
+func runExtract(cfg *config.Config, args []string) int {
+	flags := flag.NewFlagSet("extract", flag.ContinueOnError)
+	dryRun := flags.Bool("dry-run", false, "check the archive and print planned changes without writing")
+	overwrite := flags.String("overwrite", archive.OverwriteNever, "existing paths: never, skip or always")
+	restoreOwner := flags.Bool("owner", os.Geteuid() == 0, "restore ownership (default when run as root)")
+	identityFile := flags.String("identity", cfg.EncryptionIdentityFile, "age identity file for encrypted archives")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() < 2 {
+		usage()
+		return 2
+	}
+
+	archivePath, err := backup.ResolveBackup(cfg.BackupDir, flags.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 2
+	}
+
+	encryptor, err := archive.NewEncryptor(cfg.EncryptionRecipients, cfg.EncryptionPassphraseFile, *identityFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 2
+	}
+
+	// Incremental backups are restored by replaying their chain, starting
+	// with the full backup
+	chain, err := backup.Chain(archivePath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 2
+	}
+
+	if len(chain) > 1 {
+		names := make([]string, len(chain))
+		for i, path := range chain {
+			names[i] = filepath.Base(path)
+		}
+		fmt.Printf("Restoring chain of %d backups: %s\n", len(chain), strings.Join(names, ", "))
+	}
+
+	result, err := archive.ExtractChain(chain, flags.Arg(1), archive.ExtractOptions{
+		Encryptor:    encryptor,
+		Patterns:     flags.Args()[2:],
+		DryRun:       *dryRun,
+		Overwrite:    *overwrite,
+		RestoreOwner: *restoreOwner,
+	})
+	if result != nil {
+		for _, action := range result.Actions {
+			fmt.Printf("%-8s %s\n", action.Action, action.Path)
+		}
+		for _, warning := range result.Warnings {
+			fmt.Fprintf(os.Stderr, "Warning: %s\n", warning)
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 2
+	}
+
+	verb := "Extracted"
+	if *dryRun {
+		verb = "Would extract"
+	}
+	fmt.Printf("%s %d files (%.2fMB), %d directories, %d symlinks, %d hard links; %d skipped, %d deleted\n",
+		verb, result.Files, float64(result.Bytes)/1024/1024, result.Dirs, result.Symlinks, result.Hardlinks, len(result.Skipped), result.Deleted)
+	return 0
+}
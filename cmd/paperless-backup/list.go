@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"paperless-backup/internal/archive"
+	"paperless-backup/internal/backup"
+	"paperless-backup/internal/config"
+)
+
+// runListContents lists the entries of an archive. It returns 0 when entries
+// were listed, 1 when the patterns matched nothing and 2 on errors, so
+// scripts can check whether a document made it into a backup.
+func runListContents(cfg *config.Config, args []string) int {
+	flags := flag.NewFlagSet("list-contents", flag.ContinueOnError)
+	jsonOutput := flags.Bool("json", false, "print entries as JSON")
+	identityFile := flags.String("identity", cfg.EncryptionIdentityFile, "age identity file for encrypted archives")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() < 1 {
+		usage()
+		return 2
+	}
+
+	archivePath, err := backup.ResolveBackup(cfg.BackupDir, flags.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 2
+	}
+
+	encryptor, err := archive.NewEncryptor(cfg.EncryptionRecipients, cfg.EncryptionPassphraseFile, *identityFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 2
+	}
+
+	patterns := flags.Args()[1:]
+	entries, err := archive.List(archivePath, encryptor, patterns)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 2
+	}
+
+	if *jsonOutput {
+		if entries == nil {
+			entries = []*archive.Entry{}
+		}
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(entries); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 2
+		}
+	} else {
+		printEntries(entries)
+	}
+
+	if len(entries) == 0 && len(patterns) > 0 {
+		return 1
+	}
+	return 0
+}
+
+// printEntries prints entries in the style of `tar -tv`
+func printEntries(entries []*archive.Entry) {
+	var files int
+	var bytes int64
+	for _, entry := range entries {
+		line := fmt.Sprintf("%s %12d  ", modeString(entry), entry.Size)
+		if !entry.ModTime.IsZero() {
+			line += entry.ModTime.Local().Format("2006-01-02 15:04") + "  "
+		}
+		line += entry.Path
+
+		switch entry.Type {
+		case archive.EntrySymlink:
+			line += " -> " + entry.Linkname
+		case archive.EntryHardlink:
+			line += " link to " + entry.Linkname
+		case archive.EntryFile:
+			files++
+			bytes += entry.Size
+		}
+		fmt.Println(line)
+	}
+
+	fmt.Printf("%d entries, %d files (%.2fMB)\n", len(entries), files, float64(bytes)/1024/1024)
+}
+
+// modeString formats the type and permission bits like ls
+func modeString(entry *archive.Entry) string {
+	typeChar := map[string]string{
+		archive.EntryFile:     "-",
+		archive.EntryDir:      "d",
+		archive.EntrySymlink:  "l",
+		archive.EntryHardlink: "h",
+	}[entry.Type]
+	if typeChar == "" {
+		typeChar = "?"
+	}
+	return typeChar + entry.Mode.Perm().String()[1:]
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"paperless-backup/internal/backup"
+	"paperless-backup/internal/config"
+)
+
+// allowDirectEnv lets the backup run outside of systemd for testing
+const allowDirectEnv = "PAPERLESS_BACKUP_ALLOW_DIRECT"
+
+func main() {
+	cfg := config.Default()
+
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "list-contents":
+			os.Exit(runListContents(cfg, os.Args[2:]))
+		case "extract":
+			os.Exit(runExtract(cfg, os.Args[2:]))
+		case "show":
+			os.Exit(runShow(cfg, os.Args[2:]))
+		case "diff":
+			os.Exit(runDiff(cfg, os.Args[2:]))
+		case "repair":
+			os.Exit(runRepair(cfg, os.Args[2:]))
+		case "snapshots":
+			os.Exit(runSnapshots(cfg, os.Args[2:]))
+		case "restore":
+			os.Exit(runRestore(cfg, os.Args[2:]))
+		case "help", "-h", "--help":
+			usage()
+			return
+		default:
+			fmt.Fprintf(os.Stderr, "Unknown command %q\n\n", os.Args[1])
+			usage()
+			os.Exit(2)
+		}
+	}
+
+	os.Exit(runBackup(cfg))
+}
+
+// usage prints the available commands
+func usage() {
+	fmt.Fprintf(os.Stderr, `Usage:
+  paperless-backup                  Run a backup (root, started by systemd)
+  paperless-backup list-contents [-json] [-identity file] <archive|latest> [pattern...]
+                                    List the entries of a backup archive
+  paperless-backup extract [-dry-run] [-overwrite never|skip|always] [-owner] [-identity file]
+                           <archive|latest> <destination> [pattern...]
+                                    Safely restore an archive into a directory
+  paperless-backup show [-json] [-complete] [-identity file] <archive|latest>
+                                    Show how and from what an archive was written
+  paperless-backup diff [-json] [-identity file] <archive|latest> <archive|latest|live> [pattern...]
+                                    Compare two backups, or a backup and the live volumes
+  paperless-backup repair [-check] <archive|latest>
+                                    Repair a damaged archive from its parity data
+  paperless-backup snapshots [-json] [snapshot|latest [pattern...]]
+                                    List repository snapshots, or the entries of one
+  paperless-backup restore [-owner] <snapshot|latest> <destination> [pattern...]
+                                    Restore a repository snapshot into a directory
+`)
+}
+
+// runBackup performs a complete backup run and returns its exit code
+func runBackup(cfg *config.Config) int {
+	if os.Geteuid() != 0 {
+		fmt.Fprintln(os.Stderr, "Error: This program must be run as root")
+		return backup.ExitConfig
+	}
+
+	// Only run when invoked by systemd, unless explicitly overridden
+	if os.Getenv("INVOCATION_ID") == "" && os.Getenv(allowDirectEnv) != "1" {
+		fmt.Fprintln(os.Stderr, "Error: This program must be run by systemd (systemctl start paperless-backup.service)")
+		fmt.Fprintf(os.Stderr, "Set %s=1 to run it directly for testing\n", allowDirectEnv)
+		return backup.ExitConfig
+	}
+
+	// Stopping the unit, a shutdown or Ctrl-C cancel the run, which then
+	// rolls back. Further signals are ignored until it has.
+	ctx, cancel := context.WithCancelCause(context.Background())
+	defer cancel(nil)
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT, syscall.SIGHUP)
+	defer signal.Stop(signals)
+	go func() {
+		for sig := range signals {
+			cancel(fmt.Errorf("%w (signal %s)", backup.ErrInterrupted, sig))
+		}
+	}()
+
+	b, err := backup.New(cfg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return backup.ExitConfig
+	}
+
+	if err := b.Setup(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		b.Cleanup()
+		return backup.ExitConfig
+	}
+
+	return b.Execute(ctx)
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"paperless-backup/internal/archive"
+	"paperless-backup/internal/backup"
+	"paperless-backup/internal/config"
+)
+
+// runRepair checks a backup against its parity data and reconstructs
+// damaged blocks. Exit codes: 0 intact or repaired, 1 damage remains or
+// the backup has no parity data, 2 errors.
+func runRepair(cfg *config.Config, args []string) int {
+	flags := flag.NewFlagSet("repair", flag.ContinueOnError)
+	checkOnly := flags.Bool("check", false, "only report damage, change nothing")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() != 1 {
+		usage()
+		return 2
+	}
+
+	backupPath, err := backup.ResolveBackup(cfg.BackupDir, flags.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 2
+	}
+
+	var reports []*archive.ParityReport
+	if *checkOnly {
+		reports, err = archive.CheckParity(backupPath)
+	} else {
+		reports, err = archive.RepairParity(backupPath)
+	}
+	if errors.Is(err, archive.ErrNoParity) {
+		fmt.Fprintf(os.Stderr, "Error: %s was written without parity data\n", backupPath)
+		return 1
+	}
+	if err != nil && !errors.Is(err, archive.ErrUnrepairable) {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 2
+	}
+
+	damaged := false
+	for _, report := range reports {
+		printParityReport(report, *checkOnly)
+		damaged = damaged || !report.OK()
+	}
+	if damaged {
+		return 1
+	}
+	return 0
+}
+
+// printParityReport prints the state of one file of a backup
+func printParityReport(report *archive.ParityReport, checkOnly bool) {
+	name := filepath.Base(report.Path)
+	switch {
+	case report.OK() && report.Repaired:
+		fmt.Printf("%s: repaired (%d blocks)\n", name, report.Blocks)
+	case report.OK():
+		fmt.Printf("%s: OK (%d blocks)\n", name, report.Blocks)
+	case !report.Repairable():
+		fmt.Printf("%s: %s, %d stripes beyond repair\n", name, damageSummary(report), report.Unrepairable)
+	case checkOnly:
+		fmt.Printf("%s: %s, repairable\n", name, damageSummary(report))
+	default:
+		fmt.Printf("%s: %s remain\n", name, damageSummary(report))
+	}
+}
+
+// damageSummary describes the damage found in a file and its parity data
+func damageSummary(report *archive.ParityReport) string {
+	summary := fmt.Sprintf("%d damaged blocks, %d damaged parity blocks", report.Damaged, report.DamagedParity)
+	if report.DamagedMeta > 0 {
+		summary += fmt.Sprintf(", %d damaged header or hash table copies", report.DamagedMeta)
+	}
+	return summary
+}
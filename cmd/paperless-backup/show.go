@@ -0,0 +1,123 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"paperless-backup/internal/archive"
+	"paperless-backup/internal/backup"
+	"paperless-backup/internal/config"
+)
+
+// runShow prints the metadata embedded in an archive without extracting it
+func runShow(cfg *config.Config, args []string) int {
+	flags := flag.NewFlagSet("show", flag.ContinueOnError)
+	jsonOutput := flags.Bool("json", false, "print the metadata as JSON")
+	complete := flags.Bool("complete", false, "read the whole archive for the end time")
+	identityFile := flags.String("identity", cfg.EncryptionIdentityFile, "age identity file for encrypted archives")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() != 1 {
+		usage()
+		return 2
+	}
+
+	archivePath, err := backup.ResolveBackup(cfg.BackupDir, flags.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 2
+	}
+
+	encryptor, err := archive.NewEncryptor(cfg.EncryptionRecipients, cfg.EncryptionPassphraseFile, *identityFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 2
+	}
+
+	metadata, err := archive.ReadMetadata(archivePath, encryptor, *complete)
+	if errors.Is(err, archive.ErrNoMetadata) {
+		fmt.Fprintf(os.Stderr, "Error: %s was written by an older version without metadata\n", archivePath)
+		return 1
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 2
+	}
+
+	if *jsonOutput {
+		if err := printJSON(metadata); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 2
+		}
+		return 0
+	}
+	printMetadata(archivePath, metadata)
+	return 0
+}
+
+// printMetadata prints archive metadata for humans
+func printMetadata(archivePath string, m *archive.Metadata) {
+	fmt.Printf("Archive:     %s\n", archivePath)
+	// The host and run times are only recorded with PreserveMetadata
+	if m.Hostname != "" {
+		fmt.Printf("Written by:  paperless-backup %s on %s\n", m.Tool, m.Hostname)
+	} else {
+		fmt.Printf("Written by:  paperless-backup %s\n", m.Tool)
+	}
+	if !m.Started.IsZero() {
+		fmt.Printf("Started:     %s\n", m.Started.Local().Format(time.RFC3339))
+	}
+	if c := m.Completion; c != nil && !c.Finished.IsZero() {
+		fmt.Printf("Finished:    %s (%s, %d files, %d warnings)\n",
+			c.Finished.Local().Format(time.RFC3339), c.Finished.Sub(m.Started).Round(time.Second), c.Files, c.Warnings)
+	} else if c != nil {
+		fmt.Printf("Finished:    %d files, %d warnings\n", c.Files, c.Warnings)
+	}
+
+	compression := m.Compression.Codec
+	if m.Compression.Level != 0 {
+		compression += fmt.Sprintf(", level %d", m.Compression.Level)
+	}
+	fmt.Printf("Compression: %s, %d workers\n", compression, m.Compression.Workers)
+	switch {
+	case m.Encryption == nil:
+		fmt.Println("Encryption:  none")
+	case len(m.Encryption.Recipients) > 0:
+		fmt.Printf("Encryption:  age (%s: %s)\n", m.Encryption.Mode, strings.Join(m.Encryption.Recipients, ", "))
+	default:
+		fmt.Printf("Encryption:  age (%s)\n", m.Encryption.Mode)
+	}
+	if m.PartSize > 0 {
+		fmt.Printf("Split:       parts of %.2fMB\n", float64(m.PartSize)/1024/1024)
+	}
+	if c := m.Container; c != nil {
+		fmt.Printf("Container:   %s (%s", c.Name, c.Image)
+		if c.Digest != "" {
+			fmt.Printf(", %s", c.Digest)
+		}
+		fmt.Println(")")
+	}
+
+	fmt.Println("Sources:")
+	for _, source := range m.Sources {
+		line := fmt.Sprintf("  %-8s %s", source.Name, source.Mountpoint)
+		if source.Base != "" {
+			line += ", incremental on " + source.Base
+		}
+		if len(source.Include) > 0 {
+			line += ", include " + strings.Join(source.Include, " ")
+		}
+		if len(source.Exclude) > 0 {
+			line += ", exclude " + strings.Join(source.Exclude, " ")
+		}
+		if source.ReadErrors != "" {
+			line += ", " + source.ReadErrors
+		}
+		fmt.Println(line)
+	}
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"paperless-backup/internal/archive"
+	"paperless-backup/internal/config"
+	"paperless-backup/internal/repository"
+)
+
+// openRepository opens the configured repository without creating it
+func openRepository(cfg *config.Config) (*repository.Repository, error) {
+	path := filepath.Join(cfg.BackupDir, cfg.RepositoryDir)
+	if _, err := os.Stat(path); err != nil {
+		return nil, fmt.Errorf("no repository at %s: %w", path, err)
+	}
+	return repository.Open(path, repository.Options{})
+}
+
+// runSnapshots lists the snapshots of the repository, or the entries of one
+// snapshot with the exit codes of list-contents
+func runSnapshots(cfg *config.Config, args []string) int {
+	flags := flag.NewFlagSet("snapshots", flag.ContinueOnError)
+	jsonOutput := flags.Bool("json", false, "print as JSON")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	repo, err := openRepository(cfg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 2
+	}
+
+	if flags.NArg() == 0 {
+		if err := printSnapshots(repo, *jsonOutput); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 2
+		}
+		return 0
+	}
+
+	id, err := repo.ResolveSnapshot(flags.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 2
+	}
+	patterns := flags.Args()[1:]
+	entries, err := repo.List(id, patterns)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 2
+	}
+
+	if *jsonOutput {
+		if entries == nil {
+			entries = []*archive.Entry{}
+		}
+		if err := printJSON(entries); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 2
+		}
+	} else {
+		printEntries(entries)
+	}
+
+	if len(entries) == 0 && len(patterns) > 0 {
+		return 1
+	}
+	return 0
+}
+
+// printSnapshots prints one line per snapshot, oldest first
+func printSnapshots(repo *repository.Repository, jsonOutput bool) error {
+	ids, err := repo.Snapshots()
+	if err != nil {
+		return err
+	}
+
+	snapshots := []*repository.Snapshot{}
+	for _, id := range ids {
+		snapshot, err := repo.LoadSnapshot(id)
+		if err != nil {
+			return err
+		}
+		snapshots = append(snapshots, snapshot)
+	}
+
+	if jsonOutput {
+		type snapshotJSON struct {
+			ID string `json:"id"`
+			*repository.Snapshot
+		}
+		out := []snapshotJSON{}
+		for _, snapshot := range snapshots {
+			out = append(out, snapshotJSON{ID: snapshot.ID, Snapshot: snapshot})
+		}
+		return printJSON(out)
+	}
+
+	for _, snapshot := range snapshots {
+		total := snapshot.Total()
+		fmt.Printf("%s  %s  %8d files  %10.2fMB  %8.2fMB added\n", snapshot.ID,
+			snapshot.Time.Local().Format("2006-01-02 15:04"), total.Files,
+			float64(total.Bytes)/1024/1024, float64(total.StoredBytes)/1024/1024)
+	}
+	fmt.Printf("%d snapshots\n", len(snapshots))
+	return nil
+}
+
+// printJSON writes indented JSON to stdout
+func printJSON(value any) error {
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(value)
+}
+
+// runRestore restores a snapshot of the repository into a directory
+func runRestore(cfg *config.Config, args []string) int {
+	flags := flag.NewFlagSet("restore", flag.ContinueOnError)
+	restoreOwner := flags.Bool("owner", os.Geteuid() == 0, "restore ownership (default when run as root)")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() < 2 {
+		usage()
+		return 2
+	}
+
+	repo, err := openRepository(cfg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 2
+	}
+	id, err := repo.ResolveSnapshot(flags.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 2
+	}
+
+	result, err := repo.Restore(id, flags.Arg(1), repository.RestoreOptions{
+		Patterns:     flags.Args()[2:],
+		RestoreOwner: *restoreOwner,
+	})
+	if result != nil {
+		for _, warning := range result.Warnings {
+			fmt.Fprintf(os.Stderr, "Warning: %s\n", warning)
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 2
+	}
+
+	fmt.Printf("Restored snapshot %s: %d files (%.2fMB), %d directories, %d symlinks\n",
+		id, result.Files, float64(result.Bytes)/1024/1024, result.Dirs, result.Symlinks)
+	return 0
+}
@@ -2,4 +2,15 @@ module paperless-backup
 
 go 1.21
 
-require golang.org/x/sys v0.26.0
+require (
+	filippo.io/age v1.2.1
+	github.com/klauspost/compress v1.17.11
+	github.com/klauspost/reedsolomon v1.12.4
+	github.com/ulikunitz/xz v0.5.12
+	golang.org/x/sys v0.26.0
+)
+
+require (
+	github.com/klauspost/cpuid/v2 v2.2.8 // indirect
+	golang.org/x/crypto v0.24.0 // indirect
+)
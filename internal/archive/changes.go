@@ -0,0 +1,215 @@
+package archive
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"syscall"
+)
+
+// Read error policies for files that vanish or change while a source is
+// being archived
+const (
+	ReadErrorsStrict   = "strict"   // Fail the backup
+	ReadErrorsTolerant = "tolerant" // Skip vanished files, re-read or mark changed ones
+)
+
+// Problems recorded in warnings
+const (
+	ProblemVanished = "vanished before it was read"
+	ProblemReread   = "changed while being read, stored after re-reading"
+	ProblemChanged  = "changed while being read, stored content may be inconsistent"
+)
+
+// WarningsName is the archive path of the warnings of a tolerant backup. It
+// is only written when there are warnings.
+const WarningsName = MetaDir + "/warnings.json"
+
+// ErrSourceChanged is returned in strict mode for a file that changed while
+// it was being read
+var ErrSourceChanged = errors.New("file changed while being read")
+
+// Files up to rereadLimit are read into memory in tolerant mode, so they can
+// be read again until a consistent version is stored
+const (
+	rereadLimit    = 8 << 20
+	rereadAttempts = 3
+)
+
+// beforeReread is called before a changed file is read again, replaced in
+// tests
+var beforeReread = func(path string) {}
+
+// Warning is a path that was not archived as found by the walk
+type Warning struct {
+	Path    string `json:"path"` // Archive path
+	Problem string `json:"problem"`
+}
+
+// ValidateReadErrors checks a read error policy, empty selects strict
+func ValidateReadErrors(policy string) error {
+	switch policy {
+	case "", ReadErrorsStrict, ReadErrorsTolerant:
+		return nil
+	}
+	return fmt.Errorf("unknown read error policy %q", policy)
+}
+
+// Tolerant reports whether vanished and changed files are tolerated
+func (s Source) Tolerant() bool {
+	return s.ReadErrors == ReadErrorsTolerant
+}
+
+// changedState is stored in snapshots for files whose content may be
+// inconsistent. It never matches a real file, so the next incremental
+// stores them again.
+var changedState = FileState{Size: -1}
+
+// Warnings returns the paths the last Create tolerated problems with
+func (c *Creator) Warnings() []Warning {
+	return c.warnings
+}
+
+// warn records a tolerated problem
+func (c *Creator) warn(name, problem string) {
+	c.progress.clear()
+	c.logger.Logf("WARN", "%s: %s", name, problem)
+	c.warnings = append(c.warnings, Warning{Path: name, Problem: problem})
+}
+
+// skipVanished tolerates an error for a path that no longer exists. The
+// root of a source must always exist.
+func (c *Creator) skipVanished(source Source, name string, err error) error {
+	if !source.Tolerant() || !errors.Is(err, fs.ErrNotExist) || name == source.Name {
+		return err
+	}
+	c.warn(name, ProblemVanished)
+	return nil
+}
+
+// fileChanged reports whether a file differs from the state it had when it
+// was walked
+func fileChanged(file *os.File, walked os.FileInfo) bool {
+	current, err := file.Stat()
+	if err != nil {
+		return true
+	}
+	return !SameVersion(walked, current)
+}
+
+// SameVersion reports whether two stats describe the same version of a
+// file, by size, mtime, inode and ctime
+func SameVersion(a, b os.FileInfo) bool {
+	if stateOf(a) != stateOf(b) {
+		return false
+	}
+	sa, okA := a.Sys().(*syscall.Stat_t)
+	sb, okB := b.Sys().(*syscall.Stat_t)
+	return !okA || !okB || sa.Ctim == sb.Ctim
+}
+
+// checkChanged applies the source's policy after a file was streamed.
+// short reports that the file ended before its walked size.
+func (c *Creator) checkChanged(source Source, name string, file *os.File, walked os.FileInfo, short bool, snapshot *Snapshot) error {
+	if !short && !fileChanged(file, walked) {
+		return nil
+	}
+	if !source.Tolerant() {
+		return fmt.Errorf("%s: %w", name, ErrSourceChanged)
+	}
+	c.warn(name, ProblemChanged)
+	snapshot.Files[name] = changedState
+	return nil
+}
+
+// readStable reads a small file until two stats around the read agree, so
+// a consistent version is stored. It returns the content and the stat it
+// belongs to.
+func (c *Creator) readStable(ctx context.Context, name string, file *os.File, snapshot *Snapshot) ([]byte, os.FileInfo, error) {
+	for attempt := 1; ; attempt++ {
+		before, err := file.Stat()
+		if err != nil {
+			return nil, nil, err
+		}
+		if _, err := file.Seek(0, io.SeekStart); err != nil {
+			return nil, nil, err
+		}
+		// Only the first read counts towards progress and the read limit,
+		// so a re-read file is not counted twice
+		var reader io.Reader = &contextReader{ctx: ctx, reader: file}
+		if attempt == 1 {
+			reader = c.sourceReader(ctx, file)
+		} else {
+			beforeReread(file.Name())
+		}
+		content, err := io.ReadAll(io.LimitReader(reader, rereadLimit+1))
+		if err != nil {
+			return nil, nil, err
+		}
+		after, err := file.Stat()
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if SameVersion(before, after) && int64(len(content)) == after.Size() {
+			if attempt > 1 {
+				c.warn(name, ProblemReread)
+			}
+			return content, after, nil
+		}
+		if attempt == rereadAttempts || len(content) > rereadLimit {
+			c.warn(name, ProblemChanged)
+			snapshot.Files[name] = changedState
+			return content, after, nil
+		}
+	}
+}
+
+// writeWarnings stores the warnings of a tolerant backup in the archive
+func (c *Creator) writeWarnings(tarWriter *tarStream) error {
+	if len(c.warnings) == 0 {
+		return nil
+	}
+	return writeJSONEntry(tarWriter, WarningsName, c.warnings)
+}
+
+// sizedReader yields exactly size bytes of a file that may change while it
+// is read. Growth is cut off; when the file ends early it either fails or,
+// with pad set, continues with zeros.
+type sizedReader struct {
+	reader    io.Reader
+	remaining int64
+	pad       bool
+	short     bool // The file ended before size bytes
+}
+
+func (r *sizedReader) Read(b []byte) (int, error) {
+	if r.remaining <= 0 {
+		return 0, io.EOF
+	}
+	if int64(len(b)) > r.remaining {
+		b = b[:r.remaining]
+	}
+
+	if !r.short {
+		n, err := r.reader.Read(b)
+		r.remaining -= int64(n)
+		if err == io.EOF && r.remaining > 0 {
+			r.short = true
+			if !r.pad {
+				return n, io.ErrUnexpectedEOF
+			}
+			err = nil
+		}
+		if n > 0 || err != nil || !r.short {
+			return n, err
+		}
+	}
+
+	clear(b)
+	r.remaining -= int64(len(b))
+	return len(b), nil
+}
@@ -0,0 +1,205 @@
+package archive
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"paperless-backup/internal/logger"
+)
+
+func TestSizedReader(t *testing.T) {
+	// Growth is cut off at the walked size
+	r := &sizedReader{reader: bytes.NewReader([]byte("grown content")), remaining: 5}
+	content, err := io.ReadAll(r)
+	if err != nil || string(content) != "grown" || r.short {
+		t.Errorf("Grown file read %q, %v", content, err)
+	}
+
+	// A shrunk file fails unless padding is allowed
+	r = &sizedReader{reader: bytes.NewReader([]byte("abc")), remaining: 6}
+	if _, err := io.ReadAll(r); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("Expected ErrUnexpectedEOF, got %v", err)
+	}
+
+	r = &sizedReader{reader: bytes.NewReader([]byte("abc")), remaining: 6, pad: true}
+	content, err = io.ReadAll(r)
+	if err != nil || !bytes.Equal(content, []byte("abc\x00\x00\x00")) || !r.short {
+		t.Errorf("Shrunk file read %q, %v, short %v", content, err, r.short)
+	}
+}
+
+func TestSkipVanished(t *testing.T) {
+	log, _ := logger.New(filepath.Join(t.TempDir(), "test.log"))
+	defer log.Close()
+
+	creator := New(log, Options{})
+	vanished := &fs.PathError{Op: "lstat", Path: "/volume/tmp.log", Err: fs.ErrNotExist}
+	denied := &fs.PathError{Op: "open", Path: "/volume/secret", Err: fs.ErrPermission}
+
+	strict := Source{Name: "data"}
+	if err := creator.skipVanished(strict, "data/tmp.log", vanished); err == nil {
+		t.Error("Strict sources should fail for vanished files")
+	}
+
+	tolerant := Source{Name: "data", ReadErrors: ReadErrorsTolerant}
+	if err := creator.skipVanished(tolerant, "data/tmp.log", vanished); err != nil {
+		t.Errorf("Tolerant sources should skip vanished files: %v", err)
+	}
+	if err := creator.skipVanished(tolerant, "data/secret", denied); err == nil {
+		t.Error("Only vanished files are tolerated")
+	}
+	if err := creator.skipVanished(tolerant, "data", vanished); err == nil {
+		t.Error("A missing source root is never tolerated")
+	}
+
+	warnings := creator.Warnings()
+	if len(warnings) != 1 || warnings[0] != (Warning{Path: "data/tmp.log", Problem: ProblemVanished}) {
+		t.Errorf("Unexpected warnings: %v", warnings)
+	}
+}
+
+// createChanging archives a source with one file that is modified by change
+// whenever the read of the file is throttled
+func createChanging(t *testing.T, readErrors string, size int, change func(path string)) (string, *Creator, error) {
+	t.Helper()
+
+	tmpDir := t.TempDir()
+	log, _ := logger.New(filepath.Join(tmpDir, "test.log"))
+	t.Cleanup(func() { log.Close() })
+
+	sourceDir := filepath.Join(tmpDir, "source")
+	os.MkdirAll(sourceDir, 0755)
+	filePath := filepath.Join(sourceDir, "paperless.log")
+	os.WriteFile(filePath, bytes.Repeat([]byte("x"), size), 0644)
+
+	// A burst of 1KB, every further read waits and changes the file
+	creator := New(log, Options{ReadLimit: 1 << 10, DeepVerify: true, ProgressInterval: time.Hour})
+	creator.limiter.sleep = func(time.Duration) { change(filePath) }
+
+	archivePath := filepath.Join(tmpDir, "backup.tar.gz")
+	err := creator.Create(context.Background(), archivePath, []Source{{Name: "data", Path: sourceDir, ReadErrors: readErrors}})
+	return archivePath, creator, err
+}
+
+func appendTo(path string) {
+	f, _ := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+	f.WriteString("new line\n")
+	f.Close()
+}
+
+func TestStrictFailsOnChangedFile(t *testing.T) {
+	_, _, err := createChanging(t, ReadErrorsStrict, 64<<10, appendTo)
+	if !errors.Is(err, ErrSourceChanged) {
+		t.Errorf("Expected ErrSourceChanged, got %v", err)
+	}
+}
+
+func TestTolerantRereadsChangedFile(t *testing.T) {
+	changed := false
+	archivePath, creator, err := createChanging(t, ReadErrorsTolerant, 64<<10, func(path string) {
+		if !changed {
+			appendTo(path)
+			changed = true
+		}
+	})
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+
+	warnings := creator.Warnings()
+	if len(warnings) != 1 || warnings[0].Problem != ProblemReread {
+		t.Errorf("Unexpected warnings: %v", warnings)
+	}
+
+	// The consistent second read is stored
+	dest := filepath.Join(t.TempDir(), "restore")
+	if _, err := Extract(archivePath, dest, ExtractOptions{}); err != nil {
+		t.Fatalf("Extract failed: %v", err)
+	}
+	content, _ := os.ReadFile(filepath.Join(dest, "data", "paperless.log"))
+	if len(content) != 64<<10+len("new line\n") {
+		t.Errorf("Stored %d bytes, expected the changed file", len(content))
+	}
+
+	// The snapshot matches the stored version
+	if state := creator.Snapshots()[0].Files["data/paperless.log"]; state.Size != int64(len(content)) {
+		t.Errorf("Snapshot state %+v does not match the stored file", state)
+	}
+
+	// The re-read is not counted as progress again
+	if done := creator.progress.doneBytes; done != int64(len(content)) {
+		t.Errorf("Progress counted %d bytes for a %d byte file", done, len(content))
+	}
+}
+
+func TestTolerantMarksUnsettledFile(t *testing.T) {
+	// A new mtime on every read never lets the file settle
+	mtime := time.Now()
+	touch := func(path string) {
+		mtime = mtime.Add(time.Second)
+		os.Chtimes(path, mtime, mtime)
+	}
+	beforeReread = touch
+	defer func() { beforeReread = func(string) {} }()
+	archivePath, creator, err := createChanging(t, ReadErrorsTolerant, 64<<10, touch)
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	if state := creator.Snapshots()[0].Files["data/paperless.log"]; state != changedState {
+		t.Errorf("Changed file should never match in the next incremental: %+v", state)
+	}
+
+	content := readEntry(t, archivePath, WarningsName)
+	if content == nil {
+		t.Fatal("Warnings should be stored in the archive")
+	}
+	var warnings []Warning
+	if err := json.Unmarshal(content, &warnings); err != nil || len(warnings) != 1 || warnings[0].Problem != ProblemChanged {
+		t.Errorf("Unexpected stored warnings %s: %v", content, err)
+	}
+}
+
+func TestTolerantPadsShrunkLargeFile(t *testing.T) {
+	// Files above the re-read limit are streamed once
+	size := rereadLimit + 1<<20
+	archivePath, creator, err := createChanging(t, ReadErrorsTolerant, size, func(path string) {
+		os.Truncate(path, 0)
+	})
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	warnings := creator.Warnings()
+	if len(warnings) != 1 || warnings[0].Problem != ProblemChanged {
+		t.Errorf("Unexpected warnings: %v", warnings)
+	}
+
+	entries, err := List(archivePath, nil, []string{"data/paperless.log"})
+	if err != nil || len(entries) != 1 || entries[0].Size != int64(size) {
+		t.Errorf("Shrunk file should keep its walked size: %v, %v", entries, err)
+	}
+}
+
+// readEntry returns the content of an archive entry, nil when it is missing
+func readEntry(t *testing.T, archivePath, name string) []byte {
+	t.Helper()
+	ar, err := openArchive(archivePath, nil)
+	if err != nil {
+		t.Fatalf("openArchive failed: %v", err)
+	}
+	defer ar.Close()
+	for header, err := ar.Next(); err == nil; header, err = ar.Next() {
+		if header.Name == name {
+			content, _ := io.ReadAll(ar)
+			return content
+		}
+	}
+	return nil
+}
@@ -0,0 +1,248 @@
+package archive
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+// Codec describes a compression format for the tar stream
+type Codec struct {
+	Name      string // Name used in the configuration
+	Extension string // File extension of archives using this codec
+	magic     []byte
+	newWriter func(w io.Writer, level int) (io.WriteCloser, error)
+	newReader func(r io.Reader) (io.ReadCloser, error)
+}
+
+var gzipCodec = &Codec{
+	Name:      "gzip",
+	Extension: ".tar.gz",
+	magic:     []byte{0x1f, 0x8b},
+	newWriter: func(w io.Writer, level int) (io.WriteCloser, error) {
+		if level == 0 {
+			level = gzip.DefaultCompression
+		}
+		gzWriter, err := gzip.NewWriterLevel(w, level)
+		if err != nil {
+			return nil, err
+		}
+		gzWriter.ModTime = time.Time{} // Zero time for reproducibility
+		return gzWriter, nil
+	},
+	newReader: func(r io.Reader) (io.ReadCloser, error) {
+		return gzip.NewReader(r)
+	},
+}
+
+var zstdCodec = &Codec{
+	Name:      "zstd",
+	Extension: ".tar.zst",
+	magic:     []byte{0x28, 0xb5, 0x2f, 0xfd},
+	newWriter: func(w io.Writer, level int) (io.WriteCloser, error) {
+		// Parallelism is handled by parallelWriter across all codecs
+		opts := []zstd.EOption{zstd.WithEncoderConcurrency(1)}
+		if level != 0 {
+			opts = append(opts, zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(level)))
+		}
+		return zstd.NewWriter(w, opts...)
+	},
+	newReader: func(r io.Reader) (io.ReadCloser, error) {
+		decoder, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return decoder.IOReadCloser(), nil
+	},
+}
+
+var xzCodec = &Codec{
+	Name:      "xz",
+	Extension: ".tar.xz",
+	magic:     []byte{0xfd, '7', 'z', 'X', 'Z', 0x00},
+	newWriter: func(w io.Writer, level int) (io.WriteCloser, error) {
+		// The xz encoder has no numeric presets, so the level is ignored
+		return xz.NewWriter(w)
+	},
+	newReader: func(r io.Reader) (io.ReadCloser, error) {
+		xzReader, err := xz.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return io.NopCloser(xzReader), nil
+	},
+}
+
+var noneCodec = &Codec{
+	Name:      "none",
+	Extension: ".tar",
+	newWriter: func(w io.Writer, level int) (io.WriteCloser, error) {
+		return nopWriteCloser{w}, nil
+	},
+	newReader: func(r io.Reader) (io.ReadCloser, error) {
+		return io.NopCloser(r), nil
+	},
+}
+
+// codecs lists all supported codecs, compressed formats before "none" so
+// extension matching prefers the most specific suffix
+var codecs = []*Codec{gzipCodec, zstdCodec, xzCodec, noneCodec}
+
+// nopWriteCloser adds a no-op Close to a writer
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
+
+// CodecByName returns the codec with the given configuration name. An empty
+// name selects gzip.
+func CodecByName(name string) (*Codec, error) {
+	if name == "" {
+		return gzipCodec, nil
+	}
+	for _, codec := range codecs {
+		if codec.Name == name {
+			return codec, nil
+		}
+	}
+	return nil, fmt.Errorf("unsupported compression %q", name)
+}
+
+// Extensions returns the file extensions of all supported codecs
+func Extensions() []string {
+	extensions := make([]string, len(codecs))
+	for i, codec := range codecs {
+		extensions[i] = codec.Extension
+	}
+	return extensions
+}
+
+// IsArchiveName reports whether a file name carries an archive extension,
+// optionally followed by the encryption extension
+func IsArchiveName(name string) bool {
+	name = strings.TrimSuffix(name, EncryptedExt)
+	for _, codec := range codecs {
+		if strings.HasSuffix(name, codec.Extension) && len(name) > len(codec.Extension) {
+			return true
+		}
+	}
+	return false
+}
+
+// NewWriter wraps w with the codec's compressor
+func (c *Codec) NewWriter(w io.Writer, level int) (io.WriteCloser, error) {
+	return c.newWriter(w, level)
+}
+
+// NewReader wraps r with the codec's decompressor
+func (c *Codec) NewReader(r io.Reader) (io.ReadCloser, error) {
+	return c.newReader(r)
+}
+
+// DetectCodec identifies the codec of a stream from its magic bytes without
+// consuming them
+func DetectCodec(r *bufio.Reader) (*Codec, error) {
+	for _, codec := range codecs {
+		if codec.magic == nil {
+			continue
+		}
+		head, _ := r.Peek(len(codec.magic))
+		if bytes.Equal(head, codec.magic) {
+			return codec, nil
+		}
+	}
+
+	// Uncompressed tar carries the ustar magic in its first header block
+	head, _ := r.Peek(512)
+	if len(head) == 512 && bytes.HasPrefix(head[257:], []byte("ustar")) {
+		return noneCodec, nil
+	}
+
+	return nil, fmt.Errorf("unknown archive format")
+}
+
+// archiveReader is an opened archive with autodetected decryption and
+// decompression
+type archiveReader struct {
+	*tar.Reader
+	codec     *Codec
+	encrypted bool
+	file      io.ReadCloser
+	decomp    io.ReadCloser
+}
+
+// openArchive opens an archive for reading, detecting encryption and codec.
+// Split archives are read across their parts.
+func openArchive(archivePath string, encryptor *Encryptor) (*archiveReader, error) {
+	file, err := openStream(archivePath)
+	if err != nil {
+		return nil, err
+	}
+	return newArchiveReader(file, encryptor)
+}
+
+// newArchiveReader reads an archive stream, detecting encryption and codec.
+// file is closed with the reader, or right away on failure.
+func newArchiveReader(file io.ReadCloser, encryptor *Encryptor) (*archiveReader, error) {
+	buffered := bufio.NewReader(file)
+	encrypted := isEncrypted(buffered)
+	if encrypted {
+		plaintext, err := encryptor.decrypt(buffered)
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+		buffered = bufio.NewReader(plaintext)
+	}
+
+	codec, err := DetectCodec(buffered)
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("backup integrity check failed: %w", err)
+	}
+
+	decomp, err := codec.NewReader(buffered)
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("backup integrity check failed (%s): %w", codec.Name, err)
+	}
+
+	return &archiveReader{
+		Reader:    tar.NewReader(decomp),
+		codec:     codec,
+		encrypted: encrypted,
+		file:      file,
+		decomp:    decomp,
+	}, nil
+}
+
+// finish reads the stream past the end of the tar data, so the codec's
+// trailing checksum, the authentication of the last encrypted chunk and the
+// digest of the last part of a split archive are checked too
+func (a *archiveReader) finish() error {
+	if _, err := io.Copy(io.Discard, a.Reader); err != nil {
+		return err
+	}
+	if _, err := io.Copy(io.Discard, a.decomp); err != nil {
+		return fmt.Errorf("backup integrity check failed (%s): %w", a.codec.Name, err)
+	}
+	if _, err := io.Copy(io.Discard, a.file); err != nil {
+		return fmt.Errorf("backup integrity check failed: %w", err)
+	}
+	return nil
+}
+
+// Close releases the decompressor and the underlying file
+func (a *archiveReader) Close() error {
+	a.decomp.Close()
+	return a.file.Close()
+}
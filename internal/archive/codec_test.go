@@ -0,0 +1,103 @@
+package archive
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"paperless-backup/internal/logger"
+)
+
+func TestCodecByName(t *testing.T) {
+	for _, name := range []string{"gzip", "zstd", "xz", "none"} {
+		codec, err := CodecByName(name)
+		if err != nil {
+			t.Errorf("CodecByName(%q) failed: %v", name, err)
+			continue
+		}
+		if codec.Name != name {
+			t.Errorf("CodecByName(%q) returned %s", name, codec.Name)
+		}
+	}
+
+	if codec, err := CodecByName(""); err != nil || codec != gzipCodec {
+		t.Error("Empty codec name should select gzip")
+	}
+
+	if _, err := CodecByName("lz4"); err == nil {
+		t.Error("CodecByName should reject unknown codecs")
+	}
+}
+
+func TestIsArchiveName(t *testing.T) {
+	tests := []struct {
+		name     string
+		expected bool
+	}{
+		{"20240101_030000.tar.gz", true},
+		{"20240101_030000.tar.zst", true},
+		{"20240101_030000.tar.xz", true},
+		{"20240101_030000.tar", true},
+		{"20240101_030000.tar.zst.age", true},
+		{"20240101_030000.tar.gz.sha256", false},
+		{"backup.log", false},
+		{".tar.gz", false},
+	}
+
+	for _, tt := range tests {
+		if got := IsArchiveName(tt.name); got != tt.expected {
+			t.Errorf("IsArchiveName(%q) = %v, want %v", tt.name, got, tt.expected)
+		}
+	}
+}
+
+func TestCodecRoundTrip(t *testing.T) {
+	tmpDir := t.TempDir()
+	logPath := filepath.Join(tmpDir, "test.log")
+
+	log, _ := logger.New(logPath)
+	defer log.Close()
+
+	sourceDir := filepath.Join(tmpDir, "source")
+	os.MkdirAll(sourceDir, 0755)
+	os.WriteFile(filepath.Join(sourceDir, "file1.txt"), bytes.Repeat([]byte("content "), 1000), 0644)
+
+	for _, codec := range codecs {
+		t.Run(codec.Name, func(t *testing.T) {
+			creator := New(log, Options{Codec: codec})
+			backupFile := filepath.Join(tmpDir, "backup"+creator.Extension())
+
+			if err := creator.Create(context.Background(), backupFile, []Source{{Name: "data", Path: sourceDir}}); err != nil {
+				t.Fatalf("Create failed: %v", err)
+			}
+
+			// Codec must be detected from content, not from the extension
+			file, _ := os.Open(backupFile)
+			detected, err := DetectCodec(bufio.NewReader(file))
+			file.Close()
+			if err != nil {
+				t.Fatalf("DetectCodec failed: %v", err)
+			}
+			if detected != codec {
+				t.Errorf("Detected %s, want %s", detected.Name, codec.Name)
+			}
+
+			result, err := creator.VerifyDeep(backupFile)
+			if err != nil {
+				t.Fatalf("VerifyDeep failed: %v", err)
+			}
+			if !result.OK() || result.Files != 1 {
+				t.Errorf("Unexpected verification result: %s", result.Summary())
+			}
+		})
+	}
+}
+
+func TestDetectCodecUnknown(t *testing.T) {
+	if _, err := DetectCodec(bufio.NewReader(bytes.NewReader([]byte("plain text")))); err == nil {
+		t.Error("DetectCodec should fail for unknown content")
+	}
+}
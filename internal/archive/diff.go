@@ -0,0 +1,326 @@
+package archive
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Change kinds reported by Diff
+const (
+	ChangeAdded    = "added"
+	ChangeRemoved  = "removed"
+	ChangeModified = "modified" // Type, content or symlink target differs
+	ChangeMetadata = "metadata" // Only mode or modification time differ
+)
+
+// Change describes a path that differs between two trees
+type Change struct {
+	Path   string   `json:"path"`
+	Kind   string   `json:"kind"`             // One of the Change* kinds
+	Fields []string `json:"fields,omitempty"` // What differs: type, content, size, mode, mtime, link
+	Old    *Entry   `json:"old,omitempty"`
+	New    *Entry   `json:"new,omitempty"`
+}
+
+// DiffSummary counts the changes below one source
+type DiffSummary struct {
+	Source       string `json:"source"`
+	Added        int    `json:"added"`
+	Removed      int    `json:"removed"`
+	Modified     int    `json:"modified"`
+	Metadata     int    `json:"metadata"`
+	AddedBytes   int64  `json:"added_bytes"`   // Size of added files
+	RemovedBytes int64  `json:"removed_bytes"` // Size of removed files
+}
+
+// DiffResult lists the changes between two trees by path, with a summary
+// per source
+type DiffResult struct {
+	Changes []*Change      `json:"changes"`
+	Sources []*DiffSummary `json:"sources"`
+}
+
+// Empty reports whether the trees are identical
+func (d *DiffResult) Empty() bool {
+	return len(d.Changes) == 0
+}
+
+// ReadTree returns the entries a backup restores to, given its restore
+// chain: the full backup first, then each incremental on top. Paths deleted
+// according to the incremental records are dropped, and hard links carry
+// the size of their target.
+func ReadTree(chain []string, encryptor *Encryptor, patterns []string) ([]*Entry, error) {
+	tree := make(map[string]*Entry)
+	for _, archivePath := range chain {
+		entries, records, err := list(archivePath, encryptor, patterns)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", filepath.Base(archivePath), err)
+		}
+		for _, record := range records {
+			for _, deleted := range record.Deleted {
+				delete(tree, deleted)
+			}
+		}
+
+		sizes := make(map[string]int64)
+		for _, entry := range entries {
+			if entry.Type == EntryFile {
+				sizes[entry.Path] = entry.Size
+			}
+		}
+		for _, entry := range entries {
+			if entry.Type == EntryHardlink {
+				if size, ok := sizes[entry.Linkname]; ok {
+					entry.Size = size
+				}
+			}
+			tree[entry.Path] = entry
+		}
+	}
+
+	entries := make([]*Entry, 0, len(tree))
+	for _, entry := range tree {
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// ScanTree returns the entries of the live sources as they would be
+// archived now, applying each source's filter. Content hashes are left
+// empty; DiffTree computes them where needed.
+func ScanTree(sources []Source, patterns []string) ([]*Entry, error) {
+	patterns, err := CleanPatterns(patterns)
+	if err != nil {
+		return nil, err
+	}
+	if err := ValidateSources(sources); err != nil {
+		return nil, err
+	}
+
+	var entries []*Entry
+	for _, source := range sources {
+		err := filepath.Walk(source.Path, func(filePath string, info os.FileInfo, err error) error {
+			// Files deleted while scanning are simply absent
+			if errors.Is(err, fs.ErrNotExist) && filePath != source.Path {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+
+			rel, err := filepath.Rel(source.Path, filePath)
+			if err != nil {
+				return err
+			}
+			rel = filepath.ToSlash(rel)
+			if rel != "." && !source.Filter.IsEmpty() {
+				switch source.Filter.decide(rel, info.IsDir()) {
+				case filterPrune:
+					return filepath.SkipDir
+				case filterSkip:
+					return nil
+				}
+			}
+
+			entry := &Entry{
+				Path:    path.Join(source.Name, rel),
+				Mode:    info.Mode().Perm(),
+				ModTime: info.ModTime(),
+			}
+			switch {
+			case info.Mode().IsRegular():
+				entry.Type = EntryFile
+				entry.Size = info.Size()
+				entry.hostPath = filePath
+			case info.IsDir():
+				entry.Type = EntryDir
+			case info.Mode()&os.ModeSymlink != 0:
+				entry.Type = EntrySymlink
+				if entry.Linkname, err = os.Readlink(filePath); err != nil && !errors.Is(err, fs.ErrNotExist) {
+					return err
+				}
+			default:
+				entry.Type = EntryOther
+			}
+			if MatchEntry(entry.Path, patterns) {
+				entries = append(entries, entry)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan %s: %w", source.Path, err)
+		}
+	}
+	return entries, nil
+}
+
+// DiffTree compares the entries of a backup with the live sources. Live
+// files are only hashed when their size matches the archived file, so an
+// unchanged tree is read once but changed sizes cost no reads.
+func DiffTree(old []*Entry, sources []Source, patterns []string) (*DiffResult, error) {
+	current, err := ScanTree(sources, patterns)
+	if err != nil {
+		return nil, err
+	}
+
+	archived := make(map[string]*Entry, len(old))
+	for _, entry := range old {
+		archived[entry.Path] = entry
+	}
+	for _, entry := range current {
+		before := archived[entry.Path]
+		if entry.Type != EntryFile || before == nil || before.SHA256 == "" || before.Size != entry.Size {
+			continue
+		}
+		if entry.SHA256, err = hashFile(entry.hostPath); errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return Diff(old, current), nil
+}
+
+// hashFile returns the hex SHA-256 of a file's content
+func hashFile(filePath string) (string, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", fmt.Errorf("failed to read %s: %w", filePath, err)
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// Diff compares two sets of entries by path. Content is compared by hash
+// when both entries carry one and by size otherwise; modification times
+// only when both sides recorded them.
+func Diff(old, current []*Entry) *DiffResult {
+	before := make(map[string]*Entry, len(old))
+	for _, entry := range old {
+		before[entry.Path] = entry
+	}
+	after := make(map[string]*Entry, len(current))
+	for _, entry := range current {
+		after[entry.Path] = entry
+	}
+
+	result := &DiffResult{Changes: []*Change{}, Sources: []*DiffSummary{}}
+	for p, entry := range before {
+		if after[p] == nil {
+			result.Changes = append(result.Changes, &Change{Path: p, Kind: ChangeRemoved, Old: entry})
+		}
+	}
+	for p, entry := range after {
+		previous := before[p]
+		if previous == nil {
+			result.Changes = append(result.Changes, &Change{Path: p, Kind: ChangeAdded, New: entry})
+			continue
+		}
+		if change := compareEntries(previous, entry); change != nil {
+			result.Changes = append(result.Changes, change)
+		}
+	}
+	sort.Slice(result.Changes, func(i, j int) bool {
+		return result.Changes[i].Path < result.Changes[j].Path
+	})
+
+	// Every source of either tree is summarized, unchanged ones as well
+	summaries := make(map[string]*DiffSummary)
+	for _, entries := range [][]*Entry{old, current} {
+		for _, entry := range entries {
+			source, _, _ := strings.Cut(entry.Path, "/")
+			if summaries[source] == nil {
+				summaries[source] = &DiffSummary{Source: source}
+				result.Sources = append(result.Sources, summaries[source])
+			}
+		}
+	}
+	sort.Slice(result.Sources, func(i, j int) bool {
+		return result.Sources[i].Source < result.Sources[j].Source
+	})
+	for _, change := range result.Changes {
+		source, _, _ := strings.Cut(change.Path, "/")
+		summary := summaries[source]
+		switch change.Kind {
+		case ChangeAdded:
+			summary.Added++
+			summary.AddedBytes += fileSize(change.New)
+		case ChangeRemoved:
+			summary.Removed++
+			summary.RemovedBytes += fileSize(change.Old)
+		case ChangeModified:
+			summary.Modified++
+		case ChangeMetadata:
+			summary.Metadata++
+		}
+	}
+	return result
+}
+
+// fileSize returns the size of file entries, 0 for everything else
+func fileSize(entry *Entry) int64 {
+	if entry.Type == EntryFile || entry.Type == EntryHardlink {
+		return entry.Size
+	}
+	return 0
+}
+
+// compareEntries returns the change between two versions of a path, or nil
+func compareEntries(old, current *Entry) *Change {
+	change := &Change{Path: old.Path, Old: old, New: current}
+
+	// Hard links are files that share their content with another name
+	oldType, currentType := old.Type, current.Type
+	if oldType == EntryHardlink {
+		oldType = EntryFile
+	}
+	if currentType == EntryHardlink {
+		currentType = EntryFile
+	}
+
+	switch {
+	case oldType != currentType:
+		change.Fields = append(change.Fields, "type")
+	case oldType == EntryFile:
+		if old.SHA256 != "" && current.SHA256 != "" && old.SHA256 != current.SHA256 {
+			change.Fields = append(change.Fields, "content")
+		}
+		if old.Size != current.Size {
+			change.Fields = append(change.Fields, "size")
+		}
+	case oldType == EntrySymlink && old.Linkname != current.Linkname:
+		change.Fields = append(change.Fields, "link")
+	}
+	if len(change.Fields) > 0 {
+		change.Kind = ChangeModified
+		return change
+	}
+
+	if old.Mode != current.Mode {
+		change.Fields = append(change.Fields, "mode")
+	}
+	if !old.ModTime.IsZero() && !current.ModTime.IsZero() && !old.ModTime.Equal(current.ModTime) {
+		change.Fields = append(change.Fields, "mtime")
+	}
+	if len(change.Fields) > 0 {
+		change.Kind = ChangeMetadata
+		return change
+	}
+	return nil
+}
@@ -0,0 +1,154 @@
+package archive
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"paperless-backup/internal/logger"
+)
+
+func TestDiff(t *testing.T) {
+	mtime := time.Date(2024, 1, 1, 3, 0, 0, 0, time.UTC)
+	old := []*Entry{
+		{Path: "data", Type: EntryDir, Mode: 0755},
+		{Path: "media", Type: EntryDir, Mode: 0755},
+		{Path: "media/kept.pdf", Type: EntryFile, Size: 4, Mode: 0644, SHA256: "aa"},
+		{Path: "media/removed.pdf", Type: EntryFile, Size: 7, Mode: 0644, SHA256: "bb"},
+		{Path: "media/rewritten.pdf", Type: EntryFile, Size: 3, Mode: 0644, SHA256: "cc"},
+		{Path: "media/grown.pdf", Type: EntryFile, Size: 3, Mode: 0644},
+		{Path: "media/chmod.pdf", Type: EntryFile, Size: 3, Mode: 0644, ModTime: mtime},
+		{Path: "media/link", Type: EntrySymlink, Linkname: "kept.pdf", Mode: 0777},
+		{Path: "media/hardlink.pdf", Type: EntryHardlink, Linkname: "media/kept.pdf", Size: 4, SHA256: "aa", Mode: 0644},
+	}
+	current := []*Entry{
+		{Path: "data", Type: EntryDir, Mode: 0755},
+		{Path: "media", Type: EntryDir, Mode: 0755},
+		{Path: "media/kept.pdf", Type: EntryFile, Size: 4, Mode: 0644, SHA256: "aa"},
+		{Path: "media/added.pdf", Type: EntryFile, Size: 5, Mode: 0644},
+		{Path: "media/rewritten.pdf", Type: EntryFile, Size: 3, Mode: 0644, SHA256: "dd"},
+		{Path: "media/grown.pdf", Type: EntryFile, Size: 9, Mode: 0644},
+		{Path: "media/chmod.pdf", Type: EntryFile, Size: 3, Mode: 0600, ModTime: mtime.Add(time.Second)},
+		{Path: "media/link", Type: EntrySymlink, Linkname: "other.pdf", Mode: 0777},
+		{Path: "media/hardlink.pdf", Type: EntryFile, Size: 4, SHA256: "aa", Mode: 0644},
+	}
+
+	result := Diff(old, current)
+	want := []struct {
+		path   string
+		kind   string
+		fields string
+	}{
+		{"media/added.pdf", ChangeAdded, ""},
+		{"media/chmod.pdf", ChangeMetadata, "mode mtime"},
+		{"media/grown.pdf", ChangeModified, "size"},
+		{"media/link", ChangeModified, "link"},
+		{"media/removed.pdf", ChangeRemoved, ""},
+		{"media/rewritten.pdf", ChangeModified, "content"},
+	}
+	if len(result.Changes) != len(want) {
+		t.Fatalf("Expected %d changes, got %d", len(want), len(result.Changes))
+	}
+	for i, w := range want {
+		change := result.Changes[i]
+		fields := ""
+		for j, field := range change.Fields {
+			if j > 0 {
+				fields += " "
+			}
+			fields += field
+		}
+		if change.Path != w.path || change.Kind != w.kind || fields != w.fields {
+			t.Errorf("Change %d = %s %s (%s), want %s %s (%s)", i, change.Kind, change.Path, fields, w.kind, w.path, w.fields)
+		}
+	}
+
+	if len(result.Sources) != 2 || *result.Sources[0] != (DiffSummary{Source: "data"}) {
+		t.Errorf("Unchanged sources should be summarized: %+v", result.Sources)
+	}
+	media := *result.Sources[1]
+	if media != (DiffSummary{Source: "media", Added: 1, Removed: 1, Modified: 3, Metadata: 1, AddedBytes: 5, RemovedBytes: 7}) {
+		t.Errorf("Unexpected summary: %+v", media)
+	}
+
+	if !Diff(old, old).Empty() {
+		t.Error("Identical trees should have no changes")
+	}
+}
+
+func TestDiffAcrossIncrementalChain(t *testing.T) {
+	tmpDir := t.TempDir()
+	log, _ := logger.New(filepath.Join(tmpDir, "test.log"))
+	defer log.Close()
+
+	sourceDir := filepath.Join(tmpDir, "source")
+	os.MkdirAll(filepath.Join(sourceDir, "documents"), 0755)
+	os.WriteFile(filepath.Join(sourceDir, "documents", "kept.pdf"), []byte("kept"), 0644)
+	os.WriteFile(filepath.Join(sourceDir, "documents", "changed.pdf"), []byte("old"), 0644)
+	os.WriteFile(filepath.Join(sourceDir, "documents", "deleted.pdf"), []byte("deleted"), 0644)
+	os.WriteFile(filepath.Join(sourceDir, "documents", "skipped.tmp"), []byte("tmp"), 0644)
+	sources := []Source{{Name: "media", Path: sourceDir, Filter: Filter{Exclude: []string{"**/*.tmp"}}}}
+
+	// Modification times are compared as well when preserved
+	creator := New(log, Options{PreserveMetadata: true})
+	fullFile := filepath.Join(tmpDir, "full.tar.gz")
+	if err := creator.Create(context.Background(), fullFile, sources); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	base := creator.Snapshots()[0]
+	base.Archive = filepath.Base(fullFile)
+
+	// The archive matches the tree it was written from
+	full, err := ReadTree([]string{fullFile}, nil, nil)
+	if err != nil {
+		t.Fatalf("ReadTree failed: %v", err)
+	}
+	result, err := DiffTree(full, sources, nil)
+	if err != nil || !result.Empty() {
+		t.Fatalf("Fresh backup should match the live tree: %+v, %v", result, err)
+	}
+
+	// Same size, different content is only found by hash
+	os.WriteFile(filepath.Join(sourceDir, "documents", "changed.pdf"), []byte("new"), 0644)
+	os.Remove(filepath.Join(sourceDir, "documents", "deleted.pdf"))
+	os.WriteFile(filepath.Join(sourceDir, "documents", "added.pdf"), []byte("added"), 0644)
+
+	pdfs, err := ReadTree([]string{fullFile}, nil, []string{"**/*.pdf"})
+	if err != nil {
+		t.Fatalf("ReadTree failed: %v", err)
+	}
+	result, err = DiffTree(pdfs, sources, []string{"**/*.pdf"})
+	if err != nil {
+		t.Fatalf("DiffTree failed: %v", err)
+	}
+	kinds := make(map[string]string)
+	for _, change := range result.Changes {
+		kinds[change.Path] = change.Kind
+	}
+	if len(kinds) != 3 || kinds["media/documents/changed.pdf"] != ChangeModified ||
+		kinds["media/documents/deleted.pdf"] != ChangeRemoved || kinds["media/documents/added.pdf"] != ChangeAdded {
+		t.Errorf("Unexpected changes: %v", kinds)
+	}
+
+	incrementalFile := filepath.Join(tmpDir, "incremental.tar.gz")
+	sources[0].Base = base
+	if err := creator.Create(context.Background(), incrementalFile, sources); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+
+	// The incremental alone holds only the changes, its chain the full tree
+	incremental, err := ReadTree([]string{fullFile, incrementalFile}, nil, nil)
+	if err != nil {
+		t.Fatalf("ReadTree failed: %v", err)
+	}
+	if result, err := DiffTree(incremental, sources, nil); err != nil || !result.Empty() {
+		t.Errorf("Incremental chain should match the live tree: %+v, %v", result.Changes, err)
+	}
+	// The directory changed with its entries
+	summary := Diff(full, incremental).Sources[0]
+	if summary.Added != 1 || summary.Removed != 1 || summary.Modified != 1 || summary.Metadata != 1 {
+		t.Errorf("Unexpected changes between the backups: %+v", summary)
+	}
+}
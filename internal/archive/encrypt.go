@@ -0,0 +1,137 @@
+package archive
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"filippo.io/age"
+)
+
+// EncryptedExt is appended to the codec extension of encrypted archives
+const EncryptedExt = ".age"
+
+// ageMagic starts every binary age file
+var ageMagic = []byte("age-encryption.org/v1\n")
+
+// ErrMissingKey is returned when an encrypted archive is read without a
+// configured decryption key
+var ErrMissingKey = errors.New("archive is encrypted but no decryption key is configured")
+
+// Encryptor holds the keys for streaming authenticated encryption (age) of
+// archives, applied after compression
+type Encryptor struct {
+	mode       string // "recipients" or "passphrase"
+	recipients []age.Recipient
+	identities []age.Identity
+}
+
+// NewEncryptor loads encryption keys. Public key recipients let the backup
+// host encrypt without holding the decryption key; identityFile is then only
+// needed where archives are verified or restored. A passphrase file enables
+// symmetric scrypt mode instead. Returns nil when encryption is not
+// configured.
+func NewEncryptor(recipients []string, passphraseFile, identityFile string) (*Encryptor, error) {
+	if len(recipients) == 0 && passphraseFile == "" {
+		if identityFile != "" {
+			return nil, fmt.Errorf("encryption identity file set without recipients")
+		}
+		return nil, nil
+	}
+
+	if len(recipients) > 0 && passphraseFile != "" {
+		return nil, fmt.Errorf("encryption recipients and passphrase are mutually exclusive")
+	}
+
+	if passphraseFile != "" {
+		content, err := os.ReadFile(passphraseFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read passphrase file: %w", err)
+		}
+		passphrase := strings.TrimRight(string(content), "\r\n")
+		if passphrase == "" {
+			return nil, fmt.Errorf("passphrase file %s is empty", passphraseFile)
+		}
+
+		recipient, err := age.NewScryptRecipient(passphrase)
+		if err != nil {
+			return nil, fmt.Errorf("invalid passphrase: %w", err)
+		}
+		identity, err := age.NewScryptIdentity(passphrase)
+		if err != nil {
+			return nil, fmt.Errorf("invalid passphrase: %w", err)
+		}
+
+		return &Encryptor{
+			mode:       "passphrase",
+			recipients: []age.Recipient{recipient},
+			identities: []age.Identity{identity},
+		}, nil
+	}
+
+	e := &Encryptor{mode: "recipients"}
+	for _, r := range recipients {
+		recipient, err := age.ParseX25519Recipient(strings.TrimSpace(r))
+		if err != nil {
+			return nil, fmt.Errorf("invalid encryption recipient %q: %w", r, err)
+		}
+		e.recipients = append(e.recipients, recipient)
+	}
+
+	if identityFile != "" {
+		file, err := os.Open(identityFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open identity file: %w", err)
+		}
+		defer file.Close()
+
+		if e.identities, err = age.ParseIdentities(file); err != nil {
+			return nil, fmt.Errorf("failed to parse identity file: %w", err)
+		}
+	}
+
+	return e, nil
+}
+
+// Mode returns "recipients" for public key or "passphrase" for scrypt mode
+func (e *Encryptor) Mode() string {
+	return e.mode
+}
+
+// CanDecrypt reports whether a decryption key is available
+func (e *Encryptor) CanDecrypt() bool {
+	return e != nil && len(e.identities) > 0
+}
+
+// encrypt wraps w so everything written is encrypted to the recipients
+func (e *Encryptor) encrypt(w io.Writer) (io.WriteCloser, error) {
+	return age.Encrypt(w, e.recipients...)
+}
+
+// isEncrypted reports whether a stream starts with the age header
+func isEncrypted(r *bufio.Reader) bool {
+	head, _ := r.Peek(len(ageMagic))
+	return bytes.Equal(head, ageMagic)
+}
+
+// decrypt returns the authenticated plaintext of an age stream. Tampering
+// surfaces as a read error once the affected chunk is reached.
+func (e *Encryptor) decrypt(r io.Reader) (io.Reader, error) {
+	if !e.CanDecrypt() {
+		return nil, ErrMissingKey
+	}
+
+	plaintext, err := age.Decrypt(r, e.identities...)
+	if err != nil {
+		var noMatch *age.NoIdentityMatchError
+		if errors.As(err, &noMatch) {
+			return nil, fmt.Errorf("no configured decryption key matches the archive: %w", err)
+		}
+		return nil, fmt.Errorf("failed to decrypt archive: %w", err)
+	}
+	return plaintext, nil
+}
@@ -0,0 +1,190 @@
+package archive
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"filippo.io/age"
+
+	"paperless-backup/internal/logger"
+)
+
+// createEncryptedArchive writes an encrypted archive of a small source tree
+func createEncryptedArchive(t *testing.T, tmpDir string, log *logger.Logger, encryptor *Encryptor) string {
+	t.Helper()
+
+	sourceDir := filepath.Join(tmpDir, "source")
+	os.MkdirAll(sourceDir, 0755)
+	os.WriteFile(filepath.Join(sourceDir, "tax.pdf"), bytes.Repeat([]byte("secret "), 2000), 0644)
+
+	creator := New(log, Options{Encryptor: encryptor})
+	backupFile := filepath.Join(tmpDir, "backup"+creator.Extension())
+	if err := creator.Create(context.Background(), backupFile, []Source{{Name: "media", Path: sourceDir}}); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	return backupFile
+}
+
+func TestEncryptionPassphrase(t *testing.T) {
+	tmpDir := t.TempDir()
+	logPath := filepath.Join(tmpDir, "test.log")
+
+	log, _ := logger.New(logPath)
+	defer log.Close()
+
+	passphraseFile := filepath.Join(tmpDir, "passphrase")
+	os.WriteFile(passphraseFile, []byte("correct horse battery staple\n"), 0600)
+
+	encryptor, err := NewEncryptor(nil, passphraseFile, "")
+	if err != nil {
+		t.Fatalf("NewEncryptor failed: %v", err)
+	}
+	if encryptor.Mode() != "passphrase" || !encryptor.CanDecrypt() {
+		t.Fatal("Passphrase mode should be able to decrypt")
+	}
+
+	backupFile := createEncryptedArchive(t, tmpDir, log, encryptor)
+	if filepath.Ext(backupFile) != EncryptedExt {
+		t.Errorf("Expected %s extension, got %s", EncryptedExt, backupFile)
+	}
+
+	content, _ := os.ReadFile(backupFile)
+	if !bytes.HasPrefix(content, ageMagic) {
+		t.Error("Archive should start with the age header")
+	}
+	if bytes.Contains(content, []byte("secret")) {
+		t.Error("Archive should not contain plaintext")
+	}
+
+	creator := New(log, Options{Encryptor: encryptor})
+	result, err := creator.VerifyDeep(backupFile)
+	if err != nil {
+		t.Fatalf("VerifyDeep failed: %v", err)
+	}
+	if !result.OK() || result.Files != 1 {
+		t.Errorf("Unexpected verification result: %s", result.Summary())
+	}
+}
+
+func TestEncryptionRecipients(t *testing.T) {
+	tmpDir := t.TempDir()
+	logPath := filepath.Join(tmpDir, "test.log")
+
+	log, _ := logger.New(logPath)
+	defer log.Close()
+
+	identity, _ := age.GenerateX25519Identity()
+	recipients := []string{identity.Recipient().String()}
+
+	// The backup host only knows the public key
+	encryptOnly, err := NewEncryptor(recipients, "", "")
+	if err != nil {
+		t.Fatalf("NewEncryptor failed: %v", err)
+	}
+	if encryptOnly.CanDecrypt() {
+		t.Error("Recipient mode without identity should not be able to decrypt")
+	}
+
+	backupFile := createEncryptedArchive(t, tmpDir, log, encryptOnly)
+
+	// The archive was verified before encryption
+	logContent, _ := os.ReadFile(logPath)
+	if !strings.Contains(string(logContent), "Backup integrity check passed") {
+		t.Error("Archive should be verified while it is written")
+	}
+
+	err = New(log, Options{Encryptor: encryptOnly}).Verify(backupFile)
+	if !errors.Is(err, ErrMissingKey) {
+		t.Errorf("Expected ErrMissingKey, got %v", err)
+	}
+
+	// A stream failing verification is not accepted
+	verifier := New(log, Options{Encryptor: encryptOnly}).newStreamVerifier(context.Background())
+	verifier.Write(bytes.Repeat([]byte{0x1f, 0x8b, 0}, 1000))
+	if err := verifier.Close(); err == nil {
+		t.Error("A damaged stream should fail verification")
+	}
+
+	// A host holding the identity can verify the same archive
+	identityFile := filepath.Join(tmpDir, "identity.txt")
+	os.WriteFile(identityFile, []byte(identity.String()+"\n"), 0600)
+
+	withKey, err := NewEncryptor(recipients, "", identityFile)
+	if err != nil {
+		t.Fatalf("NewEncryptor failed: %v", err)
+	}
+	if err := New(log, Options{Encryptor: withKey}).Verify(backupFile); err != nil {
+		t.Errorf("Verify with identity failed: %v", err)
+	}
+
+	// A different identity must be rejected
+	other, _ := age.GenerateX25519Identity()
+	otherFile := filepath.Join(tmpDir, "other.txt")
+	os.WriteFile(otherFile, []byte(other.String()+"\n"), 0600)
+
+	wrongKey, _ := NewEncryptor(recipients, "", otherFile)
+	if err := New(log, Options{Encryptor: wrongKey}).Verify(backupFile); err == nil {
+		t.Error("Verify should fail with a non-matching identity")
+	}
+}
+
+func TestEncryptionDetectsTampering(t *testing.T) {
+	tmpDir := t.TempDir()
+	logPath := filepath.Join(tmpDir, "test.log")
+
+	log, _ := logger.New(logPath)
+	defer log.Close()
+
+	passphraseFile := filepath.Join(tmpDir, "passphrase")
+	os.WriteFile(passphraseFile, []byte("passphrase"), 0600)
+	encryptor, _ := NewEncryptor(nil, passphraseFile, "")
+
+	backupFile := createEncryptedArchive(t, tmpDir, log, encryptor)
+
+	// Flip a byte in the encrypted payload after the header
+	content, _ := os.ReadFile(backupFile)
+	content[len(content)-20] ^= 0xff
+	os.WriteFile(backupFile, content, 0600)
+
+	if err := New(log, Options{Encryptor: encryptor}).Verify(backupFile); err == nil {
+		t.Error("Verify should fail for a tampered archive")
+	}
+}
+
+func TestNewEncryptorValidation(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	if encryptor, err := NewEncryptor(nil, "", ""); encryptor != nil || err != nil {
+		t.Errorf("No configuration should disable encryption, got %v, %v", encryptor, err)
+	}
+
+	passphraseFile := filepath.Join(tmpDir, "passphrase")
+	os.WriteFile(passphraseFile, []byte("passphrase"), 0600)
+	identity, _ := age.GenerateX25519Identity()
+	emptyFile := filepath.Join(tmpDir, "empty")
+	os.WriteFile(emptyFile, []byte("\n"), 0600)
+
+	invalid := []struct {
+		name           string
+		recipients     []string
+		passphraseFile string
+		identityFile   string
+	}{
+		{"both modes", []string{identity.Recipient().String()}, passphraseFile, ""},
+		{"bad recipient", []string{"not-a-key"}, "", ""},
+		{"identity only", nil, "", passphraseFile},
+		{"empty passphrase", nil, emptyFile, ""},
+		{"missing passphrase file", nil, filepath.Join(tmpDir, "missing"), ""},
+	}
+
+	for _, tt := range invalid {
+		if _, err := NewEncryptor(tt.recipients, tt.passphraseFile, tt.identityFile); err == nil {
+			t.Errorf("%s: NewEncryptor should fail", tt.name)
+		}
+	}
+}
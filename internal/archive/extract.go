@@ -0,0 +1,668 @@
+package archive
+
+import (
+	"archive/tar"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// Overwrite policies for paths that already exist in the destination
+const (
+	OverwriteNever  = "never"  // Fail on the first existing path
+	OverwriteSkip   = "skip"   // Keep existing paths, extract the rest
+	OverwriteAlways = "always" // Replace existing files, merge into existing directories
+)
+
+// ErrUnsafePath is returned for entries that would write outside the
+// destination, through a symlink, or create a link pointing outside it
+var ErrUnsafePath = errors.New("unsafe path in archive")
+
+// ErrExists is returned when a path exists and the policy is OverwriteNever
+var ErrExists = errors.New("path already exists")
+
+// ExtractOptions controls Extract
+type ExtractOptions struct {
+	Encryptor    *Encryptor // Decrypts encrypted archives, nil for plaintext
+	Patterns     []string   // Only extract matching entries, see MatchEntry
+	DryRun       bool       // Check every entry and report actions without writing
+	Overwrite    string     // One of the Overwrite* policies, OverwriteNever when empty
+	RestoreOwner bool       // Restore uid/gid and setuid/setgid bits (requires root)
+}
+
+// ExtractAction is a planned or performed change to the destination
+type ExtractAction struct {
+	Path   string // Archive path
+	Action string // "create", "replace", "skip" or "delete"
+}
+
+// ExtractResult summarizes an extraction
+type ExtractResult struct {
+	Dirs      int
+	Files     int
+	Symlinks  int
+	Hardlinks int
+	Deleted   int // Paths removed by deletion records of incremental archives
+	Bytes     int64
+	Skipped   []string        // Existing paths kept and unsupported entries
+	Warnings  []string        // Metadata that could not be restored
+	Actions   []ExtractAction // Every change, only recorded in dry-run mode
+}
+
+// extractor holds the state of a single extraction
+type extractor struct {
+	root    string
+	options ExtractOptions
+	result  *ExtractResult
+	created map[string]string // Paths written by this run: "dir", "file" or "symlink"
+
+	// Directory metadata is applied after the content is extracted, so
+	// restrictive modes do not block writing into them
+	dirs map[string]*tar.Header
+}
+
+// Extract restores an archive below destination. Every write is confined to
+// the destination: absolute names, ".." components, writes through symlinks
+// and symlinks or hard links pointing outside the destination are refused
+// with ErrUnsafePath. Modes are restored, ownership with RestoreOwner. The
+// tool's own entries below MetaDir are not restored.
+func Extract(archivePath, destination string, options ExtractOptions) (*ExtractResult, error) {
+	return ExtractChain([]string{archivePath}, destination, options)
+}
+
+// ExtractChain restores a full backup followed by its incrementals, as
+// returned by backup.Chain. Later archives replace what earlier ones of the
+// chain restored and apply their deletion records; the overwrite policy
+// only applies to paths that existed before the restore.
+func ExtractChain(chain []string, destination string, options ExtractOptions) (*ExtractResult, error) {
+	switch options.Overwrite {
+	case "":
+		options.Overwrite = OverwriteNever
+	case OverwriteNever, OverwriteSkip, OverwriteAlways:
+	default:
+		return nil, fmt.Errorf("unknown overwrite policy %q", options.Overwrite)
+	}
+	if err := (Filter{Include: options.Patterns}).Validate(); err != nil {
+		return nil, err
+	}
+
+	root, err := filepath.Abs(destination)
+	if err != nil {
+		return nil, err
+	}
+	if !options.DryRun {
+		if err := os.MkdirAll(root, 0700); err != nil {
+			return nil, fmt.Errorf("failed to create destination: %w", err)
+		}
+	}
+
+	x := &extractor{
+		root:    root,
+		options: options,
+		result:  &ExtractResult{},
+		created: make(map[string]string),
+		dirs:    make(map[string]*tar.Header),
+	}
+	for _, archivePath := range chain {
+		if err := x.extractArchive(archivePath); err != nil {
+			if len(chain) > 1 {
+				err = fmt.Errorf("%s: %w", filepath.Base(archivePath), err)
+			}
+			return x.result, err
+		}
+	}
+
+	// Deepest directories first, so parents are still writable: in reverse
+	// order every path comes before its parents
+	if !options.DryRun {
+		paths := make([]string, 0, len(x.dirs))
+		for target := range x.dirs {
+			paths = append(paths, target)
+		}
+		sort.Sort(sort.Reverse(sort.StringSlice(paths)))
+		for _, target := range paths {
+			x.applyMeta(target, x.dirs[target])
+		}
+	}
+
+	return x.result, nil
+}
+
+// extractArchive restores the entries of one archive
+func (x *extractor) extractArchive(archivePath string) error {
+	ar, err := openArchive(archivePath, x.options.Encryptor)
+	if err != nil {
+		return err
+	}
+	defer ar.Close()
+
+	for {
+		header, err := ar.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read archive: %w", err)
+		}
+		// Deletions apply even though the record itself is not extracted
+		if isIncrementalRecord(header) {
+			if err := x.applyRecord(ar); err != nil {
+				return fmt.Errorf("%s: %w", header.Name, err)
+			}
+		}
+		// The tool's own entries describe the archive rather than content
+		if header.Name == MetaDir || strings.HasPrefix(header.Name, MetaDir+"/") {
+			continue
+		}
+		if !MatchEntry(header.Name, x.options.Patterns) {
+			continue
+		}
+		if err := x.extractEntry(header, ar); err != nil {
+			return fmt.Errorf("%s: %w", header.Name, err)
+		}
+	}
+
+	// Check the codec checksum and encryption authentication of the tail
+	return ar.finish()
+}
+
+// cleanName validates an archive name and returns it relative to the root.
+// An empty result refers to the root itself.
+func cleanName(name string) (string, error) {
+	if name == "" || path.IsAbs(name) || strings.ContainsRune(name, 0) {
+		return "", fmt.Errorf("%w: invalid name %q", ErrUnsafePath, name)
+	}
+	clean := path.Clean(name)
+	if clean == ".." || strings.HasPrefix(clean, "../") {
+		return "", fmt.Errorf("%w: %q escapes the destination", ErrUnsafePath, name)
+	}
+	if clean == "." {
+		return "", nil
+	}
+	return clean, nil
+}
+
+// checkLinkTarget validates a symlink target: it must be relative, and ".."
+// may only lead the target and must not climb above the root. Targets like
+// "a/../x" are refused because "a" may itself be a symlink.
+func checkLinkTarget(rel, target string) error {
+	if target == "" || path.IsAbs(target) {
+		return fmt.Errorf("%w: symlink to %q", ErrUnsafePath, target)
+	}
+
+	depth := strings.Count(rel, "/") // Directories above the link
+	climbing := true
+	for _, part := range strings.Split(target, "/") {
+		switch part {
+		case "", ".":
+		case "..":
+			if !climbing {
+				return fmt.Errorf("%w: symlink to %q", ErrUnsafePath, target)
+			}
+			depth--
+			if depth < 0 {
+				return fmt.Errorf("%w: symlink to %q escapes the destination", ErrUnsafePath, target)
+			}
+		default:
+			climbing = false
+		}
+	}
+	return nil
+}
+
+// prepareParents makes sure every parent of rel is a real directory inside
+// the root, creating missing ones. Symlinks are never followed.
+func (x *extractor) prepareParents(rel string) error {
+	parts := strings.Split(rel, "/")
+	current := x.root
+	for i, part := range parts[:len(parts)-1] {
+		current = filepath.Join(current, part)
+		relPart := strings.Join(parts[:i+1], "/")
+
+		kind := x.created[relPart]
+		if kind == "" {
+			info, err := os.Lstat(current)
+			switch {
+			case os.IsNotExist(err):
+				if !x.options.DryRun {
+					if err := os.Mkdir(current, 0700); err != nil {
+						return err
+					}
+				}
+				x.created[relPart] = "dir"
+				continue
+			case err != nil:
+				return err
+			case info.Mode()&os.ModeSymlink != 0:
+				kind = "symlink"
+			case info.IsDir():
+				kind = "dir"
+			default:
+				kind = "file"
+			}
+		}
+
+		switch kind {
+		case "symlink":
+			return fmt.Errorf("%w: parent %s is a symlink", ErrUnsafePath, relPart)
+		case "file":
+			return fmt.Errorf("parent %s is not a directory", relPart)
+		}
+	}
+	return nil
+}
+
+// existing reports what currently occupies a destination path: "" when
+// nothing, otherwise "dir", "symlink" or "file". In dry-run mode paths this
+// run would have written count as well.
+func (x *extractor) existing(rel, target string) (string, error) {
+	if kind := x.created[rel]; kind != "" && x.options.DryRun {
+		return kind, nil
+	}
+	info, err := os.Lstat(target)
+	switch {
+	case os.IsNotExist(err):
+		return "", nil
+	case err != nil:
+		return "", err
+	case info.Mode()&os.ModeSymlink != 0:
+		return "symlink", nil
+	case info.IsDir():
+		return "dir", nil
+	}
+	return "file", nil
+}
+
+// claim applies the overwrite policy to a path about to be written. It
+// returns false when the entry is to be skipped.
+func (x *extractor) claim(rel, target string, isDir bool) (bool, error) {
+	kind, err := x.existing(rel, target)
+	if err != nil {
+		return false, err
+	}
+
+	action := "create"
+	if kind != "" {
+		// Directories merge with existing directories
+		if isDir && kind == "dir" {
+			return true, nil
+		}
+
+		// Paths restored by this run are always replaced, the policy
+		// protects what existed before
+		policy := x.options.Overwrite
+		if x.created[rel] != "" {
+			policy = OverwriteAlways
+		}
+		switch policy {
+		case OverwriteNever:
+			return false, ErrExists
+		case OverwriteSkip:
+			x.result.Skipped = append(x.result.Skipped, rel)
+			x.record(rel, "skip")
+			return false, nil
+		}
+
+		if kind == "dir" {
+			return false, fmt.Errorf("cannot replace a directory")
+		}
+		action = "replace"
+		if !x.options.DryRun {
+			if err := os.Remove(target); err != nil {
+				return false, err
+			}
+		}
+	}
+
+	x.record(rel, action)
+	return true, nil
+}
+
+// record notes an action in dry-run mode
+func (x *extractor) record(rel, action string) {
+	if x.options.DryRun {
+		x.result.Actions = append(x.result.Actions, ExtractAction{Path: rel, Action: action})
+	}
+}
+
+// isIncrementalRecord reports whether an entry holds the deletion record of
+// an incremental archive
+func isIncrementalRecord(header *tar.Header) bool {
+	return header.Typeflag == tar.TypeReg && path.Dir(header.Name) == IncrementalDir && path.Ext(header.Name) == ".json"
+}
+
+// applyRecord removes the paths an incremental archive records as deleted
+func (x *extractor) applyRecord(r io.Reader) error {
+	var record IncrementalRecord
+	if err := json.NewDecoder(r).Decode(&record); err != nil {
+		return fmt.Errorf("invalid incremental record: %w", err)
+	}
+
+	for _, name := range record.Deleted {
+		if !MatchEntry(name, x.options.Patterns) {
+			continue
+		}
+		if err := x.remove(name); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// remove deletes a path recorded as deleted, without following symlinks
+func (x *extractor) remove(name string) error {
+	rel, err := cleanName(name)
+	if err != nil {
+		return err
+	}
+	if rel == "" {
+		return fmt.Errorf("%w: deletion of the destination root", ErrUnsafePath)
+	}
+
+	// Nothing to delete below a missing or non-directory parent
+	parts := strings.Split(rel, "/")
+	current := x.root
+	for i, part := range parts[:len(parts)-1] {
+		current = filepath.Join(current, part)
+		info, err := os.Lstat(current)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			return fmt.Errorf("%w: parent %s is a symlink", ErrUnsafePath, strings.Join(parts[:i+1], "/"))
+		}
+		if !info.IsDir() {
+			return nil
+		}
+	}
+
+	target := filepath.Join(x.root, filepath.FromSlash(rel))
+	info, err := os.Lstat(target)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	x.record(rel, "delete")
+	x.result.Deleted++
+
+	// Forget everything this run wrote at or below the path, so later
+	// entries neither link to it nor apply directory metadata to whatever
+	// replaces it
+	delete(x.created, rel)
+	delete(x.dirs, target)
+	if info.IsDir() {
+		for name := range x.created {
+			if strings.HasPrefix(name, rel+"/") {
+				delete(x.created, name)
+			}
+		}
+		for dir := range x.dirs {
+			if strings.HasPrefix(dir, target+string(filepath.Separator)) {
+				delete(x.dirs, dir)
+			}
+		}
+	}
+	if x.options.DryRun {
+		return nil
+	}
+
+	// RemoveAll removes symlinks themselves, never their targets
+	return os.RemoveAll(target)
+}
+
+// extractEntry restores a single archive entry
+func (x *extractor) extractEntry(header *tar.Header, r io.Reader) error {
+	rel, err := cleanName(header.Name)
+	if err != nil {
+		return err
+	}
+	if rel == "" {
+		// The root itself, typically "./"
+		if header.Typeflag == tar.TypeDir {
+			return nil
+		}
+		return fmt.Errorf("%w: non-directory entry for the destination root", ErrUnsafePath)
+	}
+
+	if err := x.prepareParents(rel); err != nil {
+		return err
+	}
+	target := filepath.Join(x.root, filepath.FromSlash(rel))
+
+	switch header.Typeflag {
+	case tar.TypeDir:
+		ok, err := x.claim(rel, target, true)
+		if err != nil || !ok {
+			return err
+		}
+		if !x.options.DryRun {
+			if err := os.Mkdir(target, 0700); err != nil && !os.IsExist(err) {
+				return err
+			}
+		}
+		x.created[rel] = "dir"
+		x.dirs[target] = header
+		x.result.Dirs++
+
+	case tar.TypeReg, tar.TypeGNUSparse:
+		ok, err := x.claim(rel, target, false)
+		if err != nil || !ok {
+			return err
+		}
+		if !x.options.DryRun {
+			if err := x.writeFile(target, header, r); err != nil {
+				return err
+			}
+		}
+		x.created[rel] = "file"
+		x.result.Files++
+		x.result.Bytes += header.Size
+
+	case tar.TypeSymlink:
+		if err := checkLinkTarget(rel, header.Linkname); err != nil {
+			return err
+		}
+		ok, err := x.claim(rel, target, false)
+		if err != nil || !ok {
+			return err
+		}
+		if !x.options.DryRun {
+			if err := os.Symlink(header.Linkname, target); err != nil {
+				return err
+			}
+			x.applyMeta(target, header)
+		}
+		x.created[rel] = "symlink"
+		x.result.Symlinks++
+
+	case tar.TypeLink:
+		return x.extractHardlink(rel, target, header)
+
+	default:
+		// Devices and FIFOs do not occur in paperless volumes
+		x.result.Skipped = append(x.result.Skipped, rel)
+		x.record(rel, "skip")
+	}
+
+	return nil
+}
+
+// extractHardlink links a name to an already extracted regular file
+func (x *extractor) extractHardlink(rel, target string, header *tar.Header) error {
+	linkRel, err := cleanName(header.Linkname)
+	if err != nil || linkRel == "" {
+		return fmt.Errorf("%w: hard link to %q", ErrUnsafePath, header.Linkname)
+	}
+
+	// Only regular files written by this run may be linked, never paths
+	// that existed before or symlinks
+	linkTarget := filepath.Join(x.root, filepath.FromSlash(linkRel))
+	switch x.created[linkRel] {
+	case "file":
+	case "":
+		x.result.Skipped = append(x.result.Skipped, rel)
+		x.result.Warnings = append(x.result.Warnings, fmt.Sprintf("%s: hard link target %s was not extracted", rel, linkRel))
+		x.record(rel, "skip")
+		return nil
+	default:
+		return fmt.Errorf("%w: hard link to non-file %s", ErrUnsafePath, linkRel)
+	}
+	// os.Link resolves the parents of the link target as well
+	if err := x.prepareParents(linkRel); err != nil {
+		return err
+	}
+
+	ok, err := x.claim(rel, target, false)
+	if err != nil || !ok {
+		return err
+	}
+	if !x.options.DryRun {
+		if err := os.Link(linkTarget, target); err != nil {
+			return err
+		}
+	}
+	x.created[rel] = "file"
+	x.result.Hardlinks++
+	return nil
+}
+
+// writeFile creates a regular file without following symlinks. Runs of
+// zeros are skipped instead of written, so sparse files stay sparse.
+func (x *extractor) writeFile(target string, header *tar.Header, r io.Reader) error {
+	file, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_EXCL|syscall.O_NOFOLLOW, 0600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	buf := make([]byte, len(zeroBlock))
+	var offset int64
+	for {
+		n, err := io.ReadFull(r, buf)
+		if n > 0 {
+			chunk := buf[:n]
+			if !isZero(chunk) {
+				if _, err := file.WriteAt(chunk, offset); err != nil {
+					return err
+				}
+			}
+			offset += int64(n)
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	// Trailing holes only extend the size
+	if err := file.Truncate(offset); err != nil {
+		return err
+	}
+
+	if err := file.Close(); err != nil {
+		return err
+	}
+	x.applyMeta(target, header)
+	return nil
+}
+
+// isZero reports whether a buffer only holds zeros
+func isZero(buf []byte) bool {
+	for len(buf) > 0 {
+		n := len(buf)
+		if n > len(zeroBlock) {
+			n = len(zeroBlock)
+		}
+		for _, b := range buf[:n] {
+			if b != 0 {
+				return false
+			}
+		}
+		buf = buf[n:]
+	}
+	return true
+}
+
+// applyMeta restores ownership, mode, extended attributes and timestamps of
+// an extracted path without following symlinks. Failures become warnings.
+func (x *extractor) applyMeta(target string, header *tar.Header) {
+	isLink := header.Typeflag == tar.TypeSymlink
+	warn := func(what string, err error) {
+		x.result.Warnings = append(x.result.Warnings, fmt.Sprintf("%s: failed to restore %s: %v", header.Name, what, err))
+	}
+
+	if x.options.RestoreOwner {
+		if err := os.Lchown(target, header.Uid, header.Gid); err != nil {
+			warn("ownership", err)
+		}
+	}
+
+	if !isLink {
+		// Ownership changes clear setuid/setgid, so the mode comes after it
+		mode := header.Mode & 0o1777
+		if x.options.RestoreOwner {
+			mode = header.Mode & 0o7777
+		}
+		if err := chmodNoFollow(target, uint32(mode)); err != nil {
+			warn("mode", err)
+		}
+	}
+
+	for key, value := range header.PAXRecords {
+		if !strings.HasPrefix(key, paxXattrPrefix) {
+			continue
+		}
+		name := strings.TrimPrefix(key, paxXattrPrefix)
+		if err := unix.Lsetxattr(target, name, []byte(value), 0); err != nil && !errors.Is(err, unix.ENOTSUP) {
+			warn("extended attribute "+name, err)
+		}
+	}
+
+	// Zeroed timestamps of reproducible archives are not restored
+	if header.ModTime.Unix() > 0 {
+		atime := header.AccessTime
+		if atime.IsZero() {
+			atime = header.ModTime
+		}
+		times := []unix.Timespec{unix.NsecToTimespec(atime.UnixNano()), unix.NsecToTimespec(header.ModTime.UnixNano())}
+		if err := unix.UtimesNanoAt(unix.AT_FDCWD, target, times, unix.AT_SYMLINK_NOFOLLOW); err != nil {
+			warn("timestamps", err)
+		}
+	}
+}
+
+// chmodNoFollow changes the mode of target, refusing to follow it when it is
+// a symlink. The mode is changed through /proc on an O_PATH descriptor,
+// which refers to the opened inode and cannot be redirected.
+func chmodNoFollow(target string, mode uint32) error {
+	fd, err := unix.Open(target, unix.O_PATH|unix.O_NOFOLLOW|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return err
+	}
+	defer unix.Close(fd)
+
+	var stat unix.Stat_t
+	if err := unix.Fstat(fd, &stat); err != nil {
+		return err
+	}
+	if stat.Mode&unix.S_IFMT == unix.S_IFLNK {
+		return fmt.Errorf("%w: %s is a symlink", ErrUnsafePath, target)
+	}
+	return unix.Fchmodat(unix.AT_FDCWD, fmt.Sprintf("/proc/self/fd/%d", fd), mode, 0)
+}
@@ -0,0 +1,354 @@
+package archive
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"paperless-backup/internal/logger"
+
+	"golang.org/x/sys/unix"
+)
+
+// writeRawArchive writes an uncompressed tar with arbitrary, possibly
+// malicious headers
+func writeRawArchive(t *testing.T, path string, headers []*tar.Header) {
+	t.Helper()
+
+	var buf bytes.Buffer
+	tarWriter := tar.NewWriter(&buf)
+	for _, header := range headers {
+		if header.Typeflag == tar.TypeReg {
+			header.Size = int64(len(header.Name))
+		}
+		if header.Mode == 0 {
+			header.Mode = 0644
+		}
+		if err := tarWriter.WriteHeader(header); err != nil {
+			t.Fatalf("WriteHeader(%s) failed: %v", header.Name, err)
+		}
+		if header.Typeflag == tar.TypeReg {
+			tarWriter.Write([]byte(header.Name))
+		}
+	}
+	tarWriter.Close()
+	if err := os.WriteFile(path, buf.Bytes(), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestExtractRefusesMaliciousArchives(t *testing.T) {
+	tests := []struct {
+		name    string
+		headers []*tar.Header
+	}{
+		{"parent traversal", []*tar.Header{
+			{Name: "../evil", Typeflag: tar.TypeReg},
+		}},
+		{"nested traversal", []*tar.Header{
+			{Name: "data/../../evil", Typeflag: tar.TypeReg},
+		}},
+		{"absolute path", []*tar.Header{
+			{Name: "/tmp/evil", Typeflag: tar.TypeReg},
+		}},
+		{"absolute symlink", []*tar.Header{
+			{Name: "link", Typeflag: tar.TypeSymlink, Linkname: "/etc"},
+		}},
+		{"symlink climbing out", []*tar.Header{
+			{Name: "data", Typeflag: tar.TypeDir, Mode: 0755},
+			{Name: "data/link", Typeflag: tar.TypeSymlink, Linkname: "../../outside"},
+		}},
+		{"write through own symlink", []*tar.Header{
+			{Name: "link", Typeflag: tar.TypeSymlink, Linkname: "."},
+			{Name: "link/evil", Typeflag: tar.TypeReg},
+		}},
+		{"chained symlinks", []*tar.Header{
+			{Name: "a", Typeflag: tar.TypeSymlink, Linkname: "."},
+			{Name: "b", Typeflag: tar.TypeSymlink, Linkname: "a/../outside"},
+		}},
+		{"hard link outside", []*tar.Header{
+			{Name: "passwd", Typeflag: tar.TypeLink, Linkname: "../outside/secret"},
+		}},
+		{"hard link to symlink", []*tar.Header{
+			{Name: "link", Typeflag: tar.TypeSymlink, Linkname: "target"},
+			{Name: "hard", Typeflag: tar.TypeLink, Linkname: "link"},
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tmpDir := t.TempDir()
+			archivePath := filepath.Join(tmpDir, "evil.tar")
+			writeRawArchive(t, archivePath, tt.headers)
+
+			outside := filepath.Join(tmpDir, "outside")
+			os.MkdirAll(outside, 0755)
+			os.WriteFile(filepath.Join(outside, "secret"), []byte("secret"), 0600)
+			dest := filepath.Join(tmpDir, "dest")
+
+			for _, dryRun := range []bool{true, false} {
+				_, err := Extract(archivePath, dest, ExtractOptions{DryRun: dryRun, Overwrite: OverwriteAlways})
+				if !errors.Is(err, ErrUnsafePath) {
+					t.Errorf("Extract(dryRun=%v) = %v, want ErrUnsafePath", dryRun, err)
+				}
+			}
+
+			for _, path := range []string{filepath.Join(tmpDir, "evil"), filepath.Join(outside, "evil"), "/tmp/evil"} {
+				if _, err := os.Lstat(path); err == nil {
+					t.Errorf("%s was written outside the destination", path)
+				}
+			}
+		})
+	}
+}
+
+func TestExtractRefusesExistingSymlinks(t *testing.T) {
+	tmpDir := t.TempDir()
+	archivePath := filepath.Join(tmpDir, "evil.tar")
+	writeRawArchive(t, archivePath, []*tar.Header{
+		{Name: "media/file", Typeflag: tar.TypeReg},
+	})
+
+	// A symlink planted in the destination must not be followed
+	outside := filepath.Join(tmpDir, "outside")
+	dest := filepath.Join(tmpDir, "dest")
+	os.MkdirAll(outside, 0755)
+	os.MkdirAll(dest, 0755)
+	os.Symlink(outside, filepath.Join(dest, "media"))
+
+	if _, err := Extract(archivePath, dest, ExtractOptions{Overwrite: OverwriteAlways}); !errors.Is(err, ErrUnsafePath) {
+		t.Errorf("Extract = %v, want ErrUnsafePath", err)
+	}
+	if _, err := os.Stat(filepath.Join(outside, "file")); err == nil {
+		t.Error("File was written through a symlink")
+	}
+
+	// Replacing the symlink itself removes the link, not its target
+	writeRawArchive(t, archivePath, []*tar.Header{
+		{Name: "media", Typeflag: tar.TypeReg},
+	})
+	if _, err := Extract(archivePath, dest, ExtractOptions{Overwrite: OverwriteAlways}); err != nil {
+		t.Fatalf("Extract failed: %v", err)
+	}
+	info, err := os.Lstat(filepath.Join(dest, "media"))
+	if err != nil || !info.Mode().IsRegular() {
+		t.Errorf("Symlink should be replaced by a regular file")
+	}
+	if _, err := os.Stat(outside); err != nil {
+		t.Error("Symlink target should not be touched")
+	}
+}
+
+func TestExtractReplacedByDeletionRecord(t *testing.T) {
+	tmpDir := t.TempDir()
+	dest := filepath.Join(tmpDir, "dest")
+	other := filepath.Join(dest, "other")
+	os.MkdirAll(other, 0755)
+	os.WriteFile(filepath.Join(other, "file"), []byte("local"), 0644)
+
+	// A directory deleted by a record and replaced by a symlink to another
+	// directory of the destination
+	record, _ := json.Marshal(&IncrementalRecord{Source: "data", Deleted: []string{"data"}})
+	var buf bytes.Buffer
+	tarWriter := tar.NewWriter(&buf)
+	tarWriter.WriteHeader(&tar.Header{Name: "data", Typeflag: tar.TypeDir, Mode: 0700})
+	tarWriter.WriteHeader(&tar.Header{Name: "data/file", Typeflag: tar.TypeReg, Mode: 0644, Size: 4})
+	tarWriter.Write([]byte("data"))
+	tarWriter.WriteHeader(&tar.Header{Name: IncrementalDir + "/data.json", Typeflag: tar.TypeReg, Mode: 0644, Size: int64(len(record))})
+	tarWriter.Write(record)
+	tarWriter.WriteHeader(&tar.Header{Name: "data", Typeflag: tar.TypeSymlink, Linkname: "other", Mode: 0777})
+	tarWriter.WriteHeader(&tar.Header{Name: "copy", Typeflag: tar.TypeLink, Linkname: "data/file"})
+	tarWriter.Close()
+	archivePath := filepath.Join(tmpDir, "backup.tar")
+	os.WriteFile(archivePath, buf.Bytes(), 0600)
+
+	result, err := Extract(archivePath, dest, ExtractOptions{})
+	if err != nil {
+		t.Fatalf("Extract failed: %v", err)
+	}
+
+	// Neither the deferred directory mode nor the hard link may reach
+	// through the symlink
+	if info, _ := os.Stat(other); info == nil || info.Mode().Perm() != 0755 {
+		t.Errorf("Mode of the symlink target changed: %v", info)
+	}
+	if _, err := os.Lstat(filepath.Join(dest, "copy")); err == nil {
+		t.Error("Hard link to a deleted file should not be created")
+	}
+	if result.Deleted != 1 || len(result.Skipped) != 1 || result.Skipped[0] != "copy" {
+		t.Errorf("Unexpected result: %+v", result)
+	}
+}
+
+func TestExtractRoundTrip(t *testing.T) {
+	tmpDir := t.TempDir()
+	log, _ := logger.New(filepath.Join(tmpDir, "test.log"))
+	defer log.Close()
+
+	sourceDir := filepath.Join(tmpDir, "source")
+	os.MkdirAll(filepath.Join(sourceDir, "documents"), 0750)
+	os.WriteFile(filepath.Join(sourceDir, "documents", "0001.pdf"), []byte("pdf content"), 0640)
+	os.Link(filepath.Join(sourceDir, "documents", "0001.pdf"), filepath.Join(sourceDir, "documents", "copy.pdf"))
+	os.Symlink("0001.pdf", filepath.Join(sourceDir, "documents", "latest.pdf"))
+	os.Chmod(filepath.Join(sourceDir, "documents"), 0550)
+	defer os.Chmod(filepath.Join(sourceDir, "documents"), 0750)
+
+	// Sparse file with a single data block in the middle
+	sparse, _ := os.Create(filepath.Join(sourceDir, "base.db"))
+	sparse.Truncate(32 << 20)
+	sparse.WriteAt([]byte("data"), 16<<20)
+	sparse.Close()
+
+	backupFile := filepath.Join(tmpDir, "backup.tar.gz")
+	creator := New(log, Options{})
+	if err := creator.Create(context.Background(), backupFile, []Source{{Name: "media", Path: sourceDir}}); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+
+	dest := filepath.Join(tmpDir, "restore")
+	result, err := Extract(backupFile, dest, ExtractOptions{})
+	if err != nil {
+		t.Fatalf("Extract failed: %v", err)
+	}
+	if result.Files != 2 || result.Hardlinks != 1 || result.Symlinks != 1 {
+		t.Errorf("Unexpected result: %+v", result)
+	}
+	defer os.Chmod(filepath.Join(dest, "media", "documents"), 0750)
+
+	content, err := os.ReadFile(filepath.Join(dest, "media", "documents", "0001.pdf"))
+	if err != nil || string(content) != "pdf content" {
+		t.Errorf("Unexpected content: %q, %v", content, err)
+	}
+	if info, _ := os.Stat(filepath.Join(dest, "media", "documents", "0001.pdf")); info == nil || info.Mode().Perm() != 0640 {
+		t.Errorf("File mode not restored: %v", info)
+	}
+	if info, _ := os.Stat(filepath.Join(dest, "media", "documents")); info == nil || info.Mode().Perm() != 0550 {
+		t.Errorf("Directory mode not restored: %v", info)
+	}
+	if target, _ := os.Readlink(filepath.Join(dest, "media", "documents", "latest.pdf")); target != "0001.pdf" {
+		t.Errorf("Symlink target = %q", target)
+	}
+
+	var first, second unix.Stat_t
+	unix.Stat(filepath.Join(dest, "media", "documents", "0001.pdf"), &first)
+	unix.Stat(filepath.Join(dest, "media", "documents", "copy.pdf"), &second)
+	if first.Ino != second.Ino {
+		t.Error("Hard link should share the inode")
+	}
+
+	var sparseStat unix.Stat_t
+	unix.Stat(filepath.Join(dest, "media", "base.db"), &sparseStat)
+	if sparseStat.Size != 32<<20 {
+		t.Errorf("Sparse file size = %d", sparseStat.Size)
+	}
+	if sparseStat.Blocks*512 >= sparseStat.Size {
+		t.Logf("Filesystem did not keep the restored file sparse (%d blocks)", sparseStat.Blocks)
+	}
+	data := make([]byte, 4)
+	f, _ := os.Open(filepath.Join(dest, "media", "base.db"))
+	f.ReadAt(data, 16<<20)
+	f.Close()
+	if string(data) != "data" {
+		t.Errorf("Sparse file content = %q", data)
+	}
+
+	// The tool's own entries are not restored into the destination
+	if _, err := os.Stat(filepath.Join(dest, MetaDir)); !os.IsNotExist(err) {
+		t.Errorf("%s should not be extracted: %v", MetaDir, err)
+	}
+}
+
+func TestExtractDryRunAndOverwrite(t *testing.T) {
+	tmpDir := t.TempDir()
+	archivePath := filepath.Join(tmpDir, "backup.tar")
+	writeRawArchive(t, archivePath, []*tar.Header{
+		{Name: "data", Typeflag: tar.TypeDir, Mode: 0755},
+		{Name: "data/new", Typeflag: tar.TypeReg},
+		{Name: "data/existing", Typeflag: tar.TypeReg},
+	})
+
+	dest := filepath.Join(tmpDir, "dest")
+	os.MkdirAll(filepath.Join(dest, "data"), 0755)
+	existing := filepath.Join(dest, "data", "existing")
+	os.WriteFile(existing, []byte("local"), 0644)
+
+	// Dry run reports every action and writes nothing
+	result, err := Extract(archivePath, dest, ExtractOptions{DryRun: true, Overwrite: OverwriteAlways})
+	if err != nil {
+		t.Fatalf("Dry run failed: %v", err)
+	}
+	expected := []ExtractAction{{"data/new", "create"}, {"data/existing", "replace"}}
+	if len(result.Actions) != len(expected) {
+		t.Fatalf("Actions = %v, want %v", result.Actions, expected)
+	}
+	for i := range expected {
+		if result.Actions[i] != expected[i] {
+			t.Errorf("Action %d = %v, want %v", i, result.Actions[i], expected[i])
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dest, "data", "new")); err == nil {
+		t.Error("Dry run should not write files")
+	}
+	if len(result.Warnings) != 0 {
+		t.Errorf("Dry run should not touch metadata: %v", result.Warnings)
+	}
+
+	// never fails on the existing file
+	if _, err := Extract(archivePath, dest, ExtractOptions{}); !errors.Is(err, ErrExists) {
+		t.Errorf("Extract with never = %v, want ErrExists", err)
+	}
+	os.Remove(filepath.Join(dest, "data", "new"))
+
+	// skip keeps the existing file
+	result, err = Extract(archivePath, dest, ExtractOptions{Overwrite: OverwriteSkip})
+	if err != nil {
+		t.Fatalf("Extract with skip failed: %v", err)
+	}
+	if content, _ := os.ReadFile(existing); string(content) != "local" {
+		t.Errorf("skip replaced the existing file: %q", content)
+	}
+	if len(result.Skipped) != 1 || result.Skipped[0] != "data/existing" {
+		t.Errorf("Skipped = %v", result.Skipped)
+	}
+
+	// always replaces it
+	if _, err := Extract(archivePath, dest, ExtractOptions{Overwrite: OverwriteAlways}); err != nil {
+		t.Fatalf("Extract with always failed: %v", err)
+	}
+	if content, _ := os.ReadFile(existing); string(content) != "data/existing" {
+		t.Errorf("always kept the existing file: %q", content)
+	}
+
+	if _, err := Extract(archivePath, dest, ExtractOptions{Overwrite: "sometimes"}); err == nil {
+		t.Error("Unknown overwrite policy should be rejected")
+	}
+}
+
+func TestCheckLinkTarget(t *testing.T) {
+	tests := []struct {
+		rel    string
+		target string
+		ok     bool
+	}{
+		{"a/link", "file", true},
+		{"a/link", "../b/file", true},
+		{"a/b/link", "../../file", true},
+		{"a/link", "../../file", false},
+		{"link", "..", false},
+		{"a/link", "b/../../x", false},
+		{"a/link", "/etc/passwd", false},
+		{"a/link", "./file", true},
+	}
+
+	for _, tt := range tests {
+		err := checkLinkTarget(tt.rel, tt.target)
+		if (err == nil) != tt.ok {
+			t.Errorf("checkLinkTarget(%q, %q) = %v, want ok=%v", tt.rel, tt.target, err, tt.ok)
+		}
+	}
+}
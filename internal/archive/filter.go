@@ -0,0 +1,191 @@
+package archive
+
+import (
+	"archive/tar"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+)
+
+// PAXInclude and PAXExclude are PAX records on a source's root entry that
+// hold its filter rules as JSON arrays, so a restore knows which content was
+// left out on purpose
+const (
+	PAXInclude = "PAPERLESSBACKUP.include"
+	PAXExclude = "PAPERLESSBACKUP.exclude"
+)
+
+// Filter selects the entries of a source. Patterns are slash separated and
+// relative to the source root; "*", "?" and "[...]" match within a path
+// segment and "**" matches any number of segments. A pattern matching a
+// directory applies to everything below it.
+type Filter struct {
+	Include []string // When set, only matching entries are archived
+	Exclude []string // Matching entries are skipped, directories are not descended
+}
+
+// Validate checks that all patterns are well formed
+func (f Filter) Validate() error {
+	for _, pattern := range append(append([]string{}, f.Include...), f.Exclude...) {
+		if pattern == "" || strings.HasPrefix(pattern, "/") {
+			return fmt.Errorf("invalid pattern %q: must be a non-empty relative path", pattern)
+		}
+		for _, segment := range strings.Split(pattern, "/") {
+			if _, err := path.Match(segment, ""); err != nil {
+				return fmt.Errorf("invalid pattern %q: %w", pattern, err)
+			}
+		}
+	}
+	return nil
+}
+
+// IsEmpty reports whether the filter selects every entry
+func (f Filter) IsEmpty() bool {
+	return len(f.Include) == 0 && len(f.Exclude) == 0
+}
+
+// filterDecision is the outcome of matching an entry against a filter
+type filterDecision int
+
+const (
+	filterKeep  filterDecision = iota // Archive the entry
+	filterSkip                        // Leave out the entry, descend into directories
+	filterPrune                       // Leave out the entry and everything below it
+)
+
+// decide matches a path relative to the source root. Directories that only
+// lead towards included entries are kept, so the included entries have
+// their parents in the archive.
+func (f Filter) decide(rel string, isDir bool) filterDecision {
+	segments := strings.Split(rel, "/")
+
+	for _, pattern := range f.Exclude {
+		if matchPattern(pattern, segments) {
+			if isDir {
+				return filterPrune
+			}
+			return filterSkip
+		}
+	}
+
+	if len(f.Include) == 0 {
+		return filterKeep
+	}
+
+	for _, pattern := range f.Include {
+		// A matching ancestor includes the entry too
+		for i := 1; i <= len(segments); i++ {
+			if matchPattern(pattern, segments[:i]) {
+				return filterKeep
+			}
+		}
+	}
+
+	if isDir {
+		for _, pattern := range f.Include {
+			if matchPrefix(pattern, segments) {
+				return filterKeep
+			}
+		}
+		return filterPrune
+	}
+	return filterSkip
+}
+
+// Excludes reports whether a path relative to the source root is left out.
+// Excluded directories are not descended.
+func (f Filter) Excludes(rel string, isDir bool) bool {
+	return !f.IsEmpty() && f.decide(rel, isDir) != filterKeep
+}
+
+// matchPattern reports whether a pattern matches a path given as segments
+func matchPattern(pattern string, segments []string) bool {
+	return matchSegments(strings.Split(pattern, "/"), segments)
+}
+
+func matchSegments(pattern, segments []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			// Try every number of segments for the wildcard, shortest first
+			for i := 0; i <= len(segments); i++ {
+				if matchSegments(pattern[1:], segments[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(segments) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], segments[0]); !ok {
+			return false
+		}
+		pattern, segments = pattern[1:], segments[1:]
+	}
+	return len(segments) == 0
+}
+
+// matchPrefix reports whether a pattern could match an entry below the
+// directory given as segments
+func matchPrefix(pattern string, segments []string) bool {
+	parts := strings.Split(pattern, "/")
+	for _, segment := range segments {
+		if len(parts) == 0 {
+			return false
+		}
+		if parts[0] == "**" {
+			return true
+		}
+		if ok, _ := path.Match(parts[0], segment); !ok {
+			return false
+		}
+		parts = parts[1:]
+	}
+	return len(parts) > 0
+}
+
+// filterStats counts what a filter left out of a source. Pruned directories
+// are not descended, so their content is not counted.
+type filterStats struct {
+	Dirs  int
+	Files int
+	Bytes int64
+}
+
+// addFile counts a skipped file
+func (s *filterStats) addFile(info os.FileInfo) {
+	s.Files++
+	if info.Mode().IsRegular() {
+		s.Bytes += info.Size()
+	}
+}
+
+// recordFilter stores the filter rules on the root entry of a source
+func recordFilter(header *tar.Header, filter Filter) {
+	if len(filter.Include) > 0 {
+		encoded, _ := json.Marshal(filter.Include)
+		setPAXRecord(header, PAXInclude, string(encoded))
+	}
+	if len(filter.Exclude) > 0 {
+		encoded, _ := json.Marshal(filter.Exclude)
+		setPAXRecord(header, PAXExclude, string(encoded))
+	}
+}
+
+// FilterFromPAX reads the filter rules recorded on a source's root entry
+func FilterFromPAX(records map[string]string) (Filter, error) {
+	var filter Filter
+	if value, ok := records[PAXInclude]; ok {
+		if err := json.Unmarshal([]byte(value), &filter.Include); err != nil {
+			return Filter{}, fmt.Errorf("invalid %s record: %w", PAXInclude, err)
+		}
+	}
+	if value, ok := records[PAXExclude]; ok {
+		if err := json.Unmarshal([]byte(value), &filter.Exclude); err != nil {
+			return Filter{}, fmt.Errorf("invalid %s record: %w", PAXExclude, err)
+		}
+	}
+	return filter, nil
+}
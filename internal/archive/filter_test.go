@@ -0,0 +1,159 @@
+package archive
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"paperless-backup/internal/logger"
+)
+
+func TestFilterDecide(t *testing.T) {
+	filter := Filter{
+		Include: []string{"documents/**", "*.json"},
+		Exclude: []string{"documents/thumbnails", "**/*.tmp", "log"},
+	}
+
+	tests := []struct {
+		rel      string
+		isDir    bool
+		expected filterDecision
+	}{
+		{"documents", true, filterKeep},
+		{"documents/originals/0001.pdf", false, filterKeep},
+		{"documents/thumbnails", true, filterPrune},
+		{"documents/originals/scan.tmp", false, filterSkip},
+		{"scan.tmp", false, filterSkip},
+		{"manifest.json", false, filterKeep},
+		{"sub/manifest.json", false, filterSkip},
+		{"log", true, filterPrune},
+		{"index", true, filterPrune},
+		{"celerybeat-schedule.db", false, filterSkip},
+	}
+
+	for _, tt := range tests {
+		if got := filter.decide(tt.rel, tt.isDir); got != tt.expected {
+			t.Errorf("decide(%q, %v) = %v, want %v", tt.rel, tt.isDir, got, tt.expected)
+		}
+	}
+}
+
+func TestFilterIncludeKeepsParents(t *testing.T) {
+	filter := Filter{Include: []string{"a/b/*.pdf"}}
+
+	tests := []struct {
+		rel      string
+		isDir    bool
+		expected filterDecision
+	}{
+		{"a", true, filterKeep},
+		{"a/b", true, filterKeep},
+		{"a/b/c.pdf", false, filterKeep},
+		{"a/b/c.txt", false, filterSkip},
+		{"a/x", true, filterPrune},
+		{"a/b/c", true, filterPrune},
+		{"z", true, filterPrune},
+	}
+
+	for _, tt := range tests {
+		if got := filter.decide(tt.rel, tt.isDir); got != tt.expected {
+			t.Errorf("decide(%q, %v) = %v, want %v", tt.rel, tt.isDir, got, tt.expected)
+		}
+	}
+}
+
+func TestMatchPatternDoublestar(t *testing.T) {
+	tests := []struct {
+		pattern string
+		path    string
+		match   bool
+	}{
+		{"**/*.log", "celery.log", true},
+		{"**/*.log", "a/b/celery.log", true},
+		{"a/**/z", "a/z", true},
+		{"a/**/z", "a/b/c/z", true},
+		{"a/**/z", "b/z", false},
+		{"*.log", "a/celery.log", false},
+		{"temp-*.rdb", "temp-123.rdb", true},
+		{"**", "anything/at/all", true},
+	}
+
+	for _, tt := range tests {
+		if got := matchPattern(tt.pattern, strings.Split(tt.path, "/")); got != tt.match {
+			t.Errorf("matchPattern(%q, %q) = %v, want %v", tt.pattern, tt.path, got, tt.match)
+		}
+	}
+}
+
+func TestFilterValidate(t *testing.T) {
+	valid := Filter{Include: []string{"**/*.pdf"}, Exclude: []string{"index", "log/*.log"}}
+	if err := valid.Validate(); err != nil {
+		t.Errorf("Valid filter rejected: %v", err)
+	}
+
+	for _, pattern := range []string{"", "/abs", "bad[", "a/[z-"} {
+		if err := (Filter{Exclude: []string{pattern}}).Validate(); err == nil {
+			t.Errorf("Pattern %q should be rejected", pattern)
+		}
+	}
+}
+
+func TestAddToTarAppliesFilter(t *testing.T) {
+	tmpDir := t.TempDir()
+	logPath := filepath.Join(tmpDir, "test.log")
+
+	log, _ := logger.New(logPath)
+	defer log.Close()
+
+	sourceDir := filepath.Join(tmpDir, "source")
+	os.MkdirAll(filepath.Join(sourceDir, "documents", "originals"), 0755)
+	os.MkdirAll(filepath.Join(sourceDir, "documents", "thumbnails", "nested"), 0755)
+	os.WriteFile(filepath.Join(sourceDir, "documents", "originals", "0001.pdf"), []byte("pdf"), 0644)
+	os.WriteFile(filepath.Join(sourceDir, "documents", "thumbnails", "0001.webp"), []byte("thumb"), 0644)
+	os.WriteFile(filepath.Join(sourceDir, "documents", "thumbnails", "nested", "0002.webp"), []byte("thumb"), 0644)
+	os.WriteFile(filepath.Join(sourceDir, "documents", "upload.tmp"), []byte("temporary"), 0644)
+
+	filter := Filter{Exclude: []string{"documents/thumbnails", "**/*.tmp"}}
+
+	var buf bytes.Buffer
+	tarWriter := newTarStream(&buf)
+
+	creator := New(log, Options{})
+	if err := creator.addToTar(context.Background(), tarWriter, Source{Name: "media", Path: sourceDir, Filter: filter}); err != nil {
+		t.Fatalf("addToTar failed: %v", err)
+	}
+	tarWriter.Close()
+
+	headers, _ := readTarEntries(t, &buf)
+	var names []string
+	for _, header := range headers {
+		names = append(names, header.Name)
+	}
+	expected := []string{"media", "media/documents", "media/documents/originals", "media/documents/originals/0001.pdf"}
+	if !reflect.DeepEqual(names, expected) {
+		t.Errorf("Entries = %v, want %v", names, expected)
+	}
+
+	// The rules are recorded on the root entry
+	recorded, err := FilterFromPAX(headers[0].PAXRecords)
+	if err != nil {
+		t.Fatalf("FilterFromPAX failed: %v", err)
+	}
+	if !reflect.DeepEqual(recorded, filter) {
+		t.Errorf("Recorded filter = %+v, want %+v", recorded, filter)
+	}
+	if headers[0].Typeflag != tar.TypeDir {
+		t.Errorf("Root entry should be a directory")
+	}
+
+	// Totals of the excluded content are logged
+	logContent, _ := os.ReadFile(logPath)
+	if !strings.Contains(string(logContent), "Excluded from media: 1 directories (not descended), 1 files") {
+		t.Errorf("Excluded totals not logged:\n%s", logContent)
+	}
+}
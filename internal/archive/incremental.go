@@ -0,0 +1,134 @@
+package archive
+
+import (
+	"archive/tar"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"syscall"
+	"time"
+)
+
+// IncrementalDir holds one record per source in incremental archives
+const IncrementalDir = MetaDir + "/incremental"
+
+// FileState identifies a version of an archived path for change detection
+type FileState struct {
+	Size    int64  `json:"s"`
+	ModTime int64  `json:"m"` // Nanoseconds since the epoch
+	Inode   uint64 `json:"i"`
+}
+
+// stateOf returns the FileState of a walked path
+func stateOf(info os.FileInfo) FileState {
+	state := FileState{Size: info.Size(), ModTime: info.ModTime().UnixNano()}
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		state.Inode = stat.Ino
+	}
+	return state
+}
+
+// Snapshot records every path of a source at backup time. The snapshot of
+// one backup is the base that the next incremental level is compared to.
+type Snapshot struct {
+	Source  string               `json:"source"`
+	Archive string               `json:"archive"` // File name of the backup the snapshot belongs to
+	Level   int                  `json:"level"`
+	Created time.Time            `json:"created"`
+	Files   map[string]FileState `json:"files"` // By archive path
+}
+
+// LoadSnapshot reads a snapshot saved with Save
+func LoadSnapshot(path string) (*Snapshot, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var snapshot Snapshot
+	if err := json.Unmarshal(content, &snapshot); err != nil {
+		return nil, fmt.Errorf("invalid snapshot %s: %w", path, err)
+	}
+	if snapshot.Files == nil {
+		snapshot.Files = make(map[string]FileState)
+	}
+	return &snapshot, nil
+}
+
+// Save atomically writes the snapshot to path
+func (s *Snapshot) Save(path string) error {
+	content, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(path, content, 0600); err != nil {
+		return fmt.Errorf("failed to write snapshot: %w", err)
+	}
+	return nil
+}
+
+// unchanged reports whether a regular file matches its state in the snapshot
+func (s *Snapshot) unchanged(archivePath string, state FileState) bool {
+	old, ok := s.Files[archivePath]
+	return ok && old == state
+}
+
+// IncrementalRecord is stored for each source of an incremental archive. It
+// names the backup the archive builds on and the paths deleted since.
+type IncrementalRecord struct {
+	Source    string   `json:"source"`
+	Base      string   `json:"base"`       // File name of the base backup
+	BaseLevel int      `json:"base_level"` // Level of the base backup
+	Deleted   []string `json:"deleted"`    // Archive paths removed since the base
+}
+
+// newIncrementalRecord compares the current snapshot of a source to its base
+func newIncrementalRecord(base, current *Snapshot) *IncrementalRecord {
+	record := &IncrementalRecord{
+		Source:    current.Source,
+		Base:      base.Archive,
+		BaseLevel: base.Level,
+		Deleted:   []string{},
+	}
+	for path := range base.Files {
+		if _, ok := current.Files[path]; !ok {
+			record.Deleted = append(record.Deleted, path)
+		}
+	}
+	sort.Strings(record.Deleted)
+	return record
+}
+
+// writeIncrementalRecords writes the records of an incremental archive
+func (c *Creator) writeIncrementalRecords(tarWriter *tarStream) error {
+	for _, snapshot := range c.snapshots {
+		record := c.records[snapshot.Source]
+		if record == nil {
+			continue
+		}
+
+		content, err := json.MarshalIndent(record, "", "  ")
+		if err != nil {
+			return err
+		}
+		header := &tar.Header{
+			Name:     IncrementalDir + "/" + record.Source + ".json",
+			Mode:     0644,
+			Size:     int64(len(content)),
+			Typeflag: tar.TypeReg,
+		}
+		if err := tarWriter.WriteHeader(header); err != nil {
+			return fmt.Errorf("failed to write incremental record: %w", err)
+		}
+		if _, err := tarWriter.Write(content); err != nil {
+			return fmt.Errorf("failed to write incremental record: %w", err)
+		}
+	}
+	return nil
+}
+
+// Snapshots returns the snapshots of all sources taken by the last Create
+func (c *Creator) Snapshots() []*Snapshot {
+	return c.snapshots
+}
@@ -0,0 +1,148 @@
+package archive
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"paperless-backup/internal/logger"
+)
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	tmpDir := t.TempDir()
+	path := filepath.Join(tmpDir, "media.json")
+
+	snapshot := &Snapshot{
+		Source:  "media",
+		Archive: "20240101_020000.tar.gz",
+		Level:   0,
+		Created: time.Now().UTC().Truncate(time.Second),
+		Files:   map[string]FileState{"media/a.pdf": {Size: 3, ModTime: 42, Inode: 7}},
+	}
+	if err := snapshot.Save(path); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+
+	loaded, err := LoadSnapshot(path)
+	if err != nil {
+		t.Fatalf("LoadSnapshot failed: %v", err)
+	}
+	if loaded.Archive != snapshot.Archive || !loaded.Created.Equal(snapshot.Created) {
+		t.Errorf("Unexpected snapshot: %+v", loaded)
+	}
+	if !loaded.unchanged("media/a.pdf", FileState{Size: 3, ModTime: 42, Inode: 7}) {
+		t.Error("Identical state should be unchanged")
+	}
+	if loaded.unchanged("media/a.pdf", FileState{Size: 3, ModTime: 43, Inode: 7}) {
+		t.Error("Modified file should not be unchanged")
+	}
+	if loaded.unchanged("media/b.pdf", FileState{}) {
+		t.Error("Unknown file should not be unchanged")
+	}
+
+	os.WriteFile(path, []byte("{broken"), 0600)
+	if _, err := LoadSnapshot(path); err == nil {
+		t.Error("Expected error for a malformed snapshot")
+	}
+}
+
+func TestIncrementalCreateAndRestore(t *testing.T) {
+	tmpDir := t.TempDir()
+	log, _ := logger.New(filepath.Join(tmpDir, "test.log"))
+	defer log.Close()
+
+	sourceDir := filepath.Join(tmpDir, "source")
+	os.MkdirAll(filepath.Join(sourceDir, "documents"), 0755)
+	os.WriteFile(filepath.Join(sourceDir, "documents", "kept.pdf"), []byte("kept"), 0644)
+	os.WriteFile(filepath.Join(sourceDir, "documents", "changed.pdf"), []byte("old"), 0644)
+	os.WriteFile(filepath.Join(sourceDir, "documents", "deleted.pdf"), []byte("deleted"), 0644)
+
+	creator := New(log, Options{})
+	fullFile := filepath.Join(tmpDir, "full.tar.gz")
+	if err := creator.Create(context.Background(), fullFile, []Source{{Name: "media", Path: sourceDir}}); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	base := creator.Snapshots()[0]
+	base.Archive = filepath.Base(fullFile)
+	if len(base.Files) != 5 {
+		t.Errorf("Snapshot should record every path, got %v", base.Files)
+	}
+
+	os.WriteFile(filepath.Join(sourceDir, "documents", "changed.pdf"), []byte("new content"), 0644)
+	os.Remove(filepath.Join(sourceDir, "documents", "deleted.pdf"))
+	os.WriteFile(filepath.Join(sourceDir, "documents", "added.pdf"), []byte("added"), 0644)
+
+	incrementalFile := filepath.Join(tmpDir, "incremental.tar.gz")
+	if err := creator.Create(context.Background(), incrementalFile, []Source{{Name: "media", Path: sourceDir, Base: base}}); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+
+	// Only changed files are stored again
+	entries, err := List(incrementalFile, nil, []string{"**/*.pdf"})
+	if err != nil {
+		t.Fatalf("List failed: %v", err)
+	}
+	stored := make(map[string]bool)
+	for _, entry := range entries {
+		stored[entry.Path] = true
+	}
+	if len(stored) != 2 || !stored["media/documents/changed.pdf"] || !stored["media/documents/added.pdf"] {
+		t.Errorf("Unexpected files in incremental archive: %v", stored)
+	}
+
+	// Restoring the chain replays the deletion and replaces changed files
+	// restored by the full backup
+	dest := filepath.Join(tmpDir, "restore")
+	result, err := ExtractChain([]string{fullFile, incrementalFile}, dest, ExtractOptions{Overwrite: OverwriteNever})
+	if err != nil {
+		t.Fatalf("ExtractChain failed: %v", err)
+	}
+	if result.Deleted != 1 {
+		t.Errorf("Expected 1 deletion, got %d", result.Deleted)
+	}
+
+	expected := map[string]string{"kept.pdf": "kept", "changed.pdf": "new content", "added.pdf": "added"}
+	for name, want := range expected {
+		content, err := os.ReadFile(filepath.Join(dest, "media", "documents", name))
+		if err != nil || string(content) != want {
+			t.Errorf("%s = %q, %v; want %q", name, content, err, want)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dest, "media", "documents", "deleted.pdf")); !os.IsNotExist(err) {
+		t.Error("Deleted file should be removed by the incremental restore")
+	}
+
+	if _, err := os.Stat(filepath.Join(dest, MetaDir)); !os.IsNotExist(err) {
+		t.Error("Records and manifests should not be extracted")
+	}
+
+	// The record names the base for the restore chain
+	reader, err := Open(incrementalFile, nil)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer reader.Close()
+	for _, err := reader.Next(); err == nil; _, err = reader.Next() {
+	}
+	if records := reader.Records(); len(records) != 1 || records[0].Base != "full.tar.gz" {
+		t.Errorf("Unexpected records: %+v", records)
+	}
+
+	// Files that existed before the restore stay protected by the policy,
+	// also from later archives of the chain
+	dest = filepath.Join(tmpDir, "existing")
+	os.MkdirAll(filepath.Join(dest, "media", "documents"), 0755)
+	os.WriteFile(filepath.Join(dest, "media", "documents", "changed.pdf"), []byte("local"), 0644)
+	if _, err := ExtractChain([]string{fullFile, incrementalFile}, dest, ExtractOptions{}); !errors.Is(err, ErrExists) {
+		t.Errorf("ExtractChain with never = %v, want ErrExists", err)
+	}
+	if _, err := ExtractChain([]string{fullFile, incrementalFile}, dest, ExtractOptions{Overwrite: OverwriteSkip}); err != nil {
+		t.Fatalf("ExtractChain with skip failed: %v", err)
+	}
+	if content, _ := os.ReadFile(filepath.Join(dest, "media", "documents", "changed.pdf")); string(content) != "local" {
+		t.Errorf("skip replaced an existing file: %q", content)
+	}
+}
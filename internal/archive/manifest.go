@@ -0,0 +1,128 @@
+package archive
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+)
+
+// MetaDir is the reserved top-level directory holding the tool's own entries
+const MetaDir = ".paperless-backup"
+
+// ManifestName is the archive path of the per-file SHA-256 manifest
+const ManifestName = MetaDir + "/manifest.sha256"
+
+// ChecksumExt is appended to an archive path to name its sha256sum sidecar
+const ChecksumExt = ".sha256"
+
+// ManifestEntry holds the SHA-256 of a single archived file
+type ManifestEntry struct {
+	Path string
+	Sum  string
+}
+
+// Manifest lists the content hashes of all regular files in an archive,
+// in archive order
+type Manifest struct {
+	Entries []ManifestEntry
+}
+
+// Add records the hash of an archived file
+func (m *Manifest) Add(path string, sum []byte) {
+	m.Entries = append(m.Entries, ManifestEntry{Path: path, Sum: hex.EncodeToString(sum)})
+}
+
+// Lookup returns a map from archive path to hex encoded hash
+func (m *Manifest) Lookup() map[string]string {
+	sums := make(map[string]string, len(m.Entries))
+	for _, entry := range m.Entries {
+		sums[entry.Path] = entry.Sum
+	}
+	return sums
+}
+
+// WriteTo writes the manifest in sha256sum format, so an extracted archive
+// can be checked with `sha256sum -c`
+func (m *Manifest) WriteTo(w io.Writer) (int64, error) {
+	var written int64
+	for _, entry := range m.Entries {
+		// sha256sum escapes names containing backslashes or newlines and
+		// marks such lines with a leading backslash
+		line := fmt.Sprintf("%s  %s\n", entry.Sum, entry.Path)
+		if strings.ContainsAny(entry.Path, "\\\n") {
+			escaped := strings.NewReplacer("\\", "\\\\", "\n", "\\n").Replace(entry.Path)
+			line = fmt.Sprintf("\\%s  %s\n", entry.Sum, escaped)
+		}
+
+		n, err := io.WriteString(w, line)
+		written += int64(n)
+		if err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
+
+// Bytes returns the manifest in sha256sum format
+func (m *Manifest) Bytes() []byte {
+	var sb strings.Builder
+	m.WriteTo(&sb)
+	return []byte(sb.String())
+}
+
+// ParseManifest reads a manifest in sha256sum format
+func ParseManifest(r io.Reader) (*Manifest, error) {
+	m := &Manifest{}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := scanner.Text()
+		if line == "" {
+			continue
+		}
+
+		escaped := strings.HasPrefix(line, "\\")
+		if escaped {
+			line = line[1:]
+		}
+
+		sum, name, ok := strings.Cut(line, "  ")
+		if !ok || len(sum) != sha256.Size*2 {
+			return nil, fmt.Errorf("malformed manifest line %d", lineNo)
+		}
+		if _, err := hex.DecodeString(sum); err != nil {
+			return nil, fmt.Errorf("malformed manifest line %d: %w", lineNo, err)
+		}
+		if escaped {
+			name = strings.NewReplacer("\\\\", "\\", "\\n", "\n").Replace(name)
+		}
+
+		m.Entries = append(m.Entries, ManifestEntry{Path: name, Sum: sum})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read manifest: %w", err)
+	}
+
+	return m, nil
+}
+
+// ChecksumPath returns the path of the sha256sum sidecar for an archive
+func ChecksumPath(archivePath string) string {
+	return archivePath + ChecksumExt
+}
+
+// writeChecksumFile writes a sha256sum compatible sidecar next to the archive
+func writeChecksumFile(archivePath string, sum []byte) error {
+	line := fmt.Sprintf("%s  %s\n", hex.EncodeToString(sum), filepath.Base(archivePath))
+	if err := writeFileAtomic(ChecksumPath(archivePath), []byte(line), 0644); err != nil {
+		return fmt.Errorf("failed to write checksum file: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,51 @@
+package archive
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"strings"
+	"testing"
+)
+
+func TestManifestRoundTrip(t *testing.T) {
+	m := &Manifest{}
+	sum1 := sha256.Sum256([]byte("one"))
+	sum2 := sha256.Sum256([]byte("two"))
+	m.Add("data/plain.txt", sum1[:])
+	m.Add("media/odd\\name\nwith newline", sum2[:])
+
+	var buf bytes.Buffer
+	if _, err := m.WriteTo(&buf); err != nil {
+		t.Fatalf("WriteTo failed: %v", err)
+	}
+
+	if !strings.HasPrefix(strings.Split(buf.String(), "\n")[1], "\\") {
+		t.Error("Escaped entries should be marked with a leading backslash")
+	}
+
+	parsed, err := ParseManifest(&buf)
+	if err != nil {
+		t.Fatalf("ParseManifest failed: %v", err)
+	}
+
+	if len(parsed.Entries) != 2 {
+		t.Fatalf("Expected 2 entries, got %d", len(parsed.Entries))
+	}
+	for i, entry := range m.Entries {
+		if parsed.Entries[i] != entry {
+			t.Errorf("Entry %d: expected %+v, got %+v", i, entry, parsed.Entries[i])
+		}
+	}
+}
+
+func TestParseManifestMalformed(t *testing.T) {
+	inputs := []string{
+		"nothash  data/file\n",
+		"0123456789abcdef data/file\n",
+	}
+	for _, input := range inputs {
+		if _, err := ParseManifest(strings.NewReader(input)); err == nil {
+			t.Errorf("ParseManifest should fail for %q", input)
+		}
+	}
+}
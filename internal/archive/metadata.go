@@ -0,0 +1,218 @@
+package archive
+
+import (
+	"archive/tar"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"filippo.io/age"
+)
+
+// MetadataName is the archive path of the metadata document, the first
+// entry of every archive
+const MetadataName = MetaDir + "/metadata.json"
+
+// CompletionName is the archive path of the completion record, written
+// before the manifest once all content is archived
+const CompletionName = MetaDir + "/completion.json"
+
+// MetadataFormat is the version of the metadata document
+const MetadataFormat = 1
+
+// now returns the times recorded in the metadata, replaced in tests
+var now = time.Now
+
+// ErrNoMetadata is returned for archives written before metadata was
+// embedded
+var ErrNoMetadata = errors.New("archive has no metadata")
+
+// Metadata describes how and from what an archive was written. Tool,
+// Container and Config are provided by the caller through SetMetadata, the
+// rest is filled in by Create.
+type Metadata struct {
+	Format      int               `json:"format"`
+	Tool        string            `json:"tool"`               // Version of the writing tool
+	Hostname    string            `json:"hostname,omitempty"` // Only with PreserveMetadata
+	Started     time.Time         `json:"started"`            // Zero without PreserveMetadata
+	Compression CompressionInfo   `json:"compression"`
+	Encryption  *EncryptionInfo   `json:"encryption,omitempty"`
+	PartSize    int64             `json:"part_size,omitempty"`
+	Sources     []SourceInfo      `json:"sources"`
+	Container   *ContainerInfo    `json:"container,omitempty"`
+	Config      json.RawMessage   `json:"config,omitempty"` // Configuration of the run
+	Completion  *CompletionRecord `json:"completion,omitempty"`
+}
+
+// CompressionInfo records the codec parameters
+type CompressionInfo struct {
+	Codec   string `json:"codec"`
+	Level   int    `json:"level"` // 0 is the codec default
+	Workers int    `json:"workers"`
+}
+
+// EncryptionInfo records how an archive was encrypted. Only public keys are
+// recorded, never key material.
+type EncryptionInfo struct {
+	Mode       string   `json:"mode"` // "recipients" or "passphrase"
+	Recipients []string `json:"recipients,omitempty"`
+}
+
+// SourceInfo records a source and where it was read from
+type SourceInfo struct {
+	Name       string   `json:"name"`
+	Mountpoint string   `json:"mountpoint"`
+	Include    []string `json:"include,omitempty"`
+	Exclude    []string `json:"exclude,omitempty"`
+	ReadErrors string   `json:"read_errors,omitempty"`
+	Base       string   `json:"base,omitempty"` // Backup an incremental source builds on
+}
+
+// ContainerInfo identifies the application container the sources belong to
+type ContainerInfo struct {
+	Name   string `json:"name"`
+	Image  string `json:"image"`
+	Digest string `json:"digest,omitempty"` // Repository digest, or image ID for local builds
+}
+
+// CompletionRecord is only known once all content is archived, so it is
+// stored at the end of the archive
+type CompletionRecord struct {
+	Finished time.Time `json:"finished"` // Zero without PreserveMetadata
+	Files    int       `json:"files"`    // Regular files in the manifest
+	Warnings int       `json:"warnings"`
+}
+
+// SetMetadata sets the caller's part of the metadata of the next Create
+func (c *Creator) SetMetadata(metadata Metadata) {
+	c.metadata = metadata
+}
+
+// newMetadata completes the caller's metadata for an archive of sources
+func (c *Creator) newMetadata(sources []Source) *Metadata {
+	metadata := c.metadata
+	metadata.Format = MetadataFormat
+	metadata.Started = c.runTime()
+	metadata.Completion = nil
+	if metadata.Hostname == "" && c.options.PreserveMetadata {
+		metadata.Hostname, _ = os.Hostname()
+	}
+
+	metadata.Compression = CompressionInfo{
+		Codec:   c.options.Codec.Name,
+		Level:   c.options.CompressionLevel,
+		Workers: c.options.Workers,
+	}
+	if e := c.options.Encryptor; e != nil {
+		metadata.Encryption = &EncryptionInfo{Mode: e.Mode(), Recipients: e.recipientKeys()}
+	}
+	metadata.PartSize = c.options.PartSize
+
+	metadata.Sources = nil
+	for _, source := range sources {
+		info := SourceInfo{
+			Name:       source.Name,
+			Mountpoint: source.Path,
+			Include:    source.Filter.Include,
+			Exclude:    source.Filter.Exclude,
+			ReadErrors: source.ReadErrors,
+		}
+		if source.Base != nil {
+			info.Base = source.Base.Archive
+		}
+		metadata.Sources = append(metadata.Sources, info)
+	}
+	return &metadata
+}
+
+// runTime returns the current time for the metadata. Without
+// PreserveMetadata the run is not recorded, so identical content still
+// produces identical archives.
+func (c *Creator) runTime() time.Time {
+	if !c.options.PreserveMetadata {
+		return time.Time{}
+	}
+	return now().UTC()
+}
+
+// recipientKeys returns the public keys of recipient mode
+func (e *Encryptor) recipientKeys() []string {
+	var keys []string
+	for _, recipient := range e.recipients {
+		if x, ok := recipient.(*age.X25519Recipient); ok {
+			keys = append(keys, x.String())
+		}
+	}
+	return keys
+}
+
+// writeJSONEntry stores v as a regular file entry
+func writeJSONEntry(tarWriter *tarStream, name string, v any) error {
+	content, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	header := &tar.Header{
+		Name:     name,
+		Mode:     0644,
+		Size:     int64(len(content)),
+		Typeflag: tar.TypeReg,
+	}
+	if err := tarWriter.WriteHeader(header); err != nil {
+		return fmt.Errorf("failed to write %s: %w", name, err)
+	}
+	if _, err := tarWriter.Write(content); err != nil {
+		return fmt.Errorf("failed to write %s: %w", name, err)
+	}
+	return nil
+}
+
+// ReadMetadata reads the metadata document of an archive. Only the first
+// entry is decompressed; the completion record is read as well when
+// complete is set, which reads the whole archive.
+func ReadMetadata(archivePath string, encryptor *Encryptor, complete bool) (*Metadata, error) {
+	ar, err := openArchive(archivePath, encryptor)
+	if err != nil {
+		return nil, err
+	}
+	defer ar.Close()
+
+	header, err := ar.Next()
+	if err == io.EOF || (err == nil && header.Name != MetadataName) {
+		return nil, ErrNoMetadata
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read archive: %w", err)
+	}
+
+	var metadata Metadata
+	if err := json.NewDecoder(ar).Decode(&metadata); err != nil {
+		return nil, fmt.Errorf("invalid metadata: %w", err)
+	}
+	if !complete {
+		return &metadata, nil
+	}
+
+	for {
+		header, err := ar.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read archive: %w", err)
+		}
+		if header.Name == CompletionName {
+			metadata.Completion = &CompletionRecord{}
+			if err := json.NewDecoder(ar).Decode(metadata.Completion); err != nil {
+				return nil, fmt.Errorf("invalid completion record: %w", err)
+			}
+		}
+	}
+	if err := ar.finish(); err != nil {
+		return nil, err
+	}
+	return &metadata, nil
+}
@@ -0,0 +1,153 @@
+package archive
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"paperless-backup/internal/logger"
+
+	"filippo.io/age"
+)
+
+func TestMetadataRoundTrip(t *testing.T) {
+	tmpDir := t.TempDir()
+	log, _ := logger.New(filepath.Join(tmpDir, "test.log"))
+	defer log.Close()
+
+	clock := time.Date(2024, 1, 1, 3, 0, 0, 0, time.UTC)
+	now = func() time.Time {
+		clock = clock.Add(time.Minute)
+		return clock
+	}
+	defer func() { now = time.Now }()
+
+	identity, _ := age.GenerateX25519Identity()
+	identityFile := filepath.Join(tmpDir, "identity.txt")
+	os.WriteFile(identityFile, []byte(identity.String()+"\n"), 0600)
+	encryptor, err := NewEncryptor([]string{identity.Recipient().String()}, "", identityFile)
+	if err != nil {
+		t.Fatalf("NewEncryptor failed: %v", err)
+	}
+
+	sourceDir := filepath.Join(tmpDir, "source")
+	os.MkdirAll(sourceDir, 0755)
+	os.WriteFile(filepath.Join(sourceDir, "0001.pdf"), []byte("pdf"), 0644)
+
+	creator := New(log, Options{Codec: zstdCodec, CompressionLevel: 3, Workers: 2, Encryptor: encryptor, PreserveMetadata: true})
+	creator.SetMetadata(Metadata{
+		Tool:      "1.2.3",
+		Container: &ContainerInfo{Name: "paperless-webserver-1", Image: "ghcr.io/paperless-ngx/paperless-ngx:2.7"},
+		Config:    json.RawMessage(`{"Compression":"zstd"}`),
+	})
+	archivePath := filepath.Join(tmpDir, "backup"+creator.Extension())
+	err = creator.Create(context.Background(), archivePath, []Source{{
+		Name:       "media",
+		Path:       sourceDir,
+		Filter:     Filter{Exclude: []string{"documents/thumbnails"}},
+		ReadErrors: ReadErrorsTolerant,
+	}})
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+
+	metadata, err := ReadMetadata(archivePath, encryptor, false)
+	if err != nil {
+		t.Fatalf("ReadMetadata failed: %v", err)
+	}
+	if metadata.Format != MetadataFormat || metadata.Tool != "1.2.3" || metadata.Hostname == "" {
+		t.Errorf("Unexpected metadata: %+v", metadata)
+	}
+	if !metadata.Started.Equal(time.Date(2024, 1, 1, 3, 1, 0, 0, time.UTC)) {
+		t.Errorf("Started = %s", metadata.Started)
+	}
+	if metadata.Compression != (CompressionInfo{Codec: "zstd", Level: 3, Workers: 2}) {
+		t.Errorf("Compression = %+v", metadata.Compression)
+	}
+	if e := metadata.Encryption; e == nil || e.Mode != "recipients" || len(e.Recipients) != 1 || e.Recipients[0] != identity.Recipient().String() {
+		t.Errorf("Encryption = %+v", metadata.Encryption)
+	}
+	if len(metadata.Sources) != 1 || metadata.Sources[0].Mountpoint != sourceDir ||
+		metadata.Sources[0].Exclude[0] != "documents/thumbnails" || metadata.Sources[0].ReadErrors != ReadErrorsTolerant {
+		t.Errorf("Sources = %+v", metadata.Sources)
+	}
+	var config bytes.Buffer
+	json.Compact(&config, metadata.Config)
+	if metadata.Container == nil || metadata.Container.Image != "ghcr.io/paperless-ngx/paperless-ngx:2.7" || config.String() != `{"Compression":"zstd"}` {
+		t.Errorf("Caller metadata not stored: %+v, %s", metadata.Container, metadata.Config)
+	}
+	if metadata.Completion != nil {
+		t.Error("The completion record should only be read on request")
+	}
+
+	metadata, err = ReadMetadata(archivePath, encryptor, true)
+	if err != nil {
+		t.Fatalf("ReadMetadata failed: %v", err)
+	}
+	if c := metadata.Completion; c == nil || !c.Finished.After(metadata.Started) || c.Files != 1 || c.Warnings != 0 {
+		t.Errorf("Completion = %+v", metadata.Completion)
+	}
+
+	// Without a key even the metadata cannot be read
+	if _, err := ReadMetadata(archivePath, nil, false); !errors.Is(err, ErrMissingKey) {
+		t.Errorf("Expected ErrMissingKey, got %v", err)
+	}
+}
+
+func TestMetadataIsFirstEntry(t *testing.T) {
+	tmpDir := t.TempDir()
+	log, _ := logger.New(filepath.Join(tmpDir, "test.log"))
+	defer log.Close()
+
+	sourceDir := filepath.Join(tmpDir, "source")
+	os.MkdirAll(sourceDir, 0755)
+	os.WriteFile(filepath.Join(sourceDir, "a.txt"), []byte("a"), 0644)
+
+	archivePath := filepath.Join(tmpDir, "backup.tar")
+	creator := New(log, Options{Codec: noneCodec})
+	if err := creator.Create(context.Background(), archivePath, []Source{{Name: "data", Path: sourceDir}}); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+
+	file, _ := os.Open(archivePath)
+	defer file.Close()
+	var names []string
+	tr := tar.NewReader(file)
+	for header, err := tr.Next(); err == nil; header, err = tr.Next() {
+		names = append(names, header.Name)
+	}
+	if len(names) < 3 || names[0] != MetadataName || names[len(names)-2] != CompletionName || names[len(names)-1] != ManifestName {
+		t.Errorf("Unexpected entry order: %v", names)
+	}
+
+	// Metadata entries are not restored as content
+	result, err := Extract(archivePath, filepath.Join(tmpDir, "restore"), ExtractOptions{})
+	if err != nil || result.Files != 1 {
+		t.Errorf("Extract = %+v, %v", result, err)
+	}
+	if _, err := os.Stat(filepath.Join(tmpDir, "restore", MetadataName)); !os.IsNotExist(err) {
+		t.Error("Metadata should not be extracted")
+	}
+}
+
+func TestReadMetadataOfOlderArchive(t *testing.T) {
+	tmpDir := t.TempDir()
+	archivePath := filepath.Join(tmpDir, "old.tar")
+
+	file, _ := os.Create(archivePath)
+	tw := tar.NewWriter(file)
+	tw.WriteHeader(&tar.Header{Name: "data/a.txt", Mode: 0644, Size: 1, Typeflag: tar.TypeReg})
+	tw.Write([]byte("a"))
+	tw.Close()
+	file.Close()
+
+	if _, err := ReadMetadata(archivePath, nil, false); !errors.Is(err, ErrNoMetadata) {
+		t.Errorf("Expected ErrNoMetadata, got %v", err)
+	}
+}
@@ -0,0 +1,158 @@
+package archive
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"sync"
+)
+
+// parallelBlockSize is the amount of uncompressed data per independently
+// compressed block. Output only depends on this size, never on the worker
+// count, so archives stay reproducible across machines.
+const parallelBlockSize = 4 << 20
+
+// blockResult carries a compressed block back to the ordered output
+type blockResult struct {
+	data []byte
+	err  error
+}
+
+// parallelWriter compresses fixed-size blocks on multiple cores and writes
+// them in order as concatenated members. Concatenated gzip members, zstd
+// frames and xz streams are all valid single files for standard tools.
+type parallelWriter struct {
+	w      io.Writer
+	codec  *Codec
+	level  int
+	block  []byte
+	blocks int
+	queue  chan chan blockResult
+	done   chan struct{}
+	pool   sync.Pool
+	closed bool
+
+	mu  sync.Mutex
+	err error
+}
+
+// newParallelWriter creates a writer compressing with up to workers blocks
+// in flight
+func newParallelWriter(w io.Writer, codec *Codec, level, workers int) *parallelWriter {
+	p := &parallelWriter{
+		w:     w,
+		codec: codec,
+		level: level,
+		queue: make(chan chan blockResult, workers),
+		done:  make(chan struct{}),
+	}
+	p.pool.New = func() interface{} {
+		return make([]byte, 0, parallelBlockSize)
+	}
+	p.block = p.pool.Get().([]byte)
+
+	go p.output()
+	return p
+}
+
+// output writes compressed blocks in submission order
+func (p *parallelWriter) output() {
+	defer close(p.done)
+
+	for result := range p.queue {
+		r := <-result
+		if p.failed() != nil {
+			continue
+		}
+		if r.err != nil {
+			p.setErr(r.err)
+			continue
+		}
+		if _, err := p.w.Write(r.data); err != nil {
+			p.setErr(err)
+		}
+	}
+}
+
+func (p *parallelWriter) setErr(err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.err == nil {
+		p.err = err
+	}
+}
+
+func (p *parallelWriter) failed() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.err
+}
+
+// submit hands a full block to a compression worker
+func (p *parallelWriter) submit(block []byte) {
+	result := make(chan blockResult, 1)
+	p.queue <- result // Blocks while all workers are busy
+	p.blocks++
+
+	go func() {
+		var buf bytes.Buffer
+		buf.Grow(len(block) / 2)
+
+		compWriter, err := p.codec.NewWriter(&buf, p.level)
+		if err == nil {
+			_, err = compWriter.Write(block)
+			if closeErr := compWriter.Close(); err == nil {
+				err = closeErr
+			}
+		}
+
+		p.pool.Put(block[:0])
+		result <- blockResult{data: buf.Bytes(), err: err}
+	}()
+}
+
+// Write buffers data and submits every completed block
+func (p *parallelWriter) Write(data []byte) (int, error) {
+	if p.closed {
+		return 0, errors.New("write to closed parallel writer")
+	}
+
+	written := 0
+	for len(data) > 0 {
+		if err := p.failed(); err != nil {
+			return written, err
+		}
+
+		n := parallelBlockSize - len(p.block)
+		if n > len(data) {
+			n = len(data)
+		}
+		p.block = append(p.block, data[:n]...)
+		data = data[n:]
+		written += n
+
+		if len(p.block) == parallelBlockSize {
+			p.submit(p.block)
+			p.block = p.pool.Get().([]byte)
+		}
+	}
+
+	return written, nil
+}
+
+// Close compresses the remaining data and waits for all blocks to be written
+func (p *parallelWriter) Close() error {
+	if p.closed {
+		return p.failed()
+	}
+	p.closed = true
+
+	// An empty stream still needs one member to be a valid compressed file
+	if len(p.block) > 0 || p.blocks == 0 {
+		p.submit(p.block)
+	}
+
+	close(p.queue)
+	<-p.done
+	return p.failed()
+}
@@ -0,0 +1,117 @@
+package archive
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"testing"
+)
+
+// testPayload returns compressible data spanning several parallel blocks
+func testPayload(size int) []byte {
+	rng := rand.New(rand.NewSource(1))
+	words := []string{"paperless ", "document ", "invoice ", "receipt ", "tax "}
+	var buf bytes.Buffer
+	for buf.Len() < size {
+		buf.WriteString(words[rng.Intn(len(words))])
+	}
+	return buf.Bytes()[:size]
+}
+
+func TestParallelWriterRoundTrip(t *testing.T) {
+	payload := testPayload(2*parallelBlockSize + 12345)
+
+	for _, codec := range []*Codec{gzipCodec, zstdCodec, xzCodec} {
+		t.Run(codec.Name, func(t *testing.T) {
+			var compressed bytes.Buffer
+			writer := newParallelWriter(&compressed, codec, 0, 4)
+
+			// Write in odd chunk sizes to exercise block boundaries
+			for data := payload; len(data) > 0; {
+				n := 100003
+				if n > len(data) {
+					n = len(data)
+				}
+				if _, err := writer.Write(data[:n]); err != nil {
+					t.Fatalf("Write failed: %v", err)
+				}
+				data = data[n:]
+			}
+			if err := writer.Close(); err != nil {
+				t.Fatalf("Close failed: %v", err)
+			}
+
+			reader, err := codec.NewReader(&compressed)
+			if err != nil {
+				t.Fatalf("NewReader failed: %v", err)
+			}
+			defer reader.Close()
+
+			decompressed, err := io.ReadAll(reader)
+			if err != nil {
+				t.Fatalf("Decompression failed: %v", err)
+			}
+			if !bytes.Equal(decompressed, payload) {
+				t.Errorf("Round trip mismatch: got %d bytes, want %d", len(decompressed), len(payload))
+			}
+		})
+	}
+}
+
+func TestParallelWriterDeterministic(t *testing.T) {
+	payload := testPayload(parallelBlockSize + 999)
+
+	compress := func(workers int) []byte {
+		var compressed bytes.Buffer
+		writer := newParallelWriter(&compressed, gzipCodec, 0, workers)
+		writer.Write(payload)
+		writer.Close()
+		return compressed.Bytes()
+	}
+
+	if !bytes.Equal(compress(2), compress(8)) {
+		t.Error("Output should not depend on the worker count")
+	}
+}
+
+func TestCompressorIndependentOfWorkers(t *testing.T) {
+	payload := testPayload(2*parallelBlockSize + 4321)
+
+	compress := func(codec *Codec, workers int) []byte {
+		creator := New(nil, Options{Codec: codec, Workers: workers})
+		var compressed bytes.Buffer
+		writer, err := creator.newCompressor(&compressed)
+		if err != nil {
+			t.Fatalf("newCompressor failed: %v", err)
+		}
+		writer.Write(payload)
+		if err := writer.Close(); err != nil {
+			t.Fatalf("Close failed: %v", err)
+		}
+		return compressed.Bytes()
+	}
+
+	for _, codec := range []*Codec{gzipCodec, zstdCodec, xzCodec} {
+		if !bytes.Equal(compress(codec, 1), compress(codec, 4)) {
+			t.Errorf("%s output differs between 1 and 4 workers", codec.Name)
+		}
+	}
+}
+
+func TestParallelWriterEmpty(t *testing.T) {
+	var compressed bytes.Buffer
+	writer := newParallelWriter(&compressed, gzipCodec, 0, 2)
+	if err := writer.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	reader, err := gzipCodec.NewReader(&compressed)
+	if err != nil {
+		t.Fatalf("Empty stream should still be valid gzip: %v", err)
+	}
+	defer reader.Close()
+
+	if data, _ := io.ReadAll(reader); len(data) != 0 {
+		t.Errorf("Expected empty output, got %d bytes", len(data))
+	}
+}
@@ -0,0 +1,786 @@
+package archive
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/klauspost/reedsolomon"
+)
+
+// ParityExt is appended to an archive or part path to name its parity
+// sidecar
+const ParityExt = ".parity"
+
+// Parity sidecars split a file into blocks and protect stripes of
+// parityDataShards blocks with Reed-Solomon parity blocks. The blocks of a
+// stripe are spread over a group of up to parityGroupStripes stripes, so a
+// contiguous run of damaged blocks costs each stripe as few blocks as
+// possible. The header and the block hash table are stored at both ends of
+// the sidecar and checksummed per chunk, so damage to them is repairable
+// from the other copy:
+//
+//	header | hash table | parity blocks | hash table | header
+const (
+	parityMagic        = "paperless-backup parity 1\n"
+	parityHeaderSize   = 512  // Fixed size of a header record
+	parityChunkSize    = 4096 // Hash table bytes per checksummed chunk
+	parityBlockSize    = 64 << 10
+	parityDataShards   = 20
+	parityGroupStripes = 64
+)
+
+var (
+	// ErrNoParity is returned for backups written without parity data
+	ErrNoParity = errors.New("no parity data")
+	// ErrUnrepairable is returned when damage exceeds the parity data
+	ErrUnrepairable = errors.New("damage exceeds parity data")
+)
+
+// ParityPath returns the path of the parity sidecar of an archive or part
+func ParityPath(path string) string {
+	return path + ParityExt
+}
+
+// ValidateParityPercent checks a parity redundancy in percent of the
+// archive size, 0 disables parity
+func ValidateParityPercent(percent int) error {
+	if percent < 0 || percent > 100 {
+		return fmt.Errorf("parity must be between 0 and 100 percent, got %d", percent)
+	}
+	return nil
+}
+
+// parityShards returns the parity blocks per stripe for a redundancy in
+// percent, rounded up
+func parityShards(percent int) int {
+	return (parityDataShards*percent + 99) / 100
+}
+
+// parityHeader describes the layout of a sidecar. Its record holds the
+// magic and a JSON line, padded to parityHeaderSize and ending in the
+// SHA-256 of what precedes it.
+type parityHeader struct {
+	BlockSize    int   `json:"block_size"`
+	DataShards   int   `json:"data_shards"`
+	ParityShards int   `json:"parity_shards"`
+	Size         int64 `json:"size"` // Size of the protected file
+}
+
+// valid checks a header read from a sidecar of fileSize bytes. The block
+// counts are bounded by the sidecar size before any product or allocation
+// depends on them.
+func (h *parityHeader) valid(fileSize int64) bool {
+	if h.BlockSize <= 0 || h.BlockSize > parityBlockSize || h.DataShards <= 0 || h.ParityShards <= 0 ||
+		h.DataShards+h.ParityShards > 256 || h.Size < 0 {
+		return false
+	}
+	// Every block has a hash in both tables, every parity block is stored
+	if h.Size/int64(h.BlockSize) >= fileSize/(2*sha256.Size) ||
+		int64(h.stripes())*int64(h.ParityShards) > fileSize/int64(h.BlockSize) {
+		return false
+	}
+	return h.sidecarSize() == fileSize
+}
+
+// hashesSize returns the size of the block hash table
+func (h *parityHeader) hashesSize() int {
+	return (h.blocks() + h.stripes()*h.ParityShards) * sha256.Size
+}
+
+// tableSize returns the stored size of one copy of the hash table with the
+// checksum of each chunk
+func (h *parityHeader) tableSize() int64 {
+	chunks := (h.hashesSize() + parityChunkSize - 1) / parityChunkSize
+	return int64(h.hashesSize() + chunks*sha256.Size)
+}
+
+// sidecarSize returns the size of a complete sidecar
+func (h *parityHeader) sidecarSize() int64 {
+	return 2*parityHeaderSize + 2*h.tableSize() + int64(h.stripes()*h.ParityShards)*int64(h.BlockSize)
+}
+
+// blocks returns the number of data blocks of the protected file
+func (h *parityHeader) blocks() int {
+	return int((h.Size + int64(h.BlockSize) - 1) / int64(h.BlockSize))
+}
+
+// groupWidth returns the number of stripes of a group
+func (h *parityHeader) groupWidth(group int) int {
+	remaining := h.blocks() - group*h.DataShards*parityGroupStripes
+	if remaining >= h.DataShards*parityGroupStripes {
+		return parityGroupStripes
+	}
+	return (remaining + h.DataShards - 1) / h.DataShards
+}
+
+// stripes returns the number of stripes of the protected file
+func (h *parityHeader) stripes() int {
+	span := h.DataShards * parityGroupStripes
+	groups := (h.blocks() + span - 1) / span
+	if groups == 0 {
+		return 0
+	}
+	return (groups-1)*parityGroupStripes + h.groupWidth(groups-1)
+}
+
+// locate returns the stripe of a data block and its shard index within it
+func (h *parityHeader) locate(block int) (int, int) {
+	span := h.DataShards * parityGroupStripes
+	group, k := block/span, block%span
+	width := h.groupWidth(group)
+	return group*parityGroupStripes + k%width, k / width
+}
+
+// stripeBlocks returns the data block of each shard of a stripe, -1 for
+// the zero blocks padding the last group
+func (h *parityHeader) stripeBlocks(stripe int) []int {
+	group, s := stripe/parityGroupStripes, stripe%parityGroupStripes
+	first := group * h.DataShards * parityGroupStripes
+	width := h.groupWidth(group)
+
+	blocks := make([]int, h.DataShards)
+	for row := range blocks {
+		blocks[row] = first + row*width + s
+		if blocks[row] >= h.blocks() {
+			blocks[row] = -1
+		}
+	}
+	return blocks
+}
+
+// blockLen returns the length of a data block, the last one may be short
+func (h *parityHeader) blockLen(block int) int {
+	if rest := h.Size - int64(block)*int64(h.BlockSize); rest < int64(h.BlockSize) {
+		return int(rest)
+	}
+	return h.BlockSize
+}
+
+// ParityReport describes the state of a file protected by parity data
+type ParityReport struct {
+	Path          string
+	Blocks        int  // Data blocks of the file
+	Damaged       int  // Data blocks that do not match their hash
+	DamagedParity int  // Parity blocks that do not match their hash
+	DamagedMeta   int  // Damaged copies of the header and of hash table chunks
+	Unrepairable  int  // Stripes with more damaged blocks than parity blocks
+	Resized       bool // The file size differs from the protected size
+	Repaired      bool // Damage was repaired
+}
+
+// OK reports whether the file and its parity data are intact
+func (r *ParityReport) OK() bool {
+	return r.Damaged == 0 && r.DamagedParity == 0 && r.DamagedMeta == 0 && !r.Resized
+}
+
+// Repairable reports whether all damage can be repaired from parity data
+func (r *ParityReport) Repairable() bool {
+	return r.Unrepairable == 0
+}
+
+// paritySidecar is an opened parity sidecar
+type paritySidecar struct {
+	file    *os.File
+	header  parityHeader
+	hashes  []byte // SHA-256 of each data block, then of each parity block
+	offset  int64  // Offset of the first parity block
+	damaged int    // Damaged copies of the header and of hash table chunks
+}
+
+// newParitySidecar lays out the sidecar described by header
+func newParitySidecar(header parityHeader) *paritySidecar {
+	return &paritySidecar{
+		header: header,
+		hashes: make([]byte, header.hashesSize()),
+		offset: parityHeaderSize + header.tableSize(),
+	}
+}
+
+// tableOffsets returns the offsets of both copies of the hash table
+func (p *paritySidecar) tableOffsets() [2]int64 {
+	parity := int64(p.header.stripes()*p.header.ParityShards) * int64(p.header.BlockSize)
+	return [2]int64{parityHeaderSize, p.offset + parity}
+}
+
+// dataHash returns the expected hash of a data block
+func (p *paritySidecar) dataHash(block int) []byte {
+	return p.hashes[block*sha256.Size : (block+1)*sha256.Size]
+}
+
+// parityHash returns the expected hash of a parity block
+func (p *paritySidecar) parityHash(index int) []byte {
+	i := p.header.blocks() + index
+	return p.hashes[i*sha256.Size : (i+1)*sha256.Size]
+}
+
+// parityOffset returns the sidecar offset of a parity block
+func (p *paritySidecar) parityOffset(index int) int64 {
+	return p.offset + int64(index)*int64(p.header.BlockSize)
+}
+
+// dataFiles returns the files holding the stream of a backup given its
+// archive or index path: the archive itself or the parts of a split archive
+func dataFiles(backupPath string) []string {
+	archivePath := strings.TrimSuffix(backupPath, IndexExt)
+	index, err := readIndex(IndexPath(archivePath))
+	if err != nil {
+		return []string{archivePath}
+	}
+
+	files := make([]string, 0, len(index.Parts))
+	dir := filepath.Dir(archivePath)
+	for _, part := range index.Parts {
+		files = append(files, filepath.Join(dir, part.Name))
+	}
+	return files
+}
+
+// WriteParity writes a parity sidecar for every file of a backup with the
+// given redundancy in percent of the file size
+func WriteParity(backupPath string, percent int) error {
+	if err := ValidateParityPercent(percent); err != nil {
+		return err
+	}
+	if percent == 0 {
+		return nil
+	}
+	for _, path := range dataFiles(backupPath) {
+		if err := writeParityFile(path, parityShards(percent)); err != nil {
+			return fmt.Errorf("failed to write parity data for %s: %w", filepath.Base(path), err)
+		}
+	}
+	return nil
+}
+
+// writeParityFile writes the parity sidecar of one file through a partial
+// file. Data blocks are read once, in order; only the parity of one group
+// of stripes is held in memory.
+func writeParityFile(path string, shards int) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	header := parityHeader{
+		BlockSize:    parityBlockSize,
+		DataShards:   parityDataShards,
+		ParityShards: shards,
+		Size:         info.Size(),
+	}
+	encoder, err := reedsolomon.New(header.DataShards, header.ParityShards)
+	if err != nil {
+		return err
+	}
+
+	partialPath := PartialPath(ParityPath(path))
+	out, err := os.OpenFile(partialPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		out.Close()
+		os.Remove(partialPath)
+	}()
+
+	// The layout only depends on the header, so the parity blocks can be
+	// placed before the hash table is known
+	sidecar := newParitySidecar(header)
+	sidecar.file = out
+	blocks := header.blocks()
+
+	reader := bufio.NewReaderSize(file, parityBlockSize)
+	data := make([]byte, parityBlockSize)
+	span := header.DataShards * parityGroupStripes
+	for first := 0; first < blocks; first += span {
+		group := first / span
+		parity := make([][][]byte, header.groupWidth(group))
+		for s := range parity {
+			parity[s] = make([][]byte, shards)
+			for p := range parity[s] {
+				parity[s][p] = make([]byte, parityBlockSize)
+			}
+		}
+
+		for block := first; block < blocks && block < first+span; block++ {
+			// The short last block is padded with zeros
+			length := header.blockLen(block)
+			clear(data[length:])
+			if _, err := io.ReadFull(reader, data[:length]); err != nil {
+				return err
+			}
+			sum := sha256.Sum256(data[:length])
+			copy(sidecar.dataHash(block), sum[:])
+
+			stripe, row := header.locate(block)
+			if err := encoder.EncodeIdx(data, row, parity[stripe%parityGroupStripes]); err != nil {
+				return err
+			}
+		}
+
+		for s := range parity {
+			stripe := group*parityGroupStripes + s
+			for p, block := range parity[s] {
+				index := stripe*shards + p
+				sum := sha256.Sum256(block)
+				copy(sidecar.parityHash(index), sum[:])
+				if _, err := out.WriteAt(block, sidecar.parityOffset(index)); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	if err := sidecar.writeMeta(); err != nil {
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	return publish(partialPath, ParityPath(path))
+}
+
+// encodeParityHeader returns the header record of a sidecar
+func encodeParityHeader(header *parityHeader) ([]byte, error) {
+	content, err := json.Marshal(header)
+	if err != nil {
+		return nil, err
+	}
+	record := append(append([]byte(parityMagic), content...), '\n')
+	if len(record) > parityHeaderSize-sha256.Size {
+		return nil, fmt.Errorf("parity header too long")
+	}
+	record = append(record, bytes.Repeat([]byte{' '}, parityHeaderSize-sha256.Size-len(record))...)
+	sum := sha256.Sum256(record)
+	return append(record, sum[:]...), nil
+}
+
+// decodeParityHeader parses a header record, nil when it is damaged
+func decodeParityHeader(record []byte) *parityHeader {
+	content := record[:parityHeaderSize-sha256.Size]
+	sum := sha256.Sum256(content)
+	if !bytes.Equal(sum[:], record[len(content):]) || !bytes.HasPrefix(content, []byte(parityMagic)) {
+		return nil
+	}
+	line, _, _ := bytes.Cut(content[len(parityMagic):], []byte{'\n'})
+	header := &parityHeader{}
+	if err := json.Unmarshal(line, header); err != nil {
+		return nil
+	}
+	return header
+}
+
+// encodeTable returns a stored copy of a hash table, each chunk followed by
+// its SHA-256
+func encodeTable(hashes []byte) []byte {
+	table := make([]byte, 0, len(hashes)+(len(hashes)/parityChunkSize+1)*sha256.Size)
+	for start := 0; start < len(hashes); start += parityChunkSize {
+		chunk := hashes[start:min(start+parityChunkSize, len(hashes))]
+		sum := sha256.Sum256(chunk)
+		table = append(append(table, chunk...), sum[:]...)
+	}
+	return table
+}
+
+// writeMeta writes both copies of the header and of the hash table
+func (p *paritySidecar) writeMeta() error {
+	record, err := encodeParityHeader(&p.header)
+	if err != nil {
+		return err
+	}
+	table := encodeTable(p.hashes)
+	offsets := p.tableOffsets()
+	for _, w := range []struct {
+		data   []byte
+		offset int64
+	}{
+		{record, 0},
+		{table, offsets[0]},
+		{table, offsets[1]},
+		{record, offsets[1] + int64(len(table))},
+	} {
+		if _, err := p.file.WriteAt(w.data, w.offset); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// openParity opens the parity sidecar of a file and reads its header and
+// hash table
+func openParity(path string, flag int) (*paritySidecar, error) {
+	file, err := os.OpenFile(ParityPath(path), flag, 0)
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("%s: %w", filepath.Base(path), ErrNoParity)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	sidecar, err := readParityHeader(file)
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("parity data of %s is damaged: %w", filepath.Base(path), err)
+	}
+	sidecar.file = file
+	return sidecar, nil
+}
+
+// readParityHeader reads the header and hash table of a sidecar from
+// whichever copy is intact and counts the damaged copies
+func readParityHeader(file *os.File) (*paritySidecar, error) {
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	size := info.Size()
+	if size < 2*parityHeaderSize {
+		return nil, fmt.Errorf("not a parity file")
+	}
+
+	// The trailing copy is found from the end, so a damaged leading one
+	// does not hide it
+	var header *parityHeader
+	damaged := 0
+	mismatch := false
+	for _, offset := range []int64{0, size - parityHeaderSize} {
+		record := make([]byte, parityHeaderSize)
+		if _, err := file.ReadAt(record, offset); err != nil {
+			return nil, err
+		}
+		decoded := decodeParityHeader(record)
+		switch {
+		case decoded == nil:
+			damaged++
+		case !decoded.valid(size):
+			mismatch = true
+			damaged++
+		case header == nil:
+			header = decoded
+		}
+	}
+	if header == nil && mismatch {
+		return nil, fmt.Errorf("header does not match the sidecar size %d", size)
+	}
+	if header == nil {
+		return nil, fmt.Errorf("both copies of the header are damaged")
+	}
+
+	sidecar := newParitySidecar(*header)
+	sidecar.damaged = damaged
+	var tables [2][]byte
+	for i, offset := range sidecar.tableOffsets() {
+		tables[i] = make([]byte, header.tableSize())
+		if _, err := file.ReadAt(tables[i], offset); err != nil {
+			return nil, err
+		}
+	}
+
+	// Each chunk is taken from the first copy whose checksum matches
+	for chunk, start := 0, 0; start < len(sidecar.hashes); chunk, start = chunk+1, start+parityChunkSize {
+		end := min(start+parityChunkSize, len(sidecar.hashes))
+		stored := start + chunk*sha256.Size
+		found := false
+		for _, table := range tables {
+			data := table[stored : stored+end-start]
+			sum := sha256.Sum256(data)
+			if !bytes.Equal(sum[:], table[stored+end-start:stored+end-start+sha256.Size]) {
+				sidecar.damaged++
+				continue
+			}
+			if !found {
+				copy(sidecar.hashes[start:end], data)
+				found = true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("both copies of hash table chunk %d are damaged", chunk)
+		}
+	}
+	return sidecar, nil
+}
+
+// parityDamage lists the damaged blocks of a file and its sidecar
+type parityDamage struct {
+	data    map[int]bool // Data block numbers
+	parity  map[int]bool // Parity block indexes
+	stripes map[int]int  // Damaged blocks per stripe
+}
+
+// CheckParity compares every file of a backup and its parity data against
+// the block hashes recorded in the sidecars
+func CheckParity(backupPath string) ([]*ParityReport, error) {
+	var reports []*ParityReport
+	for _, path := range dataFiles(backupPath) {
+		report, _, err := checkParityFile(path)
+		if err != nil {
+			return nil, err
+		}
+		reports = append(reports, report)
+	}
+	return reports, nil
+}
+
+// checkParityFile checks one file against its parity sidecar
+func checkParityFile(path string) (*ParityReport, *parityDamage, error) {
+	sidecar, err := openParity(path, os.O_RDONLY)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer sidecar.file.Close()
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open %s: %w", filepath.Base(path), err)
+	}
+	defer file.Close()
+
+	report, damage, err := sidecar.check(file)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to check %s: %w", filepath.Base(path), err)
+	}
+	report.Path = path
+	return report, damage, nil
+}
+
+// check reads a file and the parity blocks and records every block that
+// does not match its hash. Blocks missing from a truncated file count as
+// damaged.
+func (p *paritySidecar) check(file *os.File) (*ParityReport, *parityDamage, error) {
+	header := &p.header
+	report := &ParityReport{Blocks: header.blocks(), DamagedMeta: p.damaged}
+	damage := &parityDamage{data: make(map[int]bool), parity: make(map[int]bool), stripes: make(map[int]int)}
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, nil, err
+	}
+	report.Resized = info.Size() != header.Size
+
+	reader := bufio.NewReaderSize(file, header.BlockSize)
+	data := make([]byte, header.BlockSize)
+	for block := 0; block < report.Blocks; block++ {
+		n, err := io.ReadFull(reader, data[:header.blockLen(block)])
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return nil, nil, err
+		}
+		sum := sha256.Sum256(data[:n])
+		if n < header.blockLen(block) || !bytes.Equal(sum[:], p.dataHash(block)) {
+			damage.data[block] = true
+			stripe, _ := header.locate(block)
+			damage.stripes[stripe]++
+		}
+	}
+
+	for index := 0; index < header.stripes()*header.ParityShards; index++ {
+		n, err := p.file.ReadAt(data, p.parityOffset(index))
+		if err != nil && err != io.EOF {
+			return nil, nil, err
+		}
+		sum := sha256.Sum256(data[:n])
+		if n < header.BlockSize || !bytes.Equal(sum[:], p.parityHash(index)) {
+			damage.parity[index] = true
+			damage.stripes[index/header.ParityShards]++
+		}
+	}
+
+	report.Damaged = len(damage.data)
+	report.DamagedParity = len(damage.parity)
+	for _, count := range damage.stripes {
+		if count > header.ParityShards {
+			report.Unrepairable++
+		}
+	}
+	return report, damage, nil
+}
+
+// RepairParity reconstructs the damaged blocks of every file of a backup
+// from its parity data. Stripes with more damage than parity are left as
+// they are and reported; ErrUnrepairable is returned once all files were
+// processed when damage remains.
+func RepairParity(backupPath string) ([]*ParityReport, error) {
+	var reports []*ParityReport
+	unrepairable := false
+	for _, path := range dataFiles(backupPath) {
+		report, err := repairParityFile(path)
+		if err != nil {
+			return nil, err
+		}
+		reports = append(reports, report)
+		unrepairable = unrepairable || !report.OK()
+	}
+	if unrepairable {
+		return reports, ErrUnrepairable
+	}
+	return reports, nil
+}
+
+// repairParityFile repairs one file and its sidecar in place and returns
+// the state found afterwards. The modification time is kept, so backup
+// retention is not affected by a repair.
+func repairParityFile(path string) (*ParityReport, error) {
+	report, damage, err := checkParityFile(path)
+	if err != nil || report.OK() {
+		return report, err
+	}
+
+	sidecar, err := openParity(path, os.O_RDWR)
+	if err != nil {
+		return nil, err
+	}
+	defer sidecar.file.Close()
+
+	file, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", filepath.Base(path), err)
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := sidecar.repair(file, damage); err != nil {
+		return nil, fmt.Errorf("failed to repair %s: %w", filepath.Base(path), err)
+	}
+	if err := sidecar.writeMeta(); err != nil {
+		return nil, fmt.Errorf("failed to repair parity data of %s: %w", filepath.Base(path), err)
+	}
+	if err := file.Truncate(sidecar.header.Size); err != nil {
+		return nil, fmt.Errorf("failed to repair %s: %w", filepath.Base(path), err)
+	}
+	if err := file.Sync(); err != nil {
+		return nil, fmt.Errorf("failed to sync %s: %w", filepath.Base(path), err)
+	}
+	if err := sidecar.file.Sync(); err != nil {
+		return nil, fmt.Errorf("failed to sync parity data of %s: %w", filepath.Base(path), err)
+	}
+	os.Chtimes(path, info.ModTime(), info.ModTime())
+
+	repaired, _, err := checkParityFile(path)
+	if err != nil {
+		return nil, err
+	}
+	repaired.Repaired = true
+	return repaired, nil
+}
+
+// repair reconstructs every damaged stripe that has enough intact blocks
+// and writes the rebuilt data and parity blocks back in place
+func (p *paritySidecar) repair(file *os.File, damage *parityDamage) error {
+	header := &p.header
+	encoder, err := reedsolomon.New(header.DataShards, header.ParityShards)
+	if err != nil {
+		return err
+	}
+
+	for stripe, count := range damage.stripes {
+		if count > header.ParityShards {
+			continue
+		}
+
+		blocks := header.stripeBlocks(stripe)
+		shards := make([][]byte, header.DataShards+header.ParityShards)
+		for row, block := range blocks {
+			if block >= 0 && damage.data[block] {
+				continue
+			}
+			shards[row] = make([]byte, header.BlockSize)
+			if block < 0 {
+				continue
+			}
+			if _, err := file.ReadAt(shards[row][:header.blockLen(block)], int64(block)*int64(header.BlockSize)); err != nil {
+				return err
+			}
+		}
+		for i := 0; i < header.ParityShards; i++ {
+			index := stripe*header.ParityShards + i
+			if damage.parity[index] {
+				continue
+			}
+			shards[header.DataShards+i] = make([]byte, header.BlockSize)
+			if _, err := p.file.ReadAt(shards[header.DataShards+i], p.parityOffset(index)); err != nil {
+				return err
+			}
+		}
+
+		if err := encoder.Reconstruct(shards); err != nil {
+			return err
+		}
+
+		for row, block := range blocks {
+			if block < 0 || !damage.data[block] {
+				continue
+			}
+			if _, err := file.WriteAt(shards[row][:header.blockLen(block)], int64(block)*int64(header.BlockSize)); err != nil {
+				return err
+			}
+		}
+		for i := 0; i < header.ParityShards; i++ {
+			index := stripe*header.ParityShards + i
+			if !damage.parity[index] {
+				continue
+			}
+			if _, err := p.file.WriteAt(shards[header.DataShards+i], p.parityOffset(index)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// writeParity protects a published backup with parity sidecars
+func (c *Creator) writeParity(outputPath string) error {
+	c.logger.Logf("INFO", "Writing parity data (%d%% redundancy)...", c.options.ParityPercent)
+	if err := WriteParity(outputPath, c.options.ParityPercent); err != nil {
+		return err
+	}
+
+	var size int64
+	for _, path := range dataFiles(outputPath) {
+		if info, err := os.Stat(ParityPath(path)); err == nil {
+			size += info.Size()
+		}
+	}
+	c.logger.Logf("INFO", "Parity data written (%.2fMB)", float64(size)/1024/1024)
+	return nil
+}
+
+// parityHint adds to a failed verification whether the backup's parity
+// data can repair it
+func (c *Creator) parityHint(archivePath string, err error) error {
+	reports, checkErr := CheckParity(archivePath)
+	if checkErr != nil {
+		return err
+	}
+
+	damaged, repairable := 0, true
+	for _, report := range reports {
+		damaged += report.Damaged
+		repairable = repairable && report.Repairable()
+	}
+	if damaged == 0 {
+		return err
+	}
+	if !repairable {
+		c.logger.Logf("ERROR", "%d damaged blocks found, the damage exceeds the parity data", damaged)
+		return err
+	}
+	c.logger.Logf("WARN", "%d damaged blocks found, repairable with: paperless-backup repair %s", damaged, archivePath)
+	return fmt.Errorf("%w (repairable from parity data)", err)
+}
@@ -0,0 +1,324 @@
+package archive
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"paperless-backup/internal/logger"
+)
+
+// writeRandomFile writes incompressible content and returns it
+func writeRandomFile(t *testing.T, path string, size int) []byte {
+	t.Helper()
+	content := make([]byte, size)
+	rand.Read(content)
+	if err := os.WriteFile(path, content, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return content
+}
+
+// corrupt inverts length bytes of a file at offset
+func corrupt(t *testing.T, path string, offset int64, length int) {
+	t.Helper()
+	file, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	content := make([]byte, length)
+	file.ReadAt(content, offset)
+	for i := range content {
+		content[i] ^= 0xff
+	}
+	if _, err := file.WriteAt(content, offset); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestParityLayout(t *testing.T) {
+	// Sizes below, at and above one group of stripes
+	span := parityDataShards * parityGroupStripes
+	for _, blocks := range []int{1, 7, parityDataShards, span, span + 1, 2*span + 45} {
+		header := parityHeader{BlockSize: 1, DataShards: parityDataShards, Size: int64(blocks)}
+
+		seen := make(map[int]bool)
+		for stripe := 0; stripe < header.stripes(); stripe++ {
+			for row, block := range header.stripeBlocks(stripe) {
+				if block < 0 {
+					continue
+				}
+				if seen[block] {
+					t.Fatalf("%d blocks: block %d in more than one stripe", blocks, block)
+				}
+				seen[block] = true
+				if s, r := header.locate(block); s != stripe || r != row {
+					t.Errorf("%d blocks: locate(%d) = %d/%d, want %d/%d", blocks, block, s, r, stripe, row)
+				}
+			}
+		}
+		if len(seen) != blocks {
+			t.Errorf("%d blocks: stripes cover %d blocks", blocks, len(seen))
+		}
+	}
+
+	// Neighbouring blocks belong to different stripes
+	header := parityHeader{BlockSize: 1, DataShards: parityDataShards, Size: int64(span)}
+	first, _ := header.locate(0)
+	second, _ := header.locate(1)
+	if first == second {
+		t.Error("Adjacent blocks should be interleaved over stripes")
+	}
+}
+
+func TestParityShards(t *testing.T) {
+	tests := map[int]int{1: 1, 5: 1, 10: 2, 12: 3, 100: 20}
+	for percent, want := range tests {
+		if got := parityShards(percent); got != want {
+			t.Errorf("parityShards(%d) = %d, want %d", percent, got, want)
+		}
+	}
+	for _, percent := range []int{-1, 101} {
+		if ValidateParityPercent(percent) == nil {
+			t.Errorf("%d%% should be rejected", percent)
+		}
+	}
+}
+
+func TestRepairParity(t *testing.T) {
+	tmpDir := t.TempDir()
+	path := filepath.Join(tmpDir, "backup.tar.gz")
+	content := writeRandomFile(t, path, 50*parityBlockSize+1234)
+	modTime := time.Date(2024, 1, 1, 3, 0, 0, 0, time.UTC)
+
+	if err := WriteParity(path, 10); err != nil {
+		t.Fatalf("WriteParity failed: %v", err)
+	}
+	reports, err := CheckParity(path)
+	if err != nil || len(reports) != 1 || !reports[0].OK() || reports[0].Blocks != 51 {
+		t.Fatalf("CheckParity of an intact file = %+v, %v", reports[0], err)
+	}
+
+	// A burst over several blocks, a damaged parity block and a lost tail
+	corrupt(t, path, 3*parityBlockSize+100, 2*parityBlockSize)
+	sidecar, err := openParity(path, os.O_RDONLY)
+	if err != nil {
+		t.Fatalf("openParity failed: %v", err)
+	}
+	corrupt(t, ParityPath(path), sidecar.parityOffset(1)+5, 1)
+	sidecar.file.Close()
+	os.Truncate(path, int64(len(content))-parityBlockSize)
+	os.Chtimes(path, modTime, modTime)
+
+	reports, err = CheckParity(path)
+	if err != nil {
+		t.Fatalf("CheckParity failed: %v", err)
+	}
+	report := reports[0]
+	if report.OK() || !report.Repairable() || report.Damaged != 5 || report.DamagedParity != 1 || !report.Resized {
+		t.Errorf("Unexpected damage report: %+v", report)
+	}
+
+	reports, err = RepairParity(path)
+	if err != nil {
+		t.Fatalf("RepairParity failed: %v", err)
+	}
+	if !reports[0].OK() || !reports[0].Repaired {
+		t.Errorf("Unexpected repair report: %+v", reports[0])
+	}
+	if !bytes.Equal(mustRead(t, path), content) {
+		t.Error("Repaired content differs from the original")
+	}
+	if info, _ := os.Stat(path); !info.ModTime().Equal(modTime) {
+		t.Errorf("Repair changed the modification time to %s", info.ModTime())
+	}
+	if reports, _ := CheckParity(path); !reports[0].OK() {
+		t.Errorf("Parity data not repaired: %+v", reports[0])
+	}
+}
+
+func TestParityRepairsDamagedMetadata(t *testing.T) {
+	tmpDir := t.TempDir()
+	path := filepath.Join(tmpDir, "backup.tar.gz")
+	writeRandomFile(t, path, 300*parityBlockSize)
+	if err := WriteParity(path, 10); err != nil {
+		t.Fatalf("WriteParity failed: %v", err)
+	}
+	original := mustRead(t, ParityPath(path))
+
+	sidecar, err := openParity(path, os.O_RDONLY)
+	if err != nil {
+		t.Fatalf("openParity failed: %v", err)
+	}
+	tables := sidecar.tableOffsets()
+	sidecar.file.Close()
+
+	// The leading header and different chunks of both hash tables
+	corrupt(t, ParityPath(path), 30, 1)
+	corrupt(t, ParityPath(path), tables[0]+5, 1)
+	corrupt(t, ParityPath(path), tables[1]+parityChunkSize+sha256.Size+5, 1)
+
+	reports, err := CheckParity(path)
+	if err != nil {
+		t.Fatalf("CheckParity failed: %v", err)
+	}
+	if reports[0].OK() || reports[0].DamagedMeta != 3 || reports[0].Damaged != 0 {
+		t.Errorf("Unexpected damage report: %+v", reports[0])
+	}
+	if _, err := RepairParity(path); err != nil {
+		t.Fatalf("RepairParity failed: %v", err)
+	}
+	if !bytes.Equal(mustRead(t, ParityPath(path)), original) {
+		t.Error("Repaired parity data differs from the original")
+	}
+
+	// The same chunk damaged in both copies cannot be trusted
+	corrupt(t, ParityPath(path), tables[0]+5, 1)
+	corrupt(t, ParityPath(path), tables[1]+5, 1)
+	if _, err := RepairParity(path); err == nil || !strings.Contains(err.Error(), "parity data of backup.tar.gz is damaged") {
+		t.Errorf("Expected damaged parity data, got %v", err)
+	}
+	if err := WriteParity(filepath.Join(tmpDir, "missing.tar.gz"), 10); err == nil {
+		t.Error("WriteParity should fail for a missing file")
+	}
+}
+
+func TestParityRejectsInconsistentSize(t *testing.T) {
+	tmpDir := t.TempDir()
+	path := filepath.Join(tmpDir, "backup.tar.gz")
+	writeRandomFile(t, path, 10*parityBlockSize)
+	if err := WriteParity(path, 10); err != nil {
+		t.Fatalf("WriteParity failed: %v", err)
+	}
+
+	// Well-formed headers claiming a huge file must not be allocated for
+	info, _ := os.Stat(ParityPath(path))
+	record, err := encodeParityHeader(&parityHeader{BlockSize: 1, DataShards: 20, ParityShards: 2, Size: 1 << 60})
+	if err != nil {
+		t.Fatal(err)
+	}
+	file, _ := os.OpenFile(ParityPath(path), os.O_WRONLY, 0)
+	file.WriteAt(record, 0)
+	file.WriteAt(record, info.Size()-parityHeaderSize)
+	file.Close()
+
+	if _, err := CheckParity(path); err == nil || !strings.Contains(err.Error(), "does not match the sidecar size") {
+		t.Errorf("Expected a size mismatch, got %v", err)
+	}
+}
+
+func TestRepairParityBeyondRedundancy(t *testing.T) {
+	tmpDir := t.TempDir()
+	path := filepath.Join(tmpDir, "backup.tar.gz")
+	writeRandomFile(t, path, 40*parityBlockSize)
+	if err := WriteParity(path, 5); err != nil {
+		t.Fatalf("WriteParity failed: %v", err)
+	}
+
+	// Two damaged blocks of one stripe with a single parity block each
+	header := parityHeader{BlockSize: parityBlockSize, DataShards: parityDataShards, Size: 40 * parityBlockSize}
+	blocks := header.stripeBlocks(0)
+	corrupt(t, path, int64(blocks[0])*parityBlockSize, 1)
+	corrupt(t, path, int64(blocks[1])*parityBlockSize, 1)
+	// A single damaged block of another stripe can still be repaired
+	stripe, _ := header.locate(blocks[0] + 1)
+	corrupt(t, path, int64(blocks[0]+1)*parityBlockSize, 1)
+
+	reports, err := RepairParity(path)
+	if !errors.Is(err, ErrUnrepairable) {
+		t.Fatalf("Expected ErrUnrepairable, got %v", err)
+	}
+	if stripe == 0 || reports[0].Damaged != 2 || reports[0].Unrepairable != 1 || !reports[0].Repaired {
+		t.Errorf("Unexpected repair report: %+v", reports[0])
+	}
+}
+
+func TestParityOfCreatedArchive(t *testing.T) {
+	tmpDir := t.TempDir()
+	logPath := filepath.Join(tmpDir, "test.log")
+	log, _ := logger.New(logPath)
+	defer log.Close()
+
+	sourceDir := filepath.Join(tmpDir, "source")
+	os.MkdirAll(sourceDir, 0755)
+	writeRandomFile(t, filepath.Join(sourceDir, "random.bin"), 1024*1024)
+
+	creator := New(log, Options{ParityPercent: 10})
+	backupFile := filepath.Join(tmpDir, "backup.tar.gz")
+	if err := creator.Create(context.Background(), backupFile, []Source{{Name: "data", Path: sourceDir}}); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	if _, err := os.Stat(ParityPath(backupFile)); err != nil {
+		t.Fatalf("No parity sidecar written: %v", err)
+	}
+	original := mustRead(t, backupFile)
+
+	corrupt(t, backupFile, 512*1024, 4096)
+	err := creator.Verify(backupFile)
+	if err == nil || !strings.Contains(err.Error(), "repairable") {
+		t.Fatalf("Verify should report a repairable archive, got %v", err)
+	}
+	logContent, _ := os.ReadFile(logPath)
+	if !strings.Contains(string(logContent), "paperless-backup repair "+backupFile) {
+		t.Error("Log should name the repair command")
+	}
+
+	if _, err := RepairParity(backupFile); err != nil {
+		t.Fatalf("RepairParity failed: %v", err)
+	}
+	if !bytes.Equal(mustRead(t, backupFile), original) {
+		t.Error("Repaired archive differs from the original")
+	}
+	if err := creator.Verify(backupFile); err != nil {
+		t.Errorf("Repaired archive should verify: %v", err)
+	}
+}
+
+func TestParityOfSplitArchive(t *testing.T) {
+	tmpDir := t.TempDir()
+	log, _ := logger.New(filepath.Join(tmpDir, "test.log"))
+	defer log.Close()
+
+	backupFile := createSplitArchive(t, tmpDir, log, 64*1024)
+	if _, err := CheckParity(backupFile); !errors.Is(err, ErrNoParity) {
+		t.Errorf("Expected ErrNoParity, got %v", err)
+	}
+	if err := WriteParity(IndexPath(backupFile), 20); err != nil {
+		t.Fatalf("WriteParity failed: %v", err)
+	}
+
+	part := PartPath(backupFile, 2)
+	corrupt(t, part, 1000, 10)
+	reports, err := CheckParity(backupFile)
+	if err != nil {
+		t.Fatalf("CheckParity failed: %v", err)
+	}
+	index, _ := readIndex(IndexPath(backupFile))
+	if len(reports) != len(index.Parts) || reports[1].Path != part || reports[1].Damaged != 1 || !reports[0].OK() {
+		t.Errorf("Unexpected reports: %+v", reports)
+	}
+	if _, err := RepairParity(IndexPath(backupFile)); err != nil {
+		t.Fatalf("RepairParity failed: %v", err)
+	}
+	creator := New(log, Options{DeepVerify: true})
+	if err := creator.Verify(backupFile); err != nil {
+		t.Errorf("Repaired archive should verify: %v", err)
+	}
+}
+
+func mustRead(t *testing.T, path string) []byte {
+	t.Helper()
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return content
+}
@@ -0,0 +1,449 @@
+package archive
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// IndexExt is appended to the archive path to name the index of a split
+// archive. The index is written last and marks the set as complete.
+const IndexExt = ".parts"
+
+// PartInfo describes one part of a split archive
+type PartInfo struct {
+	Name   string `json:"name"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// PartIndex lists the parts of a split archive in stream order
+type PartIndex struct {
+	Archive string     `json:"archive"` // Logical name of the joined archive
+	Size    int64      `json:"size"`    // Size of the joined stream
+	SHA256  string     `json:"sha256"`  // Digest of the joined stream
+	Parts   []PartInfo `json:"parts"`
+}
+
+// IndexPath returns the index path of a split archive
+func IndexPath(archivePath string) string {
+	return archivePath + IndexExt
+}
+
+// PartPath returns the path of the n-th part (1-based) of a split archive
+func PartPath(archivePath string, n int) string {
+	return fmt.Sprintf("%s.part%03d", archivePath, n)
+}
+
+// partArchive returns the archive path a part file belongs to
+func partArchive(partPath string) (string, bool) {
+	i := strings.LastIndex(partPath, ".part")
+	if i < 0 {
+		return "", false
+	}
+	number := partPath[i+len(".part"):]
+	if len(number) < 3 || strings.Trim(number, "0123456789") != "" {
+		return "", false
+	}
+	return partPath[:i], IsArchiveName(partPath[:i])
+}
+
+// IsOrphanedPart reports whether a path is a published part, or the parity
+// sidecar of one, whose archive has no index, as left behind by a crash
+// while publishing
+func IsOrphanedPart(partPath string) bool {
+	archivePath, ok := partArchive(strings.TrimSuffix(partPath, ParityExt))
+	if !ok {
+		return false
+	}
+	_, err := os.Stat(IndexPath(archivePath))
+	return os.IsNotExist(err)
+}
+
+// IsIndexName reports whether a file name is the index of a split archive
+func IsIndexName(name string) bool {
+	return strings.HasSuffix(name, IndexExt) && IsArchiveName(strings.TrimSuffix(name, IndexExt))
+}
+
+// IsBackupName reports whether a file name identifies a backup: a single
+// archive or the index of a split one
+func IsBackupName(name string) bool {
+	return IsArchiveName(name) || IsIndexName(name)
+}
+
+// SetFiles returns every file belonging to a backup given its archive or
+// index path: the archive or its parts with their parity sidecars, the
+// checksum sidecar and, last, the index of a split archive
+func SetFiles(backupPath string) []string {
+	archivePath := strings.TrimSuffix(backupPath, IndexExt)
+
+	index, err := readIndex(IndexPath(archivePath))
+	if err != nil {
+		return []string{archivePath, ParityPath(archivePath), ChecksumPath(archivePath)}
+	}
+
+	files := make([]string, 0, 2*len(index.Parts)+2)
+	dir := filepath.Dir(archivePath)
+	for _, part := range index.Parts {
+		partPath := filepath.Join(dir, part.Name)
+		files = append(files, partPath, ParityPath(partPath))
+	}
+	return append(files, ChecksumPath(archivePath), IndexPath(archivePath))
+}
+
+// readIndex parses the index of a split archive
+func readIndex(indexPath string) (*PartIndex, error) {
+	content, err := os.ReadFile(indexPath)
+	if err != nil {
+		return nil, err
+	}
+
+	var index PartIndex
+	if err := json.Unmarshal(content, &index); err != nil {
+		return nil, fmt.Errorf("invalid part index %s: %w", filepath.Base(indexPath), err)
+	}
+	if len(index.Parts) == 0 {
+		return nil, fmt.Errorf("part index %s lists no parts", filepath.Base(indexPath))
+	}
+	for _, part := range index.Parts {
+		// Parts always live next to their index
+		if part.Name != filepath.Base(part.Name) || part.Name == ".." {
+			return nil, fmt.Errorf("part index %s lists invalid part %q", filepath.Base(indexPath), part.Name)
+		}
+	}
+	return &index, nil
+}
+
+// archiveOutput is the destination of an archive stream. Data goes to
+// partial files until Publish moves them to their final names.
+type archiveOutput interface {
+	io.Writer
+	// Commit syncs and closes all written files
+	Commit() error
+	// Abort closes and removes all partial files
+	Abort()
+	// VerifyPath returns the path to read the committed, unpublished archive
+	VerifyPath() string
+	// Publish renames the verified files to their final names and writes
+	// the checksum sidecar
+	Publish(sum []byte) error
+	// Size returns the number of bytes written
+	Size() int64
+}
+
+// fileOutput writes the archive as a single file
+type fileOutput struct {
+	finalPath string
+	file      *os.File
+	size      int64
+}
+
+func newFileOutput(finalPath string) (*fileOutput, error) {
+	file, err := os.OpenFile(PartialPath(finalPath), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create backup file: %w", err)
+	}
+	// A stale partial file may carry looser permissions
+	if err := file.Chmod(0600); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to set permissions: %w", err)
+	}
+	return &fileOutput{finalPath: finalPath, file: file}, nil
+}
+
+func (o *fileOutput) Write(p []byte) (int, error) {
+	n, err := o.file.Write(p)
+	o.size += int64(n)
+	return n, err
+}
+
+func (o *fileOutput) Commit() error {
+	// Make the content durable before it can be published
+	if err := o.file.Sync(); err != nil {
+		return fmt.Errorf("failed to sync backup file: %w", err)
+	}
+	if err := o.file.Close(); err != nil {
+		return fmt.Errorf("failed to close output file: %w", err)
+	}
+	return nil
+}
+
+func (o *fileOutput) Abort() {
+	o.file.Close()
+	os.Remove(PartialPath(o.finalPath))
+}
+
+func (o *fileOutput) VerifyPath() string {
+	return PartialPath(o.finalPath)
+}
+
+func (o *fileOutput) Publish(sum []byte) error {
+	if err := publish(PartialPath(o.finalPath), o.finalPath); err != nil {
+		return err
+	}
+
+	// Write the whole-archive digest next to the archive
+	return writeChecksumFile(o.finalPath, sum)
+}
+
+func (o *fileOutput) Size() int64 {
+	return o.size
+}
+
+// partOutput splits the archive stream into numbered parts of at most
+// partSize bytes, for filesystems and storage with object size limits.
+// Concatenating the parts yields the regular archive.
+type partOutput struct {
+	finalPath string
+	partSize  int64
+	parts     []PartInfo
+	current   *os.File
+	written   int64
+	hash      hash.Hash
+	size      int64
+}
+
+func newPartOutput(finalPath string, partSize int64) *partOutput {
+	return &partOutput{finalPath: finalPath, partSize: partSize}
+}
+
+// rotate finishes the current part and starts the next one
+func (o *partOutput) rotate() error {
+	if err := o.finishPart(); err != nil {
+		return err
+	}
+
+	partPath := PartPath(o.finalPath, len(o.parts)+1)
+	file, err := os.OpenFile(PartialPath(partPath), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to create backup part: %w", err)
+	}
+	if err := file.Chmod(0600); err != nil {
+		file.Close()
+		return fmt.Errorf("failed to set permissions: %w", err)
+	}
+
+	o.current = file
+	o.written = 0
+	o.hash = sha256.New()
+	o.parts = append(o.parts, PartInfo{Name: filepath.Base(partPath)})
+	return nil
+}
+
+// finishPart syncs and closes the current part and records its digest
+func (o *partOutput) finishPart() error {
+	if o.current == nil {
+		return nil
+	}
+
+	if err := o.current.Sync(); err != nil {
+		return fmt.Errorf("failed to sync backup part: %w", err)
+	}
+	if err := o.current.Close(); err != nil {
+		return fmt.Errorf("failed to close backup part: %w", err)
+	}
+
+	part := &o.parts[len(o.parts)-1]
+	part.Size = o.written
+	part.SHA256 = hex.EncodeToString(o.hash.Sum(nil))
+	o.current = nil
+	return nil
+}
+
+func (o *partOutput) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		if o.current == nil || o.written == o.partSize {
+			if err := o.rotate(); err != nil {
+				return written, err
+			}
+		}
+
+		chunk := p
+		if remaining := o.partSize - o.written; int64(len(chunk)) > remaining {
+			chunk = chunk[:remaining]
+		}
+
+		n, err := o.current.Write(chunk)
+		o.hash.Write(chunk[:n])
+		o.written += int64(n)
+		o.size += int64(n)
+		written += n
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
+
+func (o *partOutput) Commit() error {
+	// An empty stream still consists of one (empty) part
+	if len(o.parts) == 0 {
+		if err := o.rotate(); err != nil {
+			return err
+		}
+	}
+	if err := o.finishPart(); err != nil {
+		return err
+	}
+
+	// The partial index lets verification read the unpublished parts
+	return o.writeIndex(PartialPath(IndexPath(o.finalPath)), PartialExt, nil)
+}
+
+func (o *partOutput) Abort() {
+	if o.current != nil {
+		o.current.Close()
+	}
+	dir := filepath.Dir(o.finalPath)
+	for _, part := range o.parts {
+		os.Remove(filepath.Join(dir, part.Name) + PartialExt)
+	}
+	os.Remove(PartialPath(IndexPath(o.finalPath)))
+}
+
+func (o *partOutput) VerifyPath() string {
+	return PartialPath(IndexPath(o.finalPath))
+}
+
+func (o *partOutput) Publish(sum []byte) error {
+	dir := filepath.Dir(o.finalPath)
+	for _, part := range o.parts {
+		partPath := filepath.Join(dir, part.Name)
+		if err := publish(PartialPath(partPath), partPath); err != nil {
+			return err
+		}
+	}
+
+	// The final index is the commit point of the whole set
+	if err := o.writeIndex(IndexPath(o.finalPath), "", sum); err != nil {
+		return err
+	}
+	os.Remove(PartialPath(IndexPath(o.finalPath)))
+
+	// The sidecar lists every part, so `sha256sum -c` checks them all
+	var lines strings.Builder
+	for _, part := range o.parts {
+		fmt.Fprintf(&lines, "%s  %s\n", part.SHA256, part.Name)
+	}
+	if err := writeFileAtomic(ChecksumPath(o.finalPath), []byte(lines.String()), 0644); err != nil {
+		return fmt.Errorf("failed to write checksum file: %w", err)
+	}
+	return nil
+}
+
+func (o *partOutput) Size() int64 {
+	return o.size
+}
+
+// writeIndex writes the part index, with nameSuffix appended to part names
+func (o *partOutput) writeIndex(indexPath, nameSuffix string, sum []byte) error {
+	index := PartIndex{
+		Archive: filepath.Base(o.finalPath),
+		Size:    o.size,
+		SHA256:  hex.EncodeToString(sum),
+	}
+	for _, part := range o.parts {
+		part.Name += nameSuffix
+		index.Parts = append(index.Parts, part)
+	}
+
+	content, err := json.MarshalIndent(index, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(indexPath, append(content, '\n'), 0600); err != nil {
+		return fmt.Errorf("failed to write part index: %w", err)
+	}
+	return nil
+}
+
+// partReader reads one part of a split archive and checks its size and
+// digest against the index once the part has been read to its end
+type partReader struct {
+	file *os.File
+	part PartInfo
+	hash hash.Hash
+	size int64
+}
+
+func (r *partReader) Read(p []byte) (int, error) {
+	n, err := r.file.Read(p)
+	r.hash.Write(p[:n])
+	r.size += int64(n)
+	if err == io.EOF && r.part.SHA256 != "" {
+		if r.size != r.part.Size || hex.EncodeToString(r.hash.Sum(nil)) != r.part.SHA256 {
+			return n, fmt.Errorf("backup part %s does not match its checksum in the index", r.part.Name)
+		}
+	}
+	return n, err
+}
+
+// multiFile reads the parts of a split archive as one stream
+type multiFile struct {
+	io.Reader
+	files []*os.File
+}
+
+func (m *multiFile) Close() error {
+	var firstErr error
+	for _, file := range m.files {
+		if err := file.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// openStream opens the raw (possibly encrypted and compressed) archive
+// stream. The path may name a single archive, a part index, or the logical
+// path of a split archive.
+func openStream(path string) (io.ReadCloser, error) {
+	indexPath := ""
+	switch {
+	case strings.HasSuffix(path, IndexExt), strings.HasSuffix(path, IndexExt+PartialExt):
+		indexPath = path
+	default:
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			if _, err := os.Stat(IndexPath(path)); err == nil {
+				indexPath = IndexPath(path)
+			}
+		}
+	}
+
+	if indexPath == "" {
+		file, err := os.Open(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open backup file: %w", err)
+		}
+		return file, nil
+	}
+
+	index, err := readIndex(indexPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open backup index: %w", err)
+	}
+
+	m := &multiFile{}
+	readers := make([]io.Reader, 0, len(index.Parts))
+	dir := filepath.Dir(indexPath)
+	for _, part := range index.Parts {
+		file, err := os.Open(filepath.Join(dir, part.Name))
+		if err != nil {
+			m.Close()
+			return nil, fmt.Errorf("failed to open backup part: %w", err)
+		}
+		m.files = append(m.files, file)
+		readers = append(readers, &partReader{file: file, part: part, hash: sha256.New()})
+	}
+	m.Reader = io.MultiReader(readers...)
+
+	return m, nil
+}
@@ -0,0 +1,220 @@
+package archive
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"paperless-backup/internal/logger"
+)
+
+func createSplitArchive(t *testing.T, tmpDir string, log *logger.Logger, partSize int64) string {
+	t.Helper()
+
+	sourceDir := filepath.Join(tmpDir, "source")
+	os.MkdirAll(sourceDir, 0755)
+	// Random content does not compress, so the archive spans several parts
+	payload := make([]byte, 256*1024)
+	rand.Read(payload)
+	os.WriteFile(filepath.Join(sourceDir, "random.bin"), payload, 0644)
+	os.WriteFile(filepath.Join(sourceDir, "small.txt"), []byte("small"), 0644)
+
+	creator := New(log, Options{DeepVerify: true, PartSize: partSize})
+	backupFile := filepath.Join(tmpDir, "backup.tar.gz")
+	if err := creator.Create(context.Background(), backupFile, []Source{{Name: "data", Path: sourceDir}}); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	return backupFile
+}
+
+func TestCreateSplitsIntoParts(t *testing.T) {
+	tmpDir := t.TempDir()
+	log, _ := logger.New(filepath.Join(tmpDir, "test.log"))
+	defer log.Close()
+
+	const partSize = 64 * 1024
+	backupFile := createSplitArchive(t, tmpDir, log, partSize)
+
+	if _, err := os.Stat(backupFile); !os.IsNotExist(err) {
+		t.Error("Split archive should not be written as a single file")
+	}
+
+	index, err := readIndex(IndexPath(backupFile))
+	if err != nil {
+		t.Fatalf("Failed to read part index: %v", err)
+	}
+	if len(index.Parts) < 4 {
+		t.Fatalf("Expected at least 4 parts, got %d", len(index.Parts))
+	}
+
+	// Joining the parts yields the archive described by the index
+	var joined bytes.Buffer
+	for i, part := range index.Parts {
+		if part.Name != filepath.Base(PartPath(backupFile, i+1)) {
+			t.Errorf("Part %d named %s", i+1, part.Name)
+		}
+		content, err := os.ReadFile(filepath.Join(tmpDir, part.Name))
+		if err != nil {
+			t.Fatalf("Failed to read part: %v", err)
+		}
+		if i < len(index.Parts)-1 && int64(len(content)) != partSize {
+			t.Errorf("Part %d has %d bytes, want %d", i+1, len(content), partSize)
+		}
+		if int64(len(content)) != part.Size {
+			t.Errorf("Part %d size %d does not match index %d", i+1, len(content), part.Size)
+		}
+		sum := sha256.Sum256(content)
+		if hex.EncodeToString(sum[:]) != part.SHA256 {
+			t.Errorf("Part %d digest does not match index", i+1)
+		}
+		joined.Write(content)
+	}
+	sum := sha256.Sum256(joined.Bytes())
+	if hex.EncodeToString(sum[:]) != index.SHA256 || int64(joined.Len()) != index.Size {
+		t.Error("Joined parts do not match the index digest")
+	}
+	if codec, err := DetectCodec(bufio.NewReader(&joined)); err != nil || codec != gzipCodec {
+		t.Errorf("Joined parts should form a gzip archive: %v", err)
+	}
+
+	// The sidecar checks every part with sha256sum -c
+	sidecar, err := os.ReadFile(ChecksumPath(backupFile))
+	if err != nil {
+		t.Fatalf("Failed to read checksum file: %v", err)
+	}
+	if lines := strings.Count(string(sidecar), "\n"); lines != len(index.Parts) {
+		t.Errorf("Checksum file has %d lines, want %d", lines, len(index.Parts))
+	}
+
+	// No partial files remain
+	matches, _ := filepath.Glob(filepath.Join(tmpDir, "*"+PartialExt))
+	if len(matches) != 0 {
+		t.Errorf("Partial files remain: %v", matches)
+	}
+}
+
+func TestSplitArchiveChecksPartDigests(t *testing.T) {
+	tmpDir := t.TempDir()
+	log, _ := logger.New(filepath.Join(tmpDir, "test.log"))
+	defer log.Close()
+
+	sourceDir := filepath.Join(tmpDir, "source")
+	os.MkdirAll(sourceDir, 0755)
+	os.WriteFile(filepath.Join(sourceDir, "data.bin"), bytes.Repeat([]byte("paperless"), 20000), 0644)
+
+	// Without compression nothing but the part digest covers file content
+	creator := New(log, Options{Codec: noneCodec, PartSize: 64 * 1024})
+	backupFile := filepath.Join(tmpDir, "backup.tar")
+	if err := creator.Create(context.Background(), backupFile, []Source{{Name: "data", Path: sourceDir}}); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	part := PartPath(backupFile, 2)
+	content, _ := os.ReadFile(part)
+	content[1000] ^= 0xff
+	os.WriteFile(part, content, 0644)
+
+	_, err := Extract(backupFile, filepath.Join(tmpDir, "restore"), ExtractOptions{})
+	if err == nil || !strings.Contains(err.Error(), filepath.Base(part)) {
+		t.Errorf("Extract should fail naming the damaged part, got %v", err)
+	}
+	if err := creator.Verify(backupFile); err == nil || !strings.Contains(err.Error(), filepath.Base(part)) {
+		t.Errorf("Verify should fail naming the damaged part, got %v", err)
+	}
+}
+
+func TestVerifySplitArchive(t *testing.T) {
+	tmpDir := t.TempDir()
+	log, _ := logger.New(filepath.Join(tmpDir, "test.log"))
+	defer log.Close()
+
+	backupFile := createSplitArchive(t, tmpDir, log, 64*1024)
+	creator := New(log, Options{})
+
+	// Both the index and the logical archive path open the joined stream
+	for _, path := range []string{IndexPath(backupFile), backupFile} {
+		result, err := creator.VerifyDeep(path)
+		if err != nil {
+			t.Fatalf("VerifyDeep(%s) failed: %v", filepath.Base(path), err)
+		}
+		if !result.OK() || result.Files != 2 {
+			t.Errorf("VerifyDeep(%s) = %s, %d files", filepath.Base(path), result.Summary(), result.Files)
+		}
+	}
+
+	// A missing part fails verification
+	os.Remove(PartPath(backupFile, 2))
+	if err := creator.Verify(IndexPath(backupFile)); err == nil {
+		t.Error("Verify should fail with a missing part")
+	}
+}
+
+func TestSetFiles(t *testing.T) {
+	tmpDir := t.TempDir()
+	log, _ := logger.New(filepath.Join(tmpDir, "test.log"))
+	defer log.Close()
+
+	backupFile := createSplitArchive(t, tmpDir, log, 64*1024)
+	index, _ := readIndex(IndexPath(backupFile))
+	if err := WriteParity(backupFile, 10); err != nil {
+		t.Fatalf("WriteParity failed: %v", err)
+	}
+
+	files := SetFiles(IndexPath(backupFile))
+	if len(files) != 2*len(index.Parts)+2 {
+		t.Fatalf("Expected %d files, got %v", 2*len(index.Parts)+2, files)
+	}
+	if files[len(files)-1] != IndexPath(backupFile) {
+		t.Error("The index should be removed last")
+	}
+	for _, file := range files {
+		if _, err := os.Stat(file); err != nil {
+			t.Errorf("Listed file does not exist: %v", err)
+		}
+	}
+
+	single := filepath.Join(tmpDir, "single.tar.gz")
+	if files := SetFiles(single); len(files) != 3 || files[0] != single || files[1] != ParityPath(single) || files[2] != ChecksumPath(single) {
+		t.Errorf("Unexpected files of a single archive: %v", files)
+	}
+}
+
+func TestBackupNames(t *testing.T) {
+	tests := []struct {
+		name   string
+		backup bool
+		orphan bool
+	}{
+		{"b.tar.gz", true, false},
+		{"b.tar.gz.parts", true, false},
+		{"b.tar.zst.age.parts", true, false},
+		{"b.tar.gz.part001", false, true},
+		{"b.tar.gz.part1000", false, true},
+		{"b.tar.gz.partx01", false, false},
+		{"b.tar.gz.part001.parity", false, true},
+		{"b.tar.gz.parity", false, false},
+		{"notes.parts", false, false},
+	}
+
+	tmpDir := t.TempDir()
+	for _, tt := range tests {
+		if got := IsBackupName(tt.name); got != tt.backup {
+			t.Errorf("IsBackupName(%q) = %v, want %v", tt.name, got, tt.backup)
+		}
+		if got := IsOrphanedPart(filepath.Join(tmpDir, tt.name)); got != tt.orphan {
+			t.Errorf("IsOrphanedPart(%q) = %v, want %v", tt.name, got, tt.orphan)
+		}
+	}
+
+	// A part is not orphaned once its index exists
+	os.WriteFile(filepath.Join(tmpDir, "b.tar.gz.parts"), []byte("{}"), 0600)
+	if IsOrphanedPart(filepath.Join(tmpDir, "b.tar.gz.part001")) {
+		t.Error("Part with an index should not be orphaned")
+	}
+}
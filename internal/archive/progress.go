@@ -0,0 +1,234 @@
+package archive
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"paperless-backup/internal/logger"
+
+	"golang.org/x/sys/unix"
+)
+
+// drawInterval throttles redraws of the interactive progress line
+const drawInterval = 200 * time.Millisecond
+
+// sourceTotals is the pre-scanned amount of content of a source
+type sourceTotals struct {
+	Files int
+	Bytes int64
+}
+
+// scanSource counts the regular files a backup of source will read, with
+// the same filter and incremental decisions as addToTar. Unreadable entries
+// are skipped; the backup itself reports them.
+func scanSource(source Source) sourceTotals {
+	var totals sourceTotals
+	filepath.Walk(source.Path, func(filePath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return nil
+		}
+		rel, err := filepath.Rel(source.Path, filePath)
+		if err != nil {
+			return nil
+		}
+		rel = filepath.ToSlash(rel)
+
+		if rel != "." && source.Filter.Excludes(rel, info.IsDir()) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		if source.Base != nil && source.Base.unchanged(path.Join(source.Name, rel), stateOf(info)) {
+			return nil
+		}
+
+		totals.Files++
+		totals.Bytes += info.Size()
+		return nil
+	})
+	return totals
+}
+
+// progress reports how far archive creation got: a log line per interval
+// and, when stdout is a terminal, a continuously updated status line
+type progress struct {
+	logger   *logger.Logger
+	interval time.Duration
+	terminal io.Writer // nil unless stdout is a terminal
+
+	totalFiles int
+	totalBytes int64
+	doneFiles  int
+	doneBytes  int64
+	fileBytes  int64 // Bytes read of the current file
+	source     string
+
+	start    time.Time
+	lastLog  time.Time
+	lastDraw time.Time
+	drawn    bool
+}
+
+// newProgress pre-scans the sources and logs their totals
+func newProgress(log *logger.Logger, interval time.Duration, sources []Source) *progress {
+	p := &progress{logger: log, interval: interval}
+	if isTerminal(os.Stdout) {
+		p.terminal = os.Stdout
+	}
+
+	scanStart := time.Now()
+	for _, source := range sources {
+		totals := scanSource(source)
+		p.totalFiles += totals.Files
+		p.totalBytes += totals.Bytes
+		log.Logf("INFO", "Pre-scan %s: %d files (%.2fMB)", source.Name, totals.Files, float64(totals.Bytes)/1024/1024)
+	}
+	log.Logf("INFO", "Pre-scan total: %d files (%.2fMB) in %s",
+		p.totalFiles, float64(p.totalBytes)/1024/1024, time.Since(scanStart).Round(time.Millisecond))
+
+	p.start = time.Now()
+	p.lastLog = p.start
+	return p
+}
+
+// isTerminal reports whether f is an interactive terminal
+func isTerminal(f *os.File) bool {
+	_, err := unix.IoctlGetTermios(int(f.Fd()), unix.TCGETS)
+	return err == nil
+}
+
+// startSource records the source being archived
+func (p *progress) startSource(name string) {
+	if p == nil {
+		return
+	}
+	p.source = name
+}
+
+// reader counts the bytes read from r as progress of the current file
+func (p *progress) reader(r io.Reader) io.Reader {
+	if p == nil {
+		return r
+	}
+	return &progressReader{reader: r, progress: p}
+}
+
+// completeFile finishes a regular file of the given size, counting bytes
+// that were not read through reader (sparse holes, hard links)
+func (p *progress) completeFile(size int64) {
+	if p == nil {
+		return
+	}
+	if size > p.fileBytes {
+		p.doneBytes += size - p.fileBytes
+	}
+	p.fileBytes = 0
+	p.doneFiles++
+	p.update()
+}
+
+// add counts bytes read of the current file
+func (p *progress) add(n int64) {
+	p.fileBytes += n
+	p.doneBytes += n
+	p.update()
+}
+
+// update logs and redraws when due
+func (p *progress) update() {
+	now := time.Now()
+	if now.Sub(p.lastLog) >= p.interval {
+		p.lastLog = now
+		p.clear()
+		p.logger.Log("INFO", "Progress: "+p.status(now))
+	}
+	if p.terminal != nil && now.Sub(p.lastDraw) >= drawInterval {
+		p.lastDraw = now
+		fmt.Fprintf(p.terminal, "\r\033[K%s", p.status(now))
+		p.drawn = true
+	}
+}
+
+// clear removes the interactive status line, so log lines start on an
+// empty line
+func (p *progress) clear() {
+	if p == nil || !p.drawn {
+		return
+	}
+	fmt.Fprint(p.terminal, "\r\033[K")
+	p.drawn = false
+}
+
+// status describes the progress at a point in time
+func (p *progress) status(now time.Time) string {
+	elapsed := now.Sub(p.start)
+
+	percent := 100.0
+	if p.totalBytes > 0 {
+		percent = float64(p.doneBytes) / float64(p.totalBytes) * 100
+	}
+	// Files may grow between pre-scan and backup
+	if percent > 100 {
+		percent = 100
+	}
+
+	var throughput float64
+	if elapsed > 0 {
+		throughput = float64(p.doneBytes) / 1024 / 1024 / elapsed.Seconds()
+	}
+
+	eta := "unknown"
+	if p.doneBytes > 0 && p.totalBytes > p.doneBytes {
+		remaining := time.Duration(float64(elapsed) * float64(p.totalBytes-p.doneBytes) / float64(p.doneBytes))
+		eta = remaining.Round(time.Second).String()
+	} else if p.doneBytes >= p.totalBytes {
+		eta = "0s"
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "%.1f%% (%.2fMB of %.2fMB, %d/%d files), %.2fMB/s, ETA %s",
+		percent, float64(p.doneBytes)/1024/1024, float64(p.totalBytes)/1024/1024,
+		p.doneFiles, p.totalFiles, throughput, eta)
+	if p.source != "" {
+		fmt.Fprintf(&b, ", current: %s", p.source)
+	}
+	return b.String()
+}
+
+// finish clears the status line and logs the totals
+func (p *progress) finish() {
+	if p == nil {
+		return
+	}
+	p.clear()
+	elapsed := time.Since(p.start)
+	var throughput float64
+	if elapsed > 0 {
+		throughput = float64(p.doneBytes) / 1024 / 1024 / elapsed.Seconds()
+	}
+	p.logger.Logf("INFO", "Archived %d files (%.2fMB) in %s, %.2fMB/s",
+		p.doneFiles, float64(p.doneBytes)/1024/1024, elapsed.Round(time.Second), throughput)
+}
+
+// progressReader reports the bytes read through it
+type progressReader struct {
+	reader   io.Reader
+	progress *progress
+}
+
+func (r *progressReader) Read(b []byte) (int, error) {
+	n, err := r.reader.Read(b)
+	if n > 0 {
+		r.progress.add(int64(n))
+	}
+	return n, err
+}
@@ -0,0 +1,120 @@
+package archive
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"paperless-backup/internal/logger"
+)
+
+func TestScanSource(t *testing.T) {
+	sourceDir := t.TempDir()
+	os.MkdirAll(filepath.Join(sourceDir, "documents"), 0755)
+	os.MkdirAll(filepath.Join(sourceDir, "thumbnails"), 0755)
+	os.WriteFile(filepath.Join(sourceDir, "documents", "a.pdf"), make([]byte, 1000), 0644)
+	os.WriteFile(filepath.Join(sourceDir, "documents", "b.pdf"), make([]byte, 500), 0644)
+	os.WriteFile(filepath.Join(sourceDir, "thumbnails", "a.webp"), make([]byte, 200), 0644)
+	os.Symlink("a.pdf", filepath.Join(sourceDir, "documents", "latest.pdf"))
+
+	totals := scanSource(Source{Name: "media", Path: sourceDir})
+	if totals.Files != 3 || totals.Bytes != 1700 {
+		t.Errorf("Unexpected totals: %+v", totals)
+	}
+
+	// Filtered and unchanged files are not read by the backup
+	info, _ := os.Stat(filepath.Join(sourceDir, "documents", "b.pdf"))
+	base := &Snapshot{Files: map[string]FileState{"media/documents/b.pdf": stateOf(info)}}
+	totals = scanSource(Source{
+		Name:   "media",
+		Path:   sourceDir,
+		Filter: Filter{Exclude: []string{"thumbnails"}},
+		Base:   base,
+	})
+	if totals.Files != 1 || totals.Bytes != 1000 {
+		t.Errorf("Unexpected totals with filter and base: %+v", totals)
+	}
+}
+
+func TestProgressStatus(t *testing.T) {
+	start := time.Date(2024, 1, 1, 3, 0, 0, 0, time.UTC)
+	p := &progress{
+		totalFiles: 10,
+		totalBytes: 400 << 20,
+		doneFiles:  4,
+		doneBytes:  100 << 20,
+		source:     "media",
+		start:      start,
+	}
+
+	status := p.status(start.Add(10 * time.Second))
+	for _, want := range []string{"25.0%", "100.00MB of 400.00MB", "4/10 files", "10.00MB/s", "ETA 30s", "current: media"} {
+		if !strings.Contains(status, want) {
+			t.Errorf("Status %q should contain %q", status, want)
+		}
+	}
+
+	// Files that grew after the pre-scan never report more than 100%
+	p.doneBytes = 500 << 20
+	if status := p.status(start.Add(10 * time.Second)); !strings.Contains(status, "100.0%") || !strings.Contains(status, "ETA 0s") {
+		t.Errorf("Unexpected status %q", status)
+	}
+}
+
+func TestProgressCountsFiles(t *testing.T) {
+	tmpDir := t.TempDir()
+	logPath := filepath.Join(tmpDir, "test.log")
+	log, _ := logger.New(logPath)
+	defer log.Close()
+
+	p := &progress{logger: log, interval: time.Nanosecond, totalFiles: 2, totalBytes: 300, start: time.Now()}
+	var terminal bytes.Buffer
+	p.terminal = &terminal
+
+	p.startSource("media")
+	var sink bytes.Buffer
+	sink.ReadFrom(p.reader(bytes.NewReader(make([]byte, 100))))
+	p.completeFile(100)
+	// Hard links and sparse holes are counted without being read
+	p.completeFile(200)
+	p.finish()
+
+	if p.doneFiles != 2 || p.doneBytes != 300 {
+		t.Errorf("Unexpected progress: %d files, %d bytes", p.doneFiles, p.doneBytes)
+	}
+
+	content, _ := os.ReadFile(logPath)
+	if !strings.Contains(string(content), "Progress: 100.0%") || !strings.Contains(string(content), "Archived 2 files") {
+		t.Errorf("Progress should be logged:\n%s", content)
+	}
+	if !strings.Contains(terminal.String(), "\r\033[K") {
+		t.Error("Status line should be drawn on the terminal")
+	}
+}
+
+func TestCreateReportsProgress(t *testing.T) {
+	tmpDir := t.TempDir()
+	logPath := filepath.Join(tmpDir, "test.log")
+	log, _ := logger.New(logPath)
+	defer log.Close()
+
+	sourceDir := filepath.Join(tmpDir, "source")
+	os.MkdirAll(sourceDir, 0755)
+	os.WriteFile(filepath.Join(sourceDir, "a.pdf"), make([]byte, 4096), 0644)
+
+	creator := New(log, Options{ProgressInterval: time.Nanosecond})
+	if err := creator.Create(context.Background(), filepath.Join(tmpDir, "backup.tar.gz"), []Source{{Name: "media", Path: sourceDir}}); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+
+	content, _ := os.ReadFile(logPath)
+	for _, want := range []string{"Pre-scan media: 1 files", "Progress: 100.0%", "current: media", "Archived 1 files"} {
+		if !strings.Contains(string(content), want) {
+			t.Errorf("Log should contain %q:\n%s", want, content)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package archive
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// PartialExt marks files that are still being written. They are only renamed
+// to their final name once complete and verified.
+const PartialExt = ".partial"
+
+// PartialPath returns the temporary path an archive is written to
+func PartialPath(finalPath string) string {
+	return finalPath + PartialExt
+}
+
+// IsPartialName reports whether a file name belongs to an unfinished write
+func IsPartialName(name string) bool {
+	return strings.HasSuffix(name, PartialExt)
+}
+
+// syncDir flushes directory entries (creations and renames) to disk
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+// publish atomically moves a completed partial file to its final name and
+// makes the rename durable
+func publish(partialPath, finalPath string) error {
+	if err := os.Rename(partialPath, finalPath); err != nil {
+		return fmt.Errorf("failed to publish %s: %w", filepath.Base(finalPath), err)
+	}
+	if err := syncDir(filepath.Dir(finalPath)); err != nil {
+		return fmt.Errorf("failed to sync backup directory: %w", err)
+	}
+	return nil
+}
+
+// writeFileAtomic writes a small file through a synced partial file, so
+// readers never observe it half written
+func writeFileAtomic(path string, content []byte, perm os.FileMode) error {
+	partialPath := PartialPath(path)
+	file, err := os.OpenFile(partialPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+
+	if _, err := file.Write(content); err != nil {
+		file.Close()
+		os.Remove(partialPath)
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		os.Remove(partialPath)
+		return err
+	}
+	if err := file.Close(); err != nil {
+		os.Remove(partialPath)
+		return err
+	}
+
+	return publish(partialPath, path)
+}
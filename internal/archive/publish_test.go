@@ -0,0 +1,154 @@
+package archive
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"paperless-backup/internal/logger"
+
+	"filippo.io/age"
+)
+
+func TestCreatePublishesAtomically(t *testing.T) {
+	tmpDir := t.TempDir()
+	logPath := filepath.Join(tmpDir, "test.log")
+
+	log, _ := logger.New(logPath)
+	defer log.Close()
+
+	sourceDir := filepath.Join(tmpDir, "source")
+	os.MkdirAll(sourceDir, 0755)
+	os.WriteFile(filepath.Join(sourceDir, "file1.txt"), []byte("content1"), 0644)
+
+	creator := New(log, Options{DeepVerify: true})
+	backupFile := filepath.Join(tmpDir, "backup.tar.gz")
+	if err := creator.Create(context.Background(), backupFile, []Source{{Name: "data", Path: sourceDir}}); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+
+	if _, err := os.Stat(backupFile); err != nil {
+		t.Errorf("Archive should be published: %v", err)
+	}
+	if _, err := os.Stat(PartialPath(backupFile)); !os.IsNotExist(err) {
+		t.Error("Partial file should not remain after publishing")
+	}
+	if _, err := os.Stat(PartialPath(ChecksumPath(backupFile))); !os.IsNotExist(err) {
+		t.Error("Partial checksum file should not remain after publishing")
+	}
+}
+
+func TestCreateFailureLeavesNothing(t *testing.T) {
+	tmpDir := t.TempDir()
+	logPath := filepath.Join(tmpDir, "test.log")
+
+	log, _ := logger.New(logPath)
+	defer log.Close()
+
+	creator := New(log, Options{})
+	backupFile := filepath.Join(tmpDir, "backup.tar.gz")
+	missing := filepath.Join(tmpDir, "does-not-exist")
+	if err := creator.Create(context.Background(), backupFile, []Source{{Name: "data", Path: missing}}); err == nil {
+		t.Fatal("Create should fail for a missing source")
+	}
+
+	for _, path := range []string{backupFile, PartialPath(backupFile), ChecksumPath(backupFile)} {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("%s should not exist after a failed run", filepath.Base(path))
+		}
+	}
+}
+
+func TestCreateUnverifiedArchiveNotPublished(t *testing.T) {
+	tmpDir := t.TempDir()
+	logPath := filepath.Join(tmpDir, "test.log")
+
+	log, _ := logger.New(logPath)
+	defer log.Close()
+
+	sourceDir := filepath.Join(tmpDir, "source")
+	os.MkdirAll(sourceDir, 0755)
+	os.WriteFile(filepath.Join(sourceDir, "file1.txt"), []byte("content1"), 0644)
+
+	// Encrypt to one key but verify with another, so verification fails
+	recipient, _ := age.GenerateX25519Identity()
+	other, _ := age.GenerateX25519Identity()
+	identityFile := filepath.Join(tmpDir, "identity.txt")
+	os.WriteFile(identityFile, []byte(other.String()+"\n"), 0600)
+
+	encryptor, err := NewEncryptor([]string{recipient.Recipient().String()}, "", identityFile)
+	if err != nil {
+		t.Fatalf("NewEncryptor failed: %v", err)
+	}
+
+	creator := New(log, Options{Encryptor: encryptor})
+	backupFile := filepath.Join(tmpDir, "backup"+creator.Extension())
+	if err := creator.Create(context.Background(), backupFile, []Source{{Name: "data", Path: sourceDir}}); err == nil {
+		t.Fatal("Create should fail when verification fails")
+	}
+
+	if _, err := os.Stat(backupFile); !os.IsNotExist(err) {
+		t.Error("Unverified archive must not be published")
+	}
+	if _, err := os.Stat(PartialPath(backupFile)); !os.IsNotExist(err) {
+		t.Error("Partial file of a failed run should be removed")
+	}
+}
+
+func TestCreateInterrupted(t *testing.T) {
+	tmpDir := t.TempDir()
+	logPath := filepath.Join(tmpDir, "test.log")
+
+	log, _ := logger.New(logPath)
+	defer log.Close()
+
+	sourceDir := filepath.Join(tmpDir, "source")
+	os.MkdirAll(sourceDir, 0755)
+	os.WriteFile(filepath.Join(sourceDir, "small.txt"), []byte("content"), 0644)
+	os.WriteFile(filepath.Join(sourceDir, "large.bin"), make([]byte, 4*1024*1024), 0644)
+
+	// Reads are throttled, so the large file is still being read when the
+	// run is cancelled, with some parts already written
+	creator := New(log, Options{ReadLimit: 1024 * 1024, PartSize: 1024, Codec: noneCodec})
+	backupFile := filepath.Join(tmpDir, "backup.tar")
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(200*time.Millisecond, cancel)
+
+	err := creator.Create(ctx, backupFile, []Source{{Name: "data", Path: sourceDir}})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected context.Canceled, got %v", err)
+	}
+
+	entries, _ := os.ReadDir(tmpDir)
+	for _, entry := range entries {
+		if entry.Name() != "source" && entry.Name() != "test.log" {
+			t.Errorf("%s should not exist after an interrupted run", entry.Name())
+		}
+	}
+	logContent, _ := os.ReadFile(logPath)
+	if !strings.Contains(string(logContent), "partial archive removed") {
+		t.Error("Log should report the removed partial archive")
+	}
+}
+
+func TestWriteFileAtomic(t *testing.T) {
+	tmpDir := t.TempDir()
+	path := filepath.Join(tmpDir, "file.txt")
+
+	os.WriteFile(path, []byte("old"), 0644)
+	if err := writeFileAtomic(path, []byte("new"), 0644); err != nil {
+		t.Fatalf("writeFileAtomic failed: %v", err)
+	}
+
+	content, _ := os.ReadFile(path)
+	if string(content) != "new" {
+		t.Errorf("Expected new content, got %q", content)
+	}
+	if _, err := os.Stat(PartialPath(path)); !os.IsNotExist(err) {
+		t.Error("Partial file should not remain")
+	}
+}
@@ -0,0 +1,230 @@
+package archive
+
+import (
+	"archive/tar"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"strings"
+	"time"
+)
+
+// Entry types reported by Reader
+const (
+	EntryFile     = "file"
+	EntryDir      = "dir"
+	EntrySymlink  = "symlink"
+	EntryHardlink = "hardlink"
+	EntryOther    = "other"
+)
+
+// Entry describes a single archived path
+type Entry struct {
+	Path     string      // Archive path below the source's logical root
+	Type     string      // One of the Entry* types
+	Size     int64       // Logical size, including holes of sparse files
+	Mode     fs.FileMode // Permission bits
+	ModTime  time.Time   // Zero unless metadata was preserved
+	Linkname string      // Target of symlinks and hard links
+	SHA256   string      // Hex content hash from the manifest, if present
+
+	hostPath string // File a live tree entry was scanned from
+}
+
+// MarshalJSON encodes the entry with an octal mode and without empty fields
+func (e Entry) MarshalJSON() ([]byte, error) {
+	out := struct {
+		Path     string     `json:"path"`
+		Type     string     `json:"type"`
+		Size     int64      `json:"size"`
+		Mode     string     `json:"mode"`
+		ModTime  *time.Time `json:"mtime,omitempty"`
+		Linkname string     `json:"link,omitempty"`
+		SHA256   string     `json:"sha256,omitempty"`
+	}{
+		Path:     e.Path,
+		Type:     e.Type,
+		Size:     e.Size,
+		Mode:     fmt.Sprintf("%04o", e.Mode.Perm()),
+		Linkname: e.Linkname,
+		SHA256:   e.SHA256,
+	}
+	if !e.ModTime.IsZero() {
+		out.ModTime = &e.ModTime
+	}
+	return json.Marshal(out)
+}
+
+// Reader streams the entries of an archive. Encryption, compression and
+// split parts are detected automatically.
+type Reader struct {
+	archive  *archiveReader
+	manifest *Manifest
+	records  []*IncrementalRecord
+}
+
+// Open opens an archive for reading. encryptor may be nil for plaintext
+// archives.
+func Open(archivePath string, encryptor *Encryptor) (*Reader, error) {
+	ar, err := openArchive(archivePath, encryptor)
+	if err != nil {
+		return nil, err
+	}
+	return &Reader{archive: ar}, nil
+}
+
+// Next returns the next entry, or io.EOF at the end of the archive. The
+// tool's own entries below MetaDir are not returned; the manifest and
+// incremental records among them are available from Manifest and Records
+// once Next returned io.EOF.
+func (r *Reader) Next() (*Entry, error) {
+	for {
+		header, err := r.archive.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		if header.Name == MetaDir || strings.HasPrefix(header.Name, MetaDir+"/") {
+			switch {
+			case header.Name == ManifestName:
+				if r.manifest, err = ParseManifest(r.archive); err != nil {
+					return nil, err
+				}
+			case strings.HasPrefix(header.Name, IncrementalDir+"/"):
+				record := &IncrementalRecord{}
+				if err := json.NewDecoder(r.archive).Decode(record); err != nil {
+					return nil, fmt.Errorf("invalid incremental record %s: %w", header.Name, err)
+				}
+				r.records = append(r.records, record)
+			}
+			continue
+		}
+
+		return entryFromHeader(header), nil
+	}
+}
+
+// Manifest returns the archive's manifest once it has been read, or nil
+func (r *Reader) Manifest() *Manifest {
+	return r.manifest
+}
+
+// Records returns the incremental records of the archive once they have
+// been read, nil for full backups
+func (r *Reader) Records() []*IncrementalRecord {
+	return r.records
+}
+
+// Close releases the archive
+func (r *Reader) Close() error {
+	return r.archive.Close()
+}
+
+// entryFromHeader converts a tar header into an Entry
+func entryFromHeader(header *tar.Header) *Entry {
+	entry := &Entry{
+		Path:    strings.TrimSuffix(header.Name, "/"),
+		Size:    header.Size,
+		Mode:    fs.FileMode(header.Mode).Perm(),
+		ModTime: header.ModTime,
+	}
+	if entry.ModTime.Unix() == 0 {
+		entry.ModTime = time.Time{}
+	}
+
+	switch header.Typeflag {
+	case tar.TypeReg, tar.TypeGNUSparse:
+		entry.Type = EntryFile
+	case tar.TypeDir:
+		entry.Type = EntryDir
+	case tar.TypeSymlink:
+		entry.Type = EntrySymlink
+		entry.Linkname = header.Linkname
+	case tar.TypeLink:
+		entry.Type = EntryHardlink
+		entry.Linkname = header.Linkname
+	default:
+		entry.Type = EntryOther
+	}
+
+	return entry
+}
+
+// MatchEntry reports whether an archive path matches any of the glob
+// patterns. Patterns use the syntax of Filter; no patterns match everything.
+func MatchEntry(archivePath string, patterns []string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	segments := strings.Split(path.Clean(archivePath), "/")
+	for _, pattern := range patterns {
+		if matchPattern(pattern, segments) {
+			return true
+		}
+	}
+	return false
+}
+
+// List reads all entries of an archive matching the patterns, with content
+// hashes attached from the manifest. Entries keep archive order.
+func List(archivePath string, encryptor *Encryptor, patterns []string) ([]*Entry, error) {
+	entries, _, err := list(archivePath, encryptor, patterns)
+	return entries, err
+}
+
+// CleanPatterns validates entry patterns and returns a copy without the
+// trailing slashes of tar listings of directories
+func CleanPatterns(patterns []string) ([]string, error) {
+	cleaned := make([]string, len(patterns))
+	for i, pattern := range patterns {
+		cleaned[i] = strings.TrimSuffix(pattern, "/")
+	}
+	if err := (Filter{Include: cleaned}).Validate(); err != nil {
+		return nil, err
+	}
+	return cleaned, nil
+}
+
+// list reads the matching entries and the incremental records of an archive
+func list(archivePath string, encryptor *Encryptor, patterns []string) ([]*Entry, []*IncrementalRecord, error) {
+	patterns, err := CleanPatterns(patterns)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	reader, err := Open(archivePath, encryptor)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer reader.Close()
+
+	var entries []*Entry
+	for {
+		entry, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to read archive: %w", err)
+		}
+		if MatchEntry(entry.Path, patterns) {
+			entries = append(entries, entry)
+		}
+	}
+
+	if manifest := reader.Manifest(); manifest != nil {
+		sums := manifest.Lookup()
+		for _, entry := range entries {
+			switch entry.Type {
+			case EntryFile:
+				entry.SHA256 = sums[entry.Path]
+			case EntryHardlink:
+				entry.SHA256 = sums[entry.Linkname]
+			}
+		}
+	}
+
+	return entries, reader.Records(), nil
+}
@@ -0,0 +1,149 @@
+package archive
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"paperless-backup/internal/logger"
+)
+
+func createListArchive(t *testing.T, tmpDir string, log *logger.Logger) string {
+	t.Helper()
+
+	sourceDir := filepath.Join(tmpDir, "source")
+	os.MkdirAll(filepath.Join(sourceDir, "documents", "originals"), 0755)
+	os.WriteFile(filepath.Join(sourceDir, "documents", "originals", "0001.pdf"), []byte("first"), 0644)
+	os.WriteFile(filepath.Join(sourceDir, "documents", "originals", "0002.pdf"), []byte("second"), 0640)
+	os.Symlink("originals/0001.pdf", filepath.Join(sourceDir, "documents", "latest.pdf"))
+
+	creator := New(log, Options{})
+	backupFile := filepath.Join(tmpDir, "backup.tar.gz")
+	if err := creator.Create(context.Background(), backupFile, []Source{{Name: "media", Path: sourceDir}}); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	return backupFile
+}
+
+func TestReaderStreamsEntries(t *testing.T) {
+	tmpDir := t.TempDir()
+	log, _ := logger.New(filepath.Join(tmpDir, "test.log"))
+	defer log.Close()
+
+	backupFile := createListArchive(t, tmpDir, log)
+
+	reader, err := Open(backupFile, nil)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer reader.Close()
+
+	var paths []string
+	for {
+		entry, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Next failed: %v", err)
+		}
+		if strings.HasPrefix(entry.Path, MetaDir) {
+			t.Errorf("Internal entry %s should not be returned", entry.Path)
+		}
+		paths = append(paths, entry.Path)
+	}
+
+	if len(paths) != 6 {
+		t.Errorf("Expected 6 entries, got %v", paths)
+	}
+	if reader.Manifest() == nil || len(reader.Manifest().Entries) != 2 {
+		t.Error("Manifest should be available after the last entry")
+	}
+}
+
+func TestList(t *testing.T) {
+	tmpDir := t.TempDir()
+	log, _ := logger.New(filepath.Join(tmpDir, "test.log"))
+	defer log.Close()
+
+	backupFile := createListArchive(t, tmpDir, log)
+
+	entries, err := List(backupFile, nil, []string{"media/**/*.pdf"})
+	if err != nil {
+		t.Fatalf("List failed: %v", err)
+	}
+	if len(entries) != 3 {
+		t.Fatalf("Expected 3 matching entries, got %d", len(entries))
+	}
+
+	byPath := make(map[string]*Entry)
+	for _, entry := range entries {
+		byPath[entry.Path] = entry
+	}
+
+	first := byPath["media/documents/originals/0001.pdf"]
+	if first == nil || first.Type != EntryFile || first.Size != 5 || first.Mode != 0644 {
+		t.Errorf("Unexpected entry for 0001.pdf: %+v", first)
+	}
+	// sha256("first")
+	if first != nil && first.SHA256 != "a7937b64b8caa58f03721bb6bacf5c78cb235febe0e70b1b84cd99541461a08e" {
+		t.Errorf("Hash should come from the manifest, got %q", first.SHA256)
+	}
+
+	link := byPath["media/documents/latest.pdf"]
+	if link == nil || link.Type != EntrySymlink || link.Linkname != "originals/0001.pdf" || link.SHA256 != "" {
+		t.Errorf("Unexpected entry for symlink: %+v", link)
+	}
+
+	encoded, err := json.Marshal(byPath["media/documents/originals/0002.pdf"])
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	if !strings.Contains(string(encoded), `"mode":"0640"`) || strings.Contains(string(encoded), "mtime") {
+		t.Errorf("Unexpected JSON: %s", encoded)
+	}
+
+	// No match is not an error
+	entries, err = List(backupFile, nil, []string{"media/missing.pdf"})
+	if err != nil || len(entries) != 0 {
+		t.Errorf("Expected no entries, got %v, %v", entries, err)
+	}
+
+	if _, err := List(backupFile, nil, []string{"media/[bad"}); err == nil {
+		t.Error("Invalid pattern should be rejected")
+	}
+
+	// Directory patterns may carry a trailing slash; the caller's slice is
+	// left as it is
+	patterns := []string{"media/documents/"}
+	entries, err = List(backupFile, nil, patterns)
+	if err != nil || len(entries) == 0 {
+		t.Errorf("Expected entries below media/documents, got %v, %v", entries, err)
+	}
+	if patterns[0] != "media/documents/" {
+		t.Errorf("Patterns were modified: %v", patterns)
+	}
+}
+
+func TestMatchEntry(t *testing.T) {
+	tests := []struct {
+		path     string
+		patterns []string
+		match    bool
+	}{
+		{"media/documents/originals/0001.pdf", nil, true},
+		{"media/documents/originals/0001.pdf", []string{"media/**/0001.pdf"}, true},
+		{"media/documents/originals/0001.pdf", []string{"data/**", "**/*.pdf"}, true},
+		{"media/documents/originals/0001.pdf", []string{"media/*.pdf"}, false},
+	}
+
+	for _, tt := range tests {
+		if got := MatchEntry(tt.path, tt.patterns); got != tt.match {
+			t.Errorf("MatchEntry(%q, %v) = %v, want %v", tt.path, tt.patterns, got, tt.match)
+		}
+	}
+}
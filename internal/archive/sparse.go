@@ -0,0 +1,289 @@
+package archive
+
+import (
+	"archive/tar"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+const blockSize = 512
+
+// zeroBlock is a shared source of zeros for padding and hole hashing
+var zeroBlock = make([]byte, 64*1024)
+
+// tarStream is a tar writer together with its underlying stream. archive/tar
+// cannot encode sparse files, so those entries are written to the raw stream
+// between regular entries.
+type tarStream struct {
+	*tar.Writer
+	raw io.Writer
+}
+
+// newTarStream creates a tar writer on w
+func newTarStream(w io.Writer) *tarStream {
+	return &tarStream{
+		Writer: tar.NewWriter(w),
+		raw:    w,
+	}
+}
+
+// region is a range of a file that holds data
+type region struct {
+	Offset int64
+	Length int64
+}
+
+// dataRegions returns the data regions of a sparse file using SEEK_DATA and
+// SEEK_HOLE. It returns nil when the file has no holes or the filesystem
+// cannot report them.
+func dataRegions(file *os.File, size int64) ([]region, error) {
+	fd := int(file.Fd())
+	defer unix.Seek(fd, 0, io.SeekStart)
+
+	var regions []region
+	var offset int64
+	for offset < size {
+		data, err := unix.Seek(fd, offset, unix.SEEK_DATA)
+		if errors.Is(err, unix.ENXIO) {
+			break // Only a hole remains
+		}
+		if errors.Is(err, unix.EINVAL) || errors.Is(err, unix.EOPNOTSUPP) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		hole, err := unix.Seek(fd, data, unix.SEEK_HOLE)
+		if err != nil {
+			return nil, err
+		}
+		if hole > size {
+			hole = size
+		}
+		if hole > data {
+			regions = append(regions, region{Offset: data, Length: hole - data})
+		}
+		offset = hole
+	}
+
+	// A single region spanning the whole file is not sparse
+	if len(regions) == 1 && regions[0].Offset == 0 && regions[0].Length == size {
+		return nil, nil
+	}
+
+	return regions, nil
+}
+
+// writeSparse writes a regular file as a GNU PAX 1.0 sparse entry: the data
+// regions are stored after a textual sparse map, holes take no space. GNU
+// tar, bsdtar and archive/tar restore the original size. The logical content
+// is fed into hash, with holes read as zeros. Data regions are read through
+// wrap, which is given the length of each region.
+func writeSparse(stream *tarStream, header *tar.Header, file *os.File, regions []region, hash hash.Hash, wrap func(io.Reader, int64) io.Reader) error {
+	realSize := header.Size
+
+	// GNU tar ends the map with an empty region at the end of the file
+	if last := regions[len(regions)-1]; last.Offset+last.Length < realSize {
+		regions = append(regions, region{Offset: realSize, Length: 0})
+	}
+
+	var sparseMap strings.Builder
+	fmt.Fprintf(&sparseMap, "%d\n", len(regions))
+	var dataSize int64
+	for _, r := range regions {
+		fmt.Fprintf(&sparseMap, "%d\n%d\n", r.Offset, r.Length)
+		dataSize += r.Length
+	}
+	mapBytes := []byte(sparseMap.String())
+	mapBytes = append(mapBytes, make([]byte, padding(int64(len(mapBytes))))...)
+	storedSize := int64(len(mapBytes)) + dataSize
+
+	records := map[string]string{
+		"GNU.sparse.major":    "1",
+		"GNU.sparse.minor":    "0",
+		"GNU.sparse.name":     header.Name,
+		"GNU.sparse.realsize": strconv.FormatInt(realSize, 10),
+	}
+	for k, v := range header.PAXRecords {
+		records[k] = v
+	}
+	if !header.ModTime.IsZero() {
+		records["mtime"] = formatPAXTime(header.ModTime)
+	}
+	if !header.AccessTime.IsZero() {
+		records["atime"] = formatPAXTime(header.AccessTime)
+	}
+	if !header.ChangeTime.IsZero() {
+		records["ctime"] = formatPAXTime(header.ChangeTime)
+	}
+	if header.Uname != "" {
+		records["uname"] = header.Uname
+	}
+	if header.Gname != "" {
+		records["gname"] = header.Gname
+	}
+	records["size"] = strconv.FormatInt(storedSize, 10)
+	records["uid"] = strconv.Itoa(header.Uid)
+	records["gid"] = strconv.Itoa(header.Gid)
+
+	dir, base := path.Split(header.Name)
+	sparseName := dir + "GNUSparseFile.0/" + base
+
+	// Entries before must be padded before raw blocks can follow
+	if err := stream.Flush(); err != nil {
+		return err
+	}
+
+	paxData := formatPAXRecords(records)
+	paxHeader := ustarHeader(tar.TypeXHeader, dir+"PaxHeaders.0/"+base, header.Mode, 0, 0, int64(len(paxData)), time.Time{})
+	if err := writeBlocks(stream.raw, paxHeader, paxData); err != nil {
+		return err
+	}
+
+	fileHeader := ustarHeader(tar.TypeReg, sparseName, header.Mode, header.Uid, header.Gid, storedSize, header.ModTime)
+	if _, err := stream.raw.Write(fileHeader); err != nil {
+		return err
+	}
+	if _, err := stream.raw.Write(mapBytes); err != nil {
+		return err
+	}
+
+	// Data regions, feeding holes into the hash as zeros
+	var offset int64
+	for _, r := range regions {
+		if err := hashZeros(hash, r.Offset-offset); err != nil {
+			return err
+		}
+		n, err := io.Copy(io.MultiWriter(stream.raw, hash), wrap(io.NewSectionReader(file, r.Offset, r.Length), r.Length))
+		if err != nil {
+			return err
+		}
+		if n != r.Length {
+			return fmt.Errorf("%s: %w", header.Name, io.ErrUnexpectedEOF)
+		}
+		offset = r.Offset + r.Length
+	}
+	if err := hashZeros(hash, realSize-offset); err != nil {
+		return err
+	}
+
+	_, err := stream.raw.Write(zeroBlock[:padding(dataSize)])
+	return err
+}
+
+// hashZeros feeds n zero bytes into a hash
+func hashZeros(hash hash.Hash, n int64) error {
+	for n > 0 {
+		chunk := int64(len(zeroBlock))
+		if chunk > n {
+			chunk = n
+		}
+		if _, err := hash.Write(zeroBlock[:chunk]); err != nil {
+			return err
+		}
+		n -= chunk
+	}
+	return nil
+}
+
+// padding returns the number of bytes needed to fill the last tar block
+func padding(size int64) int64 {
+	return -size & (blockSize - 1)
+}
+
+// writeBlocks writes a header block followed by padded data
+func writeBlocks(w io.Writer, header, data []byte) error {
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+	if _, err := w.Write(data); err != nil {
+		return err
+	}
+	_, err := w.Write(zeroBlock[:padding(int64(len(data)))])
+	return err
+}
+
+// formatPAXRecords encodes records as "<length> <key>=<value>\n" lines, the
+// length including its own digits
+func formatPAXRecords(records map[string]string) []byte {
+	keys := make([]string, 0, len(records))
+	for k := range records {
+		keys = append(keys, k)
+	}
+	// Stable order keeps archives reproducible
+	sort.Strings(keys)
+
+	var out []byte
+	for _, k := range keys {
+		size := len(k) + len(records[k]) + 3 // Space, equals sign, newline
+		size += len(strconv.Itoa(size))
+		record := fmt.Sprintf("%d %s=%s\n", size, k, records[k])
+		if len(record) != size {
+			// Adding the length digits pushed it over a power of ten
+			size = len(record)
+			record = fmt.Sprintf("%d %s=%s\n", size, k, records[k])
+		}
+		out = append(out, record...)
+	}
+	return out
+}
+
+// formatPAXTime formats a timestamp as PAX seconds with fraction
+func formatPAXTime(t time.Time) string {
+	secs, nsecs := t.Unix(), t.Nanosecond()
+	if nsecs == 0 {
+		return strconv.FormatInt(secs, 10)
+	}
+	return strings.TrimRight(fmt.Sprintf("%d.%09d", secs, nsecs), "0")
+}
+
+// ustarHeader builds a single ustar header block. Values that do not fit are
+// carried by the preceding PAX header.
+func ustarHeader(typeflag byte, name string, mode int64, uid, gid int, size int64, modTime time.Time) []byte {
+	block := make([]byte, blockSize)
+
+	if len(name) > 100 {
+		name = name[len(name)-100:]
+	}
+	copy(block[0:100], name)
+	formatOctal(block[100:108], mode&07777)
+	formatOctal(block[108:116], int64(uid))
+	formatOctal(block[116:124], int64(gid))
+	formatOctal(block[124:136], size)
+	formatOctal(block[136:148], modTime.Unix())
+	block[156] = typeflag
+	copy(block[257:263], "ustar\x00")
+	copy(block[263:265], "00")
+
+	// Checksum is computed with the checksum field set to spaces
+	copy(block[148:156], "        ")
+	var sum int64
+	for _, b := range block {
+		sum += int64(b)
+	}
+	copy(block[148:156], fmt.Sprintf("%06o\x00 ", sum))
+
+	return block
+}
+
+// formatOctal writes a NUL terminated octal number, or zero when the value
+// does not fit (the PAX header then carries it)
+func formatOctal(field []byte, value int64) {
+	digits := strconv.FormatInt(value, 8)
+	if value < 0 || len(digits) > len(field)-1 {
+		digits = "0"
+	}
+	copy(field, strings.Repeat("0", len(field)-1-len(digits))+digits)
+	field[len(field)-1] = 0
+}
@@ -2,157 +2,681 @@ package archive
 
 import (
 	"archive/tar"
-	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"os"
+	"path"
 	"path/filepath"
+	"runtime"
 	"strings"
+	"syscall"
 	"time"
 
 	"paperless-backup/internal/logger"
 )
 
-// Creator handles tar.gz archive creation and verification
+// PAXMountpoint is the PAX record on a source's root entry that holds the
+// host path the source was read from
+const PAXMountpoint = "PAPERLESSBACKUP.mountpoint"
+
+// Source is a directory to archive under a stable logical root
+type Source struct {
+	Name   string // Logical root inside the archive (e.g. "media")
+	Path   string // Host path the content is read from
+	Filter Filter // Include and exclude rules, empty archives everything
+
+	// Base makes the source incremental: only regular files that changed
+	// since the base snapshot are stored, deletions are recorded
+	Base *Snapshot
+
+	// ReadErrors is the policy for files that vanish or change while they
+	// are read, one of the ReadErrors* constants, strict when empty
+	ReadErrors string
+}
+
+// Options controls how archives are written
+type Options struct {
+	Codec            *Codec // Compression codec, gzip when nil
+	CompressionLevel int    // Codec specific level, 0 selects the codec default
+	Workers          int    // Parallel compression workers, 0 uses all CPU cores
+	DeepVerify       bool   // Re-hash every file against the manifest before publishing
+
+	Encryptor *Encryptor // Encrypts archives after compression, nil for plaintext
+
+	// PreserveMetadata keeps real timestamps, ownership and extended
+	// attributes (including POSIX ACLs) instead of zeroing timestamps, and
+	// records the host and run times in the metadata
+	PreserveMetadata bool
+
+	// PartSize splits the archive into parts of at most this many bytes,
+	// 0 writes a single file
+	PartSize int64
+
+	// ProgressInterval is the time between progress log lines. Sources are
+	// pre-scanned for totals when set, 0 disables progress reporting.
+	ProgressInterval time.Duration
+
+	// ReadLimit caps the rate source files are read at in bytes per second,
+	// 0 reads at full speed
+	ReadLimit int64
+
+	// DropPageCache evicts source files from the page cache once they are
+	// archived, so a backup does not displace other workloads' cached data
+	DropPageCache bool
+
+	// ParityPercent writes Reed-Solomon parity sidecars of this size in
+	// percent of the archive, to repair bit rot; 0 writes none
+	ParityPercent int
+}
+
+// Creator handles compressed tar archive creation and verification
 type Creator struct {
-	logger *logger.Logger
+	logger    *logger.Logger
+	options   Options
+	manifest  *Manifest
+	links     map[fileID]string // First archive path of each multiply linked inode
+	snapshots []*Snapshot       // State of each source, for the next incremental
+	records   map[string]*IncrementalRecord
+	progress  *progress    // nil unless progress reporting is enabled
+	limiter   *rateLimiter // nil unless reads are capped
+	warnings  []Warning    // Problems tolerated by the last Create
+	metadata  Metadata     // Caller's part of the metadata, see SetMetadata
+}
+
+// fileID identifies an inode across the archived sources
+type fileID struct {
+	dev uint64
+	ino uint64
 }
 
 // New creates a new archive Creator
-func New(logger *logger.Logger) *Creator {
+func New(logger *logger.Logger, options Options) *Creator {
+	if options.Codec == nil {
+		options.Codec = gzipCodec
+	}
+	if options.Workers <= 0 {
+		options.Workers = runtime.NumCPU()
+	}
+
 	return &Creator{
-		logger: logger,
+		logger:  logger,
+		options: options,
+		limiter: newRateLimiter(options.ReadLimit),
 	}
 }
 
-// Create creates a compressed tar.gz archive of specified paths
-func (c *Creator) Create(outputPath string, sourcePaths []string) error {
-	c.logger.Logf("INFO", "Creating compressed backup archive: %s", outputPath)
+// Extension returns the file extension of archives written by this Creator
+func (c *Creator) Extension() string {
+	if c.options.Encryptor != nil {
+		return c.options.Codec.Extension + EncryptedExt
+	}
+	return c.options.Codec.Extension
+}
 
-	// Create output file
-	outFile, err := os.Create(outputPath)
+// CanDecrypt reports whether archives written by this Creator can be read
+// back on this host
+func (c *Creator) CanDecrypt() bool {
+	return c.options.Encryptor == nil || c.options.Encryptor.CanDecrypt()
+}
+
+// Create creates a compressed tar archive of the given sources. The archive
+// is written to partial files, synced and verified before it is atomically
+// renamed to outputPath, so a crash never leaves a truncated archive under
+// the final name. With a part size set the archive is split into numbered
+// parts listed in an index file. Cancelling ctx stops the archive before it
+// is published and removes the partial files.
+func (c *Creator) Create(ctx context.Context, outputPath string, sources []Source) error {
+	c.logger.Logf("INFO", "Creating compressed backup archive: %s (%s)", outputPath, c.options.Codec.Name)
+
+	if err := ValidateSources(sources); err != nil {
+		return err
+	}
+
+	var output archiveOutput
+	if c.options.PartSize > 0 {
+		c.logger.Logf("INFO", "Splitting archive into parts of at most %d bytes", c.options.PartSize)
+		output = newPartOutput(outputPath, c.options.PartSize)
+	} else {
+		fileOutput, err := newFileOutput(outputPath)
+		if err != nil {
+			return err
+		}
+		output = fileOutput
+	}
+
+	archiveSum, manifestSum, err := c.writeArchive(ctx, output, sources)
 	if err != nil {
-		return fmt.Errorf("failed to create backup file: %w", err)
+		return c.abort(ctx, output, err)
 	}
-	defer outFile.Close()
 
-	// Create gzip writer with no timestamp for deterministic output
-	gzWriter := gzip.NewWriter(outFile)
-	gzWriter.ModTime = time.Time{} // Zero time for reproducibility
-	defer gzWriter.Close()
+	// Only a verified archive may replace the partial files. Archives this
+	// host cannot decrypt were verified while they were written.
+	if c.CanDecrypt() {
+		if err := c.verifyCreated(ctx, output.VerifyPath()); err != nil {
+			return c.abort(ctx, output, err)
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return c.abort(ctx, output, err)
+	}
+
+	if err := output.Publish(archiveSum); err != nil {
+		output.Abort()
+		return err
+	}
+
+	// The archive is complete without parity, so failing to protect it
+	// does not fail the backup
+	if c.options.ParityPercent > 0 && ctx.Err() != nil {
+		c.logger.Log("WARN", "Interrupted - parity data not written")
+	} else if c.options.ParityPercent > 0 {
+		if err := c.writeParity(outputPath); err != nil {
+			c.logger.Logf("WARN", "Failed to write parity data: %v", err)
+		}
+	}
+
+	sizeMB := float64(output.Size()) / 1024 / 1024
+	c.logger.Logf("INFO", "Backup created successfully: %s (%.2fMB)", outputPath, sizeMB)
+	if parts, ok := output.(*partOutput); ok {
+		c.logger.Logf("INFO", "Archive split into %d parts, index: %s", len(parts.parts), filepath.Base(IndexPath(outputPath)))
+	}
+	c.logger.Logf("INFO", "Manifest SHA-256: %s (%d files)", hex.EncodeToString(manifestSum), len(c.manifest.Entries))
+	c.logger.Logf("INFO", "Archive SHA-256: %s", hex.EncodeToString(archiveSum))
+
+	return nil
+}
+
+// abort removes the partial files of an archive that failed or was
+// interrupted and returns err
+func (c *Creator) abort(ctx context.Context, output archiveOutput, err error) error {
+	output.Abort()
+	if ctx.Err() != nil {
+		c.logger.Log("WARN", "Interrupted - partial archive removed")
+	}
+	return err
+}
+
+// writeArchive streams the sources through tar, compression and encryption
+// into output and syncs it to disk. It returns the SHA-256 of the written
+// stream and of the embedded manifest.
+func (c *Creator) writeArchive(ctx context.Context, output archiveOutput, sources []Source) ([]byte, []byte, error) {
+	// Hash the compressed stream while writing for the checksum sidecar
+	archiveHash := sha256.New()
+	c.manifest = &Manifest{}
+	c.links = make(map[fileID]string)
+	c.snapshots = nil
+	c.records = make(map[string]*IncrementalRecord)
+	c.warnings = nil
+
+	// Encrypt the compressed stream when configured
+	var sink io.Writer = io.MultiWriter(output, archiveHash)
+	var encWriter io.WriteCloser
+	var err error
+	if c.options.Encryptor != nil {
+		c.logger.Logf("INFO", "Encrypting backup archive (%s mode)", c.options.Encryptor.Mode())
+		if encWriter, err = c.options.Encryptor.encrypt(sink); err != nil {
+			return nil, nil, fmt.Errorf("failed to create encryption writer: %w", err)
+		}
+		defer encWriter.Close()
+		sink = encWriter
+	}
+
+	// With public key encryption the decryption key may deliberately live
+	// elsewhere, so the compressed stream is verified before encryption
+	var verifier *streamVerifier
+	if !c.CanDecrypt() {
+		c.logger.Log("INFO", "No decryption key configured - verifying the archive before encryption")
+		verifier = c.newStreamVerifier(ctx)
+		defer verifier.Close()
+		sink = io.MultiWriter(sink, verifier)
+	}
+
+	// Create compressor
+	compWriter, err := c.newCompressor(sink)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create %s writer: %w", c.options.Codec.Name, err)
+	}
+	defer compWriter.Close()
 
 	// Create tar writer
-	tarWriter := tar.NewWriter(gzWriter)
+	tarWriter := newTarStream(compWriter)
 	defer tarWriter.Close()
 
-	// Add each path to the tar
-	for _, path := range sourcePaths {
-		if err := c.addToTar(tarWriter, path); err != nil {
-			return fmt.Errorf("failed to add %s to archive: %w", path, err)
+	// The metadata comes first, so it can be read without the content
+	metadata := c.newMetadata(sources)
+	if err := writeJSONEntry(tarWriter, MetadataName, metadata); err != nil {
+		return nil, nil, err
+	}
+
+	c.progress = nil
+	if c.options.ProgressInterval > 0 {
+		c.progress = newProgress(c.logger, c.options.ProgressInterval, sources)
+	}
+
+	// Add each source to the tar
+	for _, source := range sources {
+		if err := c.addToTar(ctx, tarWriter, source); err != nil {
+			c.progress.clear()
+			return nil, nil, fmt.Errorf("failed to add %s to archive: %w", source.Path, err)
 		}
 	}
+	c.progress.finish()
+
+	// Deletions are only known once every source has been walked
+	if err := c.writeIncrementalRecords(tarWriter); err != nil {
+		return nil, nil, err
+	}
+
+	if err := c.writeWarnings(tarWriter); err != nil {
+		return nil, nil, err
+	}
+	completion := &CompletionRecord{
+		Finished: c.runTime(),
+		Files:    len(c.manifest.Entries),
+		Warnings: len(c.warnings),
+	}
+	if err := writeJSONEntry(tarWriter, CompletionName, completion); err != nil {
+		return nil, nil, err
+	}
+
+	// The manifest is written last, once every file has been hashed
+	manifestSum, err := c.writeManifest(tarWriter)
+	if err != nil {
+		return nil, nil, err
+	}
 
 	// Close writers to flush
 	if err := tarWriter.Close(); err != nil {
-		return fmt.Errorf("failed to close tar writer: %w", err)
+		return nil, nil, fmt.Errorf("failed to close tar writer: %w", err)
+	}
+	if err := compWriter.Close(); err != nil {
+		return nil, nil, fmt.Errorf("failed to close %s writer: %w", c.options.Codec.Name, err)
 	}
-	if err := gzWriter.Close(); err != nil {
-		return fmt.Errorf("failed to close gzip writer: %w", err)
+	if encWriter != nil {
+		if err := encWriter.Close(); err != nil {
+			return nil, nil, fmt.Errorf("failed to close encryption writer: %w", err)
+		}
 	}
-	if err := outFile.Close(); err != nil {
-		return fmt.Errorf("failed to close output file: %w", err)
+	if verifier != nil {
+		if err := verifier.Close(); err != nil {
+			return nil, nil, err
+		}
 	}
 
-	// Set restrictive permissions
-	if err := os.Chmod(outputPath, 0600); err != nil {
-		return fmt.Errorf("failed to set permissions: %w", err)
+	// Make the content durable before it can be published
+	if err := output.Commit(); err != nil {
+		return nil, nil, err
 	}
 
-	// Get backup size
-	info, err := os.Stat(outputPath)
+	return archiveHash.Sum(nil), manifestSum, nil
+}
+
+// verifyCreated checks a freshly written archive, re-hashing its content when
+// deep verification is enabled
+func (c *Creator) verifyCreated(ctx context.Context, path string) error {
+	tarReader, err := openArchive(path, c.options.Encryptor)
 	if err != nil {
-		return fmt.Errorf("failed to stat backup file: %w", err)
+		return err
 	}
+	defer tarReader.Close()
+	return c.checkCreated(ctx, tarReader)
+}
 
-	sizeMB := float64(info.Size()) / 1024 / 1024
-	c.logger.Logf("INFO", "Backup created successfully: %s (%.2fMB)", outputPath, sizeMB)
+// checkCreated runs the verification configured for new archives on an
+// opened archive
+func (c *Creator) checkCreated(ctx context.Context, tarReader *archiveReader) error {
+	if !c.options.DeepVerify {
+		return c.verifyReader(ctx, tarReader)
+	}
 
+	result, err := c.verifyDeepReader(ctx, tarReader)
+	if err != nil {
+		return err
+	}
+	if !result.OK() {
+		return fmt.Errorf("backup content verification failed (%s)", result.Summary())
+	}
 	return nil
 }
 
-// addToTar recursively adds a directory and its contents to the tar archive
-func (c *Creator) addToTar(tarWriter *tar.Writer, source string) error {
-	return filepath.Walk(source, func(path string, info os.FileInfo, err error) error {
+// newCompressor returns the codec writer. Compressed streams are always
+// written in parallel blocks, even with a single worker, so the output does
+// not depend on the worker count.
+func (c *Creator) newCompressor(w io.Writer) (io.WriteCloser, error) {
+	if c.options.Codec != noneCodec {
+		return newParallelWriter(w, c.options.Codec, c.options.CompressionLevel, c.options.Workers), nil
+	}
+	return c.options.Codec.NewWriter(w, c.options.CompressionLevel)
+}
+
+// writeManifest appends the manifest as the final archive entry and returns
+// its own SHA-256
+func (c *Creator) writeManifest(tarWriter *tarStream) ([]byte, error) {
+	content := c.manifest.Bytes()
+
+	header := &tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     ManifestName,
+		Mode:     0644,
+		Size:     int64(len(content)),
+	}
+	if err := tarWriter.WriteHeader(header); err != nil {
+		return nil, fmt.Errorf("failed to write manifest header: %w", err)
+	}
+	if _, err := tarWriter.Write(content); err != nil {
+		return nil, fmt.Errorf("failed to write manifest: %w", err)
+	}
+
+	sum := sha256.Sum256(content)
+	return sum[:], nil
+}
+
+// ValidateSources ensures every source has a unique, single-segment logical
+// name and valid filter and read error settings
+func ValidateSources(sources []Source) error {
+	seen := make(map[string]bool)
+	for _, source := range sources {
+		if source.Name == "" || source.Name == "." || source.Name == ".." || source.Name == MetaDir || strings.ContainsAny(source.Name, "/\\") {
+			return fmt.Errorf("invalid logical name %q", source.Name)
+		}
+		if seen[source.Name] {
+			return fmt.Errorf("duplicate logical name %q", source.Name)
+		}
+		if err := source.Filter.Validate(); err != nil {
+			return fmt.Errorf("source %q: %w", source.Name, err)
+		}
+		if err := ValidateReadErrors(source.ReadErrors); err != nil {
+			return fmt.Errorf("source %q: %w", source.Name, err)
+		}
+		seen[source.Name] = true
+	}
+	return nil
+}
+
+// addToTar recursively adds a source directory and its contents to the tar
+// archive, storing entries below the source's logical name. Walk visits
+// entries in lexical order, so identical trees always produce entries in the
+// same order. Cancelling ctx stops the walk.
+func (c *Creator) addToTar(ctx context.Context, tarWriter *tarStream, source Source) error {
+	if c.links == nil {
+		c.links = make(map[fileID]string)
+	}
+
+	snapshot := &Snapshot{Source: source.Name, Created: now(), Files: make(map[string]FileState)}
+
+	var excluded filterStats
+	var unchanged int
+	c.progress.startSource(source.Name)
+	err := filepath.Walk(source.Path, func(filePath string, info os.FileInfo, err error) error {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+
+		rel, relErr := filepath.Rel(source.Path, filePath)
+		if relErr != nil {
+			return relErr
+		}
+		rel = filepath.ToSlash(rel)
+		name := path.Join(source.Name, rel)
+
+		// Files deleted since their directory was listed
 		if err != nil {
-			return err
+			return c.skipVanished(source, name, err)
+		}
+
+		// Apply the source's filter, pruning excluded directories
+		if rel != "." && !source.Filter.IsEmpty() {
+			switch source.Filter.decide(rel, info.IsDir()) {
+			case filterPrune:
+				excluded.Dirs++
+				return filepath.SkipDir
+			case filterSkip:
+				excluded.addFile(info)
+				return nil
+			}
+		}
+
+		// Symlinks keep their target
+		var linkTarget string
+		if info.Mode()&os.ModeSymlink != 0 {
+			if linkTarget, err = os.Readlink(filePath); err != nil {
+				return c.skipVanished(source, name, err)
+			}
 		}
 
 		// Create tar header
-		header, err := tar.FileInfoHeader(info, "")
+		header, err := tar.FileInfoHeader(info, linkTarget)
 		if err != nil {
 			return err
 		}
 
-		// Update header name to use full path
-		header.Name = path
-		if strings.HasPrefix(header.Name, "/") {
-			header.Name = strings.TrimPrefix(header.Name, "/")
+		// Store the entry relative to the source under its logical root
+		header.Name = name
+
+		// Every path is recorded, unchanged files of incremental sources
+		// are not stored again
+		state := stateOf(info)
+		snapshot.Files[header.Name] = state
+		if source.Base != nil && info.Mode().IsRegular() && source.Base.unchanged(header.Name, state) {
+			unchanged++
+			return nil
 		}
 
-		// Normalize timestamps for deterministic archives
-		// This ensures identical content produces identical checksums
-		header.ModTime = time.Time{}
-		header.AccessTime = time.Time{}
-		header.ChangeTime = time.Time{}
+		// Record where the source was mounted on the original host and
+		// which content was left out on purpose
+		if rel == "." {
+			setPAXRecord(header, PAXMountpoint, source.Path)
+			recordFilter(header, source.Filter)
+		}
 
-		// Write header
-		if err := tarWriter.WriteHeader(header); err != nil {
-			return err
+		if c.options.PreserveMetadata {
+			// PAX keeps sub-second timestamps and extended attributes
+			header.Format = tar.FormatPAX
+			xattrs, err := readXattrs(filePath)
+			if errors.Is(err, fs.ErrNotExist) && source.Tolerant() {
+				delete(snapshot.Files, name)
+				return c.skipVanished(source, name, err)
+			}
+			if err != nil {
+				return fmt.Errorf("failed to read extended attributes of %s: %w", filePath, err)
+			}
+			for attr, value := range xattrs {
+				setPAXRecord(header, paxXattrPrefix+attr, value)
+			}
+		} else {
+			// Normalize timestamps for deterministic archives
+			// This ensures identical content produces identical checksums
+			header.ModTime = time.Time{}
+			header.AccessTime = time.Time{}
+			header.ChangeTime = time.Time{}
+		}
+
+		// Further names of an already archived inode become hard links
+		stat, _ := info.Sys().(*syscall.Stat_t)
+		var link *fileID
+		if info.Mode().IsRegular() && stat != nil && stat.Nlink > 1 {
+			id := fileID{dev: uint64(stat.Dev), ino: stat.Ino}
+			if first, ok := c.links[id]; ok {
+				header.Typeflag = tar.TypeLink
+				header.Linkname = first
+				header.Size = 0
+				c.progress.completeFile(info.Size())
+				return tarWriter.WriteHeader(header)
+			}
+			link = &id
 		}
 
 		// If not a regular file (directory, symlink, etc.), skip content
 		if !info.Mode().IsRegular() {
-			return nil
+			return tarWriter.WriteHeader(header)
 		}
 
 		// Write file content
-		file, err := os.Open(path)
+		file, err := os.Open(filePath)
 		if err != nil {
-			return err
+			delete(snapshot.Files, name)
+			return c.skipVanished(source, name, err)
 		}
 		defer file.Close()
+		c.adviseSequential(file)
+		defer c.dropPageCache(file)
 
-		if _, err := io.Copy(tarWriter, file); err != nil {
+		// Only stored files can be the target of later hard links
+		if link != nil {
+			c.links[*link] = header.Name
+		}
+
+		hash := sha256.New()
+
+		// Files occupying fewer blocks than their size may have holes
+		if stat != nil && stat.Blocks*512 < info.Size() {
+			regions, err := dataRegions(file, info.Size())
+			if err != nil {
+				return fmt.Errorf("failed to map sparse file %s: %w", filePath, err)
+			}
+			if regions != nil {
+				var sections []*sizedReader
+				wrap := func(r io.Reader, size int64) io.Reader {
+					section := &sizedReader{reader: c.sourceReader(ctx, r), remaining: size, pad: source.Tolerant()}
+					sections = append(sections, section)
+					return section
+				}
+				if err := writeSparse(tarWriter, header, file, regions, hash, wrap); err != nil {
+					if errors.Is(err, io.ErrUnexpectedEOF) {
+						return fmt.Errorf("%s: %w", name, ErrSourceChanged)
+					}
+					return err
+				}
+				short := false
+				for _, section := range sections {
+					short = short || section.short
+				}
+				if err := c.checkChanged(source, name, file, info, short, snapshot); err != nil {
+					return err
+				}
+				c.progress.completeFile(info.Size())
+				if c.manifest != nil {
+					c.manifest.Add(header.Name, hash.Sum(nil))
+				}
+				return nil
+			}
+		}
+
+		// Small files of tolerant sources are re-read until consistent
+		if source.Tolerant() && info.Size() <= rereadLimit {
+			content, current, err := c.readStable(ctx, name, file, snapshot)
+			if err != nil {
+				return err
+			}
+			header.Size = int64(len(content))
+			if c.options.PreserveMetadata {
+				header.ModTime = current.ModTime()
+			}
+			if snapshot.Files[name] != changedState {
+				snapshot.Files[name] = stateOf(current)
+			}
+			if err := tarWriter.WriteHeader(header); err != nil {
+				return err
+			}
+			if _, err := io.MultiWriter(tarWriter, hash).Write(content); err != nil {
+				return err
+			}
+			c.progress.completeFile(header.Size)
+			if c.manifest != nil {
+				c.manifest.Add(header.Name, hash.Sum(nil))
+			}
+			return nil
+		}
+
+		// Write header
+		if err := tarWriter.WriteHeader(header); err != nil {
 			return err
 		}
 
+		// Exactly the walked size is stored, whatever happens to the file
+		content := &sizedReader{reader: c.sourceReader(ctx, file), remaining: header.Size, pad: source.Tolerant()}
+		if _, err := io.Copy(io.MultiWriter(tarWriter, hash), content); err != nil {
+			if errors.Is(err, io.ErrUnexpectedEOF) {
+				return fmt.Errorf("%s: %w", name, ErrSourceChanged)
+			}
+			return err
+		}
+		if err := c.checkChanged(source, name, file, info, content.short, snapshot); err != nil {
+			return err
+		}
+		c.progress.completeFile(info.Size())
+		if c.manifest != nil {
+			c.manifest.Add(header.Name, hash.Sum(nil))
+		}
+
 		return nil
 	})
+	if err != nil {
+		return err
+	}
+
+	c.progress.clear()
+	c.snapshots = append(c.snapshots, snapshot)
+	if source.Base != nil {
+		record := newIncrementalRecord(source.Base, snapshot)
+		if c.records == nil {
+			c.records = make(map[string]*IncrementalRecord)
+		}
+		c.records[source.Name] = record
+		c.logger.Logf("INFO", "Incremental %s: %d unchanged files skipped, %d deletions since %s",
+			source.Name, unchanged, len(record.Deleted), source.Base.Archive)
+	}
+
+	if excluded.Dirs > 0 || excluded.Files > 0 {
+		c.logger.Logf("INFO", "Excluded from %s: %d directories (not descended), %d files (%.2fMB)",
+			source.Name, excluded.Dirs, excluded.Files, float64(excluded.Bytes)/1024/1024)
+	}
+	return nil
 }
 
-// Verify validates the integrity of a tar.gz archive
-func (c *Creator) Verify(archivePath string) error {
-	c.logger.Log("INFO", "Verifying backup integrity...")
+// setPAXRecord adds a PAX record to a header
+func setPAXRecord(header *tar.Header, key, value string) {
+	if header.PAXRecords == nil {
+		header.PAXRecords = make(map[string]string)
+	}
+	header.PAXRecords[key] = value
+}
 
-	file, err := os.Open(archivePath)
-	if err != nil {
-		return fmt.Errorf("failed to open backup file: %w", err)
+// Verify validates the integrity of a compressed tar archive, detecting the
+// codec from the file content. A failure notes whether the archive can be
+// repaired from its parity data.
+func (c *Creator) Verify(archivePath string) error {
+	if err := c.verify(context.Background(), archivePath); err != nil {
+		return c.parityHint(archivePath, err)
 	}
-	defer file.Close()
+	return nil
+}
 
-	// Create gzip reader
-	gzReader, err := gzip.NewReader(file)
+// verify reads through every entry of an archive until ctx is cancelled
+func (c *Creator) verify(ctx context.Context, archivePath string) error {
+	tarReader, err := openArchive(archivePath, c.options.Encryptor)
 	if err != nil {
-		return fmt.Errorf("backup integrity check failed (gzip): %w", err)
+		return err
 	}
-	defer gzReader.Close()
+	defer tarReader.Close()
+	return c.verifyReader(ctx, tarReader)
+}
 
-	// Create tar reader
-	tarReader := tar.NewReader(gzReader)
+// verifyReader reads through every entry of an opened archive
+func (c *Creator) verifyReader(ctx context.Context, tarReader *archiveReader) error {
+	c.logger.Log("INFO", "Verifying backup integrity...")
 
 	// Read through all entries to verify integrity
 	fileCount := 0
 	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
 		_, err := tarReader.Next()
 		if err == io.EOF {
 			break
@@ -163,7 +687,13 @@ func (c *Creator) Verify(archivePath string) error {
 		fileCount++
 	}
 
+	if err := tarReader.finish(); err != nil {
+		return err
+	}
+
+	if tarReader.encrypted {
+		c.logger.Log("INFO", "Encrypted archive authenticated")
+	}
 	c.logger.Logf("INFO", "Backup integrity check passed (%d files)", fileCount)
 	return nil
 }
-
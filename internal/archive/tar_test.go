@@ -2,19 +2,27 @@ package archive
 
 import (
 	"archive/tar"
+	"bytes"
 	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
 	"io"
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
 
 	"paperless-backup/internal/logger"
+
+	"golang.org/x/sys/unix"
 )
 
 func TestCreate(t *testing.T) {
 	tmpDir := t.TempDir()
 	logPath := filepath.Join(tmpDir, "test.log")
-	
+
 	log, _ := logger.New(logPath)
 	defer log.Close()
 
@@ -35,12 +43,16 @@ func TestCreate(t *testing.T) {
 	backupDir := filepath.Join(tmpDir, "backups")
 	os.MkdirAll(backupDir, 0755)
 
-	creator := New(log)
+	creator := New(log, Options{})
 	backupFile := filepath.Join(backupDir, "test_backup.tar.gz")
-	sourcePaths := []string{dataDir, mediaDir, redisDir}
+	sources := []Source{
+		{Name: "data", Path: dataDir},
+		{Name: "media", Path: mediaDir},
+		{Name: "redis", Path: redisDir},
+	}
 
 	// Create backup
-	err := creator.Create(backupFile, sourcePaths)
+	err := creator.Create(context.Background(), backupFile, sources)
 	if err != nil {
 		t.Fatalf("Create failed: %v", err)
 	}
@@ -64,13 +76,13 @@ func TestCreate(t *testing.T) {
 func TestVerify(t *testing.T) {
 	tmpDir := t.TempDir()
 	logPath := filepath.Join(tmpDir, "test.log")
-	
+
 	log, _ := logger.New(logPath)
 	defer log.Close()
 
 	// Create a valid tar.gz file
 	backupFile := filepath.Join(tmpDir, "test_backup.tar.gz")
-	
+
 	file, _ := os.Create(backupFile)
 	gzWriter := gzip.NewWriter(file)
 	tarWriter := tar.NewWriter(gzWriter)
@@ -88,7 +100,7 @@ func TestVerify(t *testing.T) {
 	gzWriter.Close()
 	file.Close()
 
-	creator := New(log)
+	creator := New(log, Options{})
 
 	// Verify should succeed
 	err := creator.Verify(backupFile)
@@ -100,7 +112,7 @@ func TestVerify(t *testing.T) {
 func TestVerifyInvalid(t *testing.T) {
 	tmpDir := t.TempDir()
 	logPath := filepath.Join(tmpDir, "test.log")
-	
+
 	log, _ := logger.New(logPath)
 	defer log.Close()
 
@@ -108,7 +120,7 @@ func TestVerifyInvalid(t *testing.T) {
 	backupFile := filepath.Join(tmpDir, "invalid.tar.gz")
 	os.WriteFile(backupFile, []byte("not a valid tar.gz file"), 0644)
 
-	creator := New(log)
+	creator := New(log, Options{})
 
 	// Verify should fail
 	err := creator.Verify(backupFile)
@@ -120,7 +132,7 @@ func TestVerifyInvalid(t *testing.T) {
 func TestAddToTar(t *testing.T) {
 	tmpDir := t.TempDir()
 	logPath := filepath.Join(tmpDir, "test.log")
-	
+
 	log, _ := logger.New(logPath)
 	defer log.Close()
 
@@ -133,12 +145,12 @@ func TestAddToTar(t *testing.T) {
 	// Create tar file
 	tarFile := filepath.Join(tmpDir, "test.tar")
 	file, _ := os.Create(tarFile)
-	tarWriter := tar.NewWriter(file)
+	tarWriter := newTarStream(file)
 
-	creator := New(log)
+	creator := New(log, Options{})
 
 	// Add directory to tar
-	err := creator.addToTar(tarWriter, sourceDir)
+	err := creator.addToTar(context.Background(), tarWriter, Source{Name: "source", Path: sourceDir})
 	if err != nil {
 		t.Fatalf("addToTar failed: %v", err)
 	}
@@ -169,3 +181,442 @@ func TestAddToTar(t *testing.T) {
 	}
 }
 
+func TestAddToTarLogicalLayout(t *testing.T) {
+	tmpDir := t.TempDir()
+	logPath := filepath.Join(tmpDir, "test.log")
+
+	log, _ := logger.New(logPath)
+	defer log.Close()
+
+	// Create source directory nested deep like a docker volume
+	sourceDir := filepath.Join(tmpDir, "volumes", "paperless-ngx_media", "_data")
+	os.MkdirAll(filepath.Join(sourceDir, "documents"), 0755)
+	os.WriteFile(filepath.Join(sourceDir, "documents", "0001.pdf"), []byte("pdf"), 0644)
+
+	var buf bytes.Buffer
+	tarWriter := newTarStream(&buf)
+
+	creator := New(log, Options{})
+	if err := creator.addToTar(context.Background(), tarWriter, Source{Name: "media", Path: sourceDir}); err != nil {
+		t.Fatalf("addToTar failed: %v", err)
+	}
+	tarWriter.Close()
+
+	tarReader := tar.NewReader(&buf)
+	var names []string
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Error reading tar: %v", err)
+		}
+		names = append(names, header.Name)
+
+		if header.Name == "media" && header.PAXRecords[PAXMountpoint] != sourceDir {
+			t.Errorf("Expected mountpoint record %s, got %q", sourceDir, header.PAXRecords[PAXMountpoint])
+		}
+	}
+
+	expected := []string{"media", "media/documents", "media/documents/0001.pdf"}
+	if len(names) != len(expected) {
+		t.Fatalf("Expected entries %v, got %v", expected, names)
+	}
+	for i := range expected {
+		if names[i] != expected[i] {
+			t.Errorf("Entry %d: expected %s, got %s", i, expected[i], names[i])
+		}
+	}
+}
+
+func TestCreateRejectsInvalidSourceNames(t *testing.T) {
+	tmpDir := t.TempDir()
+	logPath := filepath.Join(tmpDir, "test.log")
+
+	log, _ := logger.New(logPath)
+	defer log.Close()
+
+	creator := New(log, Options{})
+	backupFile := filepath.Join(tmpDir, "test_backup.tar.gz")
+
+	invalid := [][]Source{
+		{{Name: "", Path: tmpDir}},
+		{{Name: "../escape", Path: tmpDir}},
+		{{Name: "data", Path: tmpDir}, {Name: "data", Path: tmpDir}},
+	}
+	for _, sources := range invalid {
+		if err := creator.Create(context.Background(), backupFile, sources); err == nil {
+			t.Errorf("Create should reject sources %v", sources)
+		}
+	}
+}
+
+func TestCreateWritesManifest(t *testing.T) {
+	tmpDir := t.TempDir()
+	logPath := filepath.Join(tmpDir, "test.log")
+
+	log, _ := logger.New(logPath)
+	defer log.Close()
+
+	sourceDir := filepath.Join(tmpDir, "source")
+	os.MkdirAll(filepath.Join(sourceDir, "subdir"), 0755)
+	os.WriteFile(filepath.Join(sourceDir, "file1.txt"), []byte("content1"), 0644)
+	os.WriteFile(filepath.Join(sourceDir, "subdir", "file2.txt"), []byte("content2"), 0644)
+
+	creator := New(log, Options{})
+	backupFile := filepath.Join(tmpDir, "test_backup.tar.gz")
+	if err := creator.Create(context.Background(), backupFile, []Source{{Name: "data", Path: sourceDir}}); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+
+	file, _ := os.Open(backupFile)
+	defer file.Close()
+	gzReader, err := gzip.NewReader(file)
+	if err != nil {
+		t.Fatalf("gzip reader failed: %v", err)
+	}
+	tarReader := tar.NewReader(gzReader)
+
+	var lastName string
+	var lastContent []byte
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Error reading tar: %v", err)
+		}
+		lastName = header.Name
+		lastContent, _ = io.ReadAll(tarReader)
+	}
+
+	if lastName != ManifestName {
+		t.Fatalf("Expected manifest as final entry, got %s", lastName)
+	}
+
+	manifest, err := ParseManifest(bytes.NewReader(lastContent))
+	if err != nil {
+		t.Fatalf("ParseManifest failed: %v", err)
+	}
+
+	sums := manifest.Lookup()
+	expected := sha256.Sum256([]byte("content2"))
+	if sums["data/subdir/file2.txt"] != hex.EncodeToString(expected[:]) {
+		t.Errorf("Unexpected hash for data/subdir/file2.txt: %q", sums["data/subdir/file2.txt"])
+	}
+	if len(sums) != 2 {
+		t.Errorf("Expected 2 manifest entries, got %d", len(sums))
+	}
+}
+
+func TestCreateWritesChecksumFile(t *testing.T) {
+	tmpDir := t.TempDir()
+	logPath := filepath.Join(tmpDir, "test.log")
+
+	log, _ := logger.New(logPath)
+	defer log.Close()
+
+	sourceDir := filepath.Join(tmpDir, "source")
+	os.MkdirAll(sourceDir, 0755)
+	os.WriteFile(filepath.Join(sourceDir, "file1.txt"), []byte("content1"), 0644)
+
+	creator := New(log, Options{})
+	backupFile := filepath.Join(tmpDir, "test_backup.tar.gz")
+	if err := creator.Create(context.Background(), backupFile, []Source{{Name: "data", Path: sourceDir}}); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+
+	content, err := os.ReadFile(ChecksumPath(backupFile))
+	if err != nil {
+		t.Fatalf("Checksum file should exist: %v", err)
+	}
+
+	archiveContent, _ := os.ReadFile(backupFile)
+	sum := sha256.Sum256(archiveContent)
+	expected := hex.EncodeToString(sum[:]) + "  test_backup.tar.gz\n"
+	if string(content) != expected {
+		t.Errorf("Expected checksum line %q, got %q", expected, string(content))
+	}
+}
+
+func BenchmarkCreate(b *testing.B) {
+	tmpDir := b.TempDir()
+	logPath := filepath.Join(tmpDir, "bench.log")
+
+	log, _ := logger.New(logPath)
+	defer log.Close()
+
+	// 64MB of compressible content spread over several files
+	sourceDir := filepath.Join(tmpDir, "source")
+	os.MkdirAll(sourceDir, 0755)
+	payload := testPayload(16 << 20)
+	for i := 0; i < 4; i++ {
+		os.WriteFile(filepath.Join(sourceDir, fmt.Sprintf("file%d.bin", i)), payload, 0644)
+	}
+	sources := []Source{{Name: "data", Path: sourceDir}}
+	totalBytes := int64(4 * len(payload))
+
+	for _, codec := range []*Codec{gzipCodec, zstdCodec} {
+		for _, workers := range []int{1, 4} {
+			b.Run(fmt.Sprintf("%s/workers=%d", codec.Name, workers), func(b *testing.B) {
+				creator := New(log, Options{Codec: codec, Workers: workers})
+				backupFile := filepath.Join(tmpDir, "bench"+creator.Extension())
+
+				b.SetBytes(totalBytes)
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					if err := creator.Create(context.Background(), backupFile, sources); err != nil {
+						b.Fatalf("Create failed: %v", err)
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestCreateDeterministic(t *testing.T) {
+	tmpDir := t.TempDir()
+	logPath := filepath.Join(tmpDir, "test.log")
+
+	log, _ := logger.New(logPath)
+	defer log.Close()
+
+	sourceDir := filepath.Join(tmpDir, "source")
+	os.MkdirAll(filepath.Join(sourceDir, "b"), 0755)
+	os.WriteFile(filepath.Join(sourceDir, "c.txt"), []byte("c"), 0644)
+	os.WriteFile(filepath.Join(sourceDir, "a.txt"), []byte("a"), 0644)
+	os.WriteFile(filepath.Join(sourceDir, "b", "d.txt"), []byte("d"), 0644)
+
+	creator := New(log, Options{})
+	sources := []Source{{Name: "data", Path: sourceDir}}
+
+	first := filepath.Join(tmpDir, "first.tar.gz")
+	if err := creator.Create(context.Background(), first, sources); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+
+	// Touching files must not change the archive
+	later := time.Now().Add(time.Hour)
+	os.Chtimes(filepath.Join(sourceDir, "a.txt"), later, later)
+
+	second := filepath.Join(tmpDir, "second.tar.gz")
+	if err := creator.Create(context.Background(), second, sources); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+
+	firstContent, _ := os.ReadFile(first)
+	secondContent, _ := os.ReadFile(second)
+	if !bytes.Equal(firstContent, secondContent) {
+		t.Error("Identical content should produce identical archives")
+	}
+
+	// Neither the host nor the run times are recorded
+	metadata, err := ReadMetadata(first, nil, true)
+	if err != nil {
+		t.Fatalf("ReadMetadata failed: %v", err)
+	}
+	if metadata.Hostname != "" || !metadata.Started.IsZero() || metadata.Completion == nil || !metadata.Completion.Finished.IsZero() {
+		t.Errorf("Run specific metadata recorded: %+v, %+v", metadata, metadata.Completion)
+	}
+}
+
+func TestAddToTarPreserveMetadata(t *testing.T) {
+	tmpDir := t.TempDir()
+	logPath := filepath.Join(tmpDir, "test.log")
+
+	log, _ := logger.New(logPath)
+	defer log.Close()
+
+	sourceDir := filepath.Join(tmpDir, "source")
+	os.MkdirAll(sourceDir, 0755)
+	filePath := filepath.Join(sourceDir, "document.pdf")
+	os.WriteFile(filePath, []byte("pdf"), 0640)
+
+	modTime := time.Date(2021, 3, 4, 5, 6, 7, 890000000, time.UTC)
+	os.Chtimes(filePath, modTime, modTime)
+
+	hasXattr := unix.Lsetxattr(filePath, "user.paperless", []byte("tagged"), 0) == nil
+
+	var buf bytes.Buffer
+	tarWriter := newTarStream(&buf)
+
+	creator := New(log, Options{PreserveMetadata: true})
+	if err := creator.addToTar(context.Background(), tarWriter, Source{Name: "media", Path: sourceDir}); err != nil {
+		t.Fatalf("addToTar failed: %v", err)
+	}
+	tarWriter.Close()
+
+	tarReader := tar.NewReader(&buf)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			t.Fatal("document.pdf not found in archive")
+		}
+		if err != nil {
+			t.Fatalf("Error reading tar: %v", err)
+		}
+		if header.Name != "media/document.pdf" {
+			continue
+		}
+
+		if !header.ModTime.Equal(modTime) {
+			t.Errorf("Expected mtime %v, got %v", modTime, header.ModTime)
+		}
+		if header.Uid != os.Getuid() || header.Gid != os.Getgid() {
+			t.Errorf("Expected uid/gid %d/%d, got %d/%d", os.Getuid(), os.Getgid(), header.Uid, header.Gid)
+		}
+		if header.Mode&0777 != 0640 {
+			t.Errorf("Expected mode 0640, got %o", header.Mode&0777)
+		}
+		if hasXattr && header.PAXRecords[paxXattrPrefix+"user.paperless"] != "tagged" {
+			t.Errorf("Expected xattr record, got %v", header.PAXRecords)
+		}
+		break
+	}
+}
+
+// readTarEntries returns headers and contents of an uncompressed tar
+func readTarEntries(t *testing.T, r io.Reader) ([]*tar.Header, map[string][]byte) {
+	t.Helper()
+
+	var headers []*tar.Header
+	contents := make(map[string][]byte)
+	tarReader := tar.NewReader(r)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Error reading tar: %v", err)
+		}
+		content, err := io.ReadAll(tarReader)
+		if err != nil {
+			t.Fatalf("Error reading %s: %v", header.Name, err)
+		}
+		headers = append(headers, header)
+		contents[header.Name] = content
+	}
+	return headers, contents
+}
+
+func TestAddToTarHardLinks(t *testing.T) {
+	tmpDir := t.TempDir()
+	logPath := filepath.Join(tmpDir, "test.log")
+
+	log, _ := logger.New(logPath)
+	defer log.Close()
+
+	sourceDir := filepath.Join(tmpDir, "source")
+	os.MkdirAll(sourceDir, 0755)
+	content := bytes.Repeat([]byte("linked"), 10000)
+	os.WriteFile(filepath.Join(sourceDir, "a.pdf"), content, 0644)
+	if err := os.Link(filepath.Join(sourceDir, "a.pdf"), filepath.Join(sourceDir, "b.pdf")); err != nil {
+		t.Skipf("Hard links not supported: %v", err)
+	}
+	os.Symlink("a.pdf", filepath.Join(sourceDir, "c.pdf"))
+
+	var buf bytes.Buffer
+	tarWriter := newTarStream(&buf)
+
+	creator := New(log, Options{})
+	if err := creator.addToTar(context.Background(), tarWriter, Source{Name: "media", Path: sourceDir}); err != nil {
+		t.Fatalf("addToTar failed: %v", err)
+	}
+	tarWriter.Close()
+
+	if buf.Len() > 2*len(content) {
+		t.Errorf("Hard linked content should be stored once, archive is %d bytes", buf.Len())
+	}
+
+	headers, contents := readTarEntries(t, &buf)
+	found := make(map[string]*tar.Header)
+	for _, header := range headers {
+		found[header.Name] = header
+	}
+
+	if !bytes.Equal(contents["media/a.pdf"], content) {
+		t.Error("First name should carry the content")
+	}
+	link := found["media/b.pdf"]
+	if link == nil || link.Typeflag != tar.TypeLink || link.Linkname != "media/a.pdf" {
+		t.Errorf("Expected media/b.pdf as hard link to media/a.pdf, got %+v", link)
+	}
+	symlink := found["media/c.pdf"]
+	if symlink == nil || symlink.Typeflag != tar.TypeSymlink || symlink.Linkname != "a.pdf" {
+		t.Errorf("Expected media/c.pdf as symlink to a.pdf, got %+v", symlink)
+	}
+}
+
+func TestAddToTarSparseFiles(t *testing.T) {
+	tmpDir := t.TempDir()
+	logPath := filepath.Join(tmpDir, "test.log")
+
+	log, _ := logger.New(logPath)
+	defer log.Close()
+
+	sourceDir := filepath.Join(tmpDir, "source")
+	os.MkdirAll(sourceDir, 0755)
+
+	// 64MB file with two small data regions and a trailing hole
+	const size = 64 << 20
+	sparsePath := filepath.Join(sourceDir, "base.db")
+	file, _ := os.Create(sparsePath)
+	file.Truncate(size)
+	file.WriteAt(bytes.Repeat([]byte("A"), 5000), 1<<20)
+	file.WriteAt(bytes.Repeat([]byte("B"), 100), 40<<20)
+	file.Close()
+
+	var stat unix.Stat_t
+	unix.Stat(sparsePath, &stat)
+	if stat.Blocks*512 >= size {
+		t.Skip("Filesystem does not support sparse files")
+	}
+
+	expected := make([]byte, size)
+	copy(expected[1<<20:], bytes.Repeat([]byte("A"), 5000))
+	copy(expected[40<<20:], bytes.Repeat([]byte("B"), 100))
+
+	// Full archive including manifest
+	creator := New(log, Options{Codec: noneCodec, PreserveMetadata: true})
+	backupFile := filepath.Join(tmpDir, "backup.tar")
+	if err := creator.Create(context.Background(), backupFile, []Source{{Name: "redis", Path: sourceDir}}); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+
+	info, _ := os.Stat(backupFile)
+	if info.Size() > 1<<20 {
+		t.Errorf("Holes should not be stored, archive is %d bytes", info.Size())
+	}
+
+	archiveFile, _ := os.Open(backupFile)
+	defer archiveFile.Close()
+	headers, contents := readTarEntries(t, archiveFile)
+
+	var sparseHeader *tar.Header
+	for _, header := range headers {
+		if header.Name == "redis/base.db" {
+			sparseHeader = header
+		}
+	}
+	if sparseHeader == nil {
+		t.Fatal("Sparse file not found under its real name")
+	}
+	if sparseHeader.Size != size {
+		t.Errorf("Expected restored size %d, got %d", size, sparseHeader.Size)
+	}
+	if !bytes.Equal(contents["redis/base.db"], expected) {
+		t.Error("Sparse file content does not round-trip")
+	}
+
+	// Manifest hash covers the logical content including holes
+	result, err := creator.VerifyDeep(backupFile)
+	if err != nil {
+		t.Fatalf("VerifyDeep failed: %v", err)
+	}
+	if !result.OK() {
+		t.Errorf("Unexpected verification result: %s", result.Summary())
+	}
+}
@@ -0,0 +1,102 @@
+package archive
+
+import (
+	"context"
+	"io"
+	"os"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// rateLimiter is a token bucket capping the read rate of source files. Up
+// to one second of reads can burst.
+type rateLimiter struct {
+	rate   float64 // Bytes per second
+	tokens float64
+	last   time.Time
+	sleep  func(time.Duration)
+}
+
+// newRateLimiter returns a limiter for bytesPerSecond, or nil for unlimited
+func newRateLimiter(bytesPerSecond int64) *rateLimiter {
+	if bytesPerSecond <= 0 {
+		return nil
+	}
+	return &rateLimiter{
+		rate:   float64(bytesPerSecond),
+		tokens: float64(bytesPerSecond),
+		last:   time.Now(),
+		sleep:  time.Sleep,
+	}
+}
+
+// wait blocks until n bytes may be read
+func (l *rateLimiter) wait(n int) {
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.rate {
+		l.tokens = l.rate
+	}
+	l.last = now
+
+	l.tokens -= float64(n)
+	if l.tokens < 0 {
+		l.sleep(time.Duration(-l.tokens / l.rate * float64(time.Second)))
+	}
+}
+
+// limitedReader throttles reads through a rateLimiter
+type limitedReader struct {
+	reader  io.Reader
+	limiter *rateLimiter
+}
+
+func (r *limitedReader) Read(b []byte) (int, error) {
+	n, err := r.reader.Read(b)
+	if n > 0 {
+		r.limiter.wait(n)
+	}
+	return n, err
+}
+
+// contextReader fails reads once its context is cancelled, so copying a
+// large file stops promptly
+type contextReader struct {
+	ctx    context.Context
+	reader io.Reader
+}
+
+func (r *contextReader) Read(b []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.reader.Read(b)
+}
+
+// sourceReader wraps the content of a source file with cancellation, the
+// configured bandwidth cap and progress reporting
+func (c *Creator) sourceReader(ctx context.Context, r io.Reader) io.Reader {
+	r = &contextReader{ctx: ctx, reader: r}
+	if c.limiter != nil {
+		r = &limitedReader{reader: r, limiter: c.limiter}
+	}
+	return c.progress.reader(r)
+}
+
+// adviseSequential tells the kernel a source file is read once from start
+// to end, which enlarges readahead
+func (c *Creator) adviseSequential(file *os.File) {
+	if c.options.DropPageCache {
+		unix.Fadvise(int(file.Fd()), 0, 0, unix.FADV_SEQUENTIAL)
+	}
+}
+
+// dropPageCache evicts an archived source file from the page cache, so the
+// backup does not displace the cached data of other workloads. It is only
+// a hint; failures are ignored.
+func (c *Creator) dropPageCache(file *os.File) {
+	if c.options.DropPageCache {
+		unix.Fadvise(int(file.Fd()), 0, 0, unix.FADV_DONTNEED)
+	}
+}
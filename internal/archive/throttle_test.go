@@ -0,0 +1,77 @@
+package archive
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"paperless-backup/internal/logger"
+)
+
+func TestRateLimiter(t *testing.T) {
+	if newRateLimiter(0) != nil {
+		t.Error("A zero rate should be unlimited")
+	}
+
+	var slept time.Duration
+	l := newRateLimiter(1000)
+	l.sleep = func(d time.Duration) { slept += d }
+
+	// One second of reads may burst
+	l.wait(1000)
+	if slept != 0 {
+		t.Errorf("Burst should not sleep, slept %s", slept)
+	}
+
+	// Beyond the burst, reads are delayed by their size at the given rate
+	l.wait(500)
+	if slept < 490*time.Millisecond || slept > 510*time.Millisecond {
+		t.Errorf("Expected about 500ms of sleep, got %s", slept)
+	}
+}
+
+func TestLimitedReader(t *testing.T) {
+	var slept time.Duration
+	l := newRateLimiter(1 << 10)
+	l.sleep = func(d time.Duration) { slept += d }
+
+	data := make([]byte, 4<<10)
+	var out bytes.Buffer
+	out.ReadFrom(&limitedReader{reader: bytes.NewReader(data), limiter: l})
+	if out.Len() != len(data) {
+		t.Errorf("Read %d bytes, want %d", out.Len(), len(data))
+	}
+	if slept < 2900*time.Millisecond {
+		t.Errorf("4KB at 1KB/s with 1KB burst should sleep about 3s, slept %s", slept)
+	}
+}
+
+func TestCreateWithReadLimitAndPageCacheHints(t *testing.T) {
+	tmpDir := t.TempDir()
+	log, _ := logger.New(filepath.Join(tmpDir, "test.log"))
+	defer log.Close()
+
+	sourceDir := filepath.Join(tmpDir, "source")
+	os.MkdirAll(sourceDir, 0755)
+	content := bytes.Repeat([]byte("paperless"), 10000)
+	os.WriteFile(filepath.Join(sourceDir, "a.pdf"), content, 0644)
+
+	// The limit is far above the content size, so nothing waits
+	creator := New(log, Options{ReadLimit: 100 << 20, DropPageCache: true})
+	archivePath := filepath.Join(tmpDir, "backup.tar.gz")
+	if err := creator.Create(context.Background(), archivePath, []Source{{Name: "media", Path: sourceDir}}); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+
+	dest := filepath.Join(tmpDir, "restore")
+	if _, err := Extract(archivePath, dest, ExtractOptions{}); err != nil {
+		t.Fatalf("Extract failed: %v", err)
+	}
+	restored, _ := os.ReadFile(filepath.Join(dest, "media", "a.pdf"))
+	if !bytes.Equal(restored, content) {
+		t.Error("Throttled content differs")
+	}
+}
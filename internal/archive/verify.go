@@ -0,0 +1,184 @@
+package archive
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// VerifyResult describes the outcome of a deep content verification
+type VerifyResult struct {
+	Files      int      // Regular files read from the archive
+	Mismatched []string // Paths whose content hash differs from the manifest
+	Missing    []string // Paths listed in the manifest but absent from the archive
+	Extra      []string // Regular files in the archive without a manifest entry
+}
+
+// OK reports whether every file matched the manifest
+func (r *VerifyResult) OK() bool {
+	return len(r.Mismatched) == 0 && len(r.Missing) == 0 && len(r.Extra) == 0
+}
+
+// Summary returns a one-line description of the verification outcome
+func (r *VerifyResult) Summary() string {
+	return fmt.Sprintf("%d files, %d mismatched, %d missing, %d extra",
+		r.Files, len(r.Mismatched), len(r.Missing), len(r.Extra))
+}
+
+// VerifyDeep reads every file body, recomputes its SHA-256 and compares it
+// against the embedded manifest. Archive level failures (unreadable stream,
+// missing manifest) are returned as error, content problems in the result.
+func (c *Creator) VerifyDeep(archivePath string) (*VerifyResult, error) {
+	result, err := c.verifyDeep(context.Background(), archivePath)
+	if err != nil {
+		return nil, c.parityHint(archivePath, err)
+	}
+	return result, nil
+}
+
+// verifyDeep re-hashes every file body of an archive until ctx is cancelled
+func (c *Creator) verifyDeep(ctx context.Context, archivePath string) (*VerifyResult, error) {
+	tarReader, err := openArchive(archivePath, c.options.Encryptor)
+	if err != nil {
+		return nil, err
+	}
+	defer tarReader.Close()
+	return c.verifyDeepReader(ctx, tarReader)
+}
+
+// verifyDeepReader re-hashes every file body of an opened archive
+func (c *Creator) verifyDeepReader(ctx context.Context, tarReader *archiveReader) (*VerifyResult, error) {
+	c.logger.Log("INFO", "Verifying backup content against manifest...")
+
+	result := &VerifyResult{}
+	actual := make(map[string]string)
+	var manifest *Manifest
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("backup integrity check failed (tar): %w", err)
+		}
+
+		if header.Name == ManifestName {
+			content, err := io.ReadAll(tarReader)
+			if err != nil {
+				return nil, fmt.Errorf("backup integrity check failed (manifest): %w", err)
+			}
+			if manifest, err = ParseManifest(bytes.NewReader(content)); err != nil {
+				return nil, fmt.Errorf("backup integrity check failed: %w", err)
+			}
+			continue
+		}
+
+		// Incremental records and warnings are not covered by the manifest
+		if header.Typeflag != tar.TypeReg || strings.HasPrefix(header.Name, MetaDir+"/") {
+			continue
+		}
+
+		hash := sha256.New()
+		if _, err := io.Copy(hash, &contextReader{ctx: ctx, reader: tarReader}); err != nil {
+			return nil, fmt.Errorf("backup integrity check failed (%s): %w", header.Name, err)
+		}
+		actual[header.Name] = hex.EncodeToString(hash.Sum(nil))
+		result.Files++
+	}
+
+	if err := tarReader.finish(); err != nil {
+		return nil, err
+	}
+
+	if manifest == nil {
+		return nil, fmt.Errorf("backup integrity check failed: archive has no manifest")
+	}
+
+	expected := manifest.Lookup()
+	for name, sum := range expected {
+		got, ok := actual[name]
+		switch {
+		case !ok:
+			result.Missing = append(result.Missing, name)
+		case got != sum:
+			result.Mismatched = append(result.Mismatched, name)
+		}
+	}
+	for name := range actual {
+		if _, ok := expected[name]; !ok {
+			result.Extra = append(result.Extra, name)
+		}
+	}
+
+	sort.Strings(result.Mismatched)
+	sort.Strings(result.Missing)
+	sort.Strings(result.Extra)
+
+	for _, name := range result.Mismatched {
+		c.logger.Logf("ERROR", "Content mismatch: %s", name)
+	}
+	for _, name := range result.Missing {
+		c.logger.Logf("ERROR", "Missing from archive: %s", name)
+	}
+	for _, name := range result.Extra {
+		c.logger.Logf("ERROR", "Not in manifest: %s", name)
+	}
+
+	if result.OK() {
+		c.logger.Logf("INFO", "Backup content verification passed (%d files)", result.Files)
+	} else {
+		c.logger.Logf("ERROR", "Backup content verification failed (%s)", result.Summary())
+	}
+
+	return result, nil
+}
+
+// streamVerifier verifies an archive stream while it is written, for
+// archives that cannot be read back after encryption
+type streamVerifier struct {
+	writer *io.PipeWriter
+	done   chan error
+	err    error
+}
+
+// newStreamVerifier starts the verification configured for new archives on
+// everything written to the verifier
+func (c *Creator) newStreamVerifier(ctx context.Context) *streamVerifier {
+	reader, writer := io.Pipe()
+	v := &streamVerifier{writer: writer, done: make(chan error, 1)}
+	go func() {
+		tarReader, err := newArchiveReader(io.NopCloser(reader), nil)
+		if err == nil {
+			err = c.checkCreated(ctx, tarReader)
+			tarReader.Close()
+		}
+		// Fails further writes once the check has stopped
+		reader.CloseWithError(err)
+		v.done <- err
+	}()
+	return v
+}
+
+func (v *streamVerifier) Write(p []byte) (int, error) {
+	return v.writer.Write(p)
+}
+
+// Close ends the stream and returns the outcome of the verification
+func (v *streamVerifier) Close() error {
+	if v.done != nil {
+		v.writer.Close()
+		v.err = <-v.done
+		v.done = nil
+	}
+	return v.err
+}
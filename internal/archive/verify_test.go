@@ -0,0 +1,128 @@
+package archive
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"paperless-backup/internal/logger"
+)
+
+// writeTestArchive writes a tar.gz with the given files and manifest
+func writeTestArchive(t *testing.T, path string, files map[string]string, manifest *Manifest) {
+	t.Helper()
+
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("Failed to create archive: %v", err)
+	}
+	defer file.Close()
+	gzWriter := gzip.NewWriter(file)
+	tarWriter := tar.NewWriter(gzWriter)
+
+	for name, content := range files {
+		tarWriter.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(content))})
+		tarWriter.Write([]byte(content))
+	}
+
+	if manifest != nil {
+		content := manifest.Bytes()
+		tarWriter.WriteHeader(&tar.Header{Name: ManifestName, Mode: 0644, Size: int64(len(content))})
+		tarWriter.Write(content)
+	}
+
+	tarWriter.Close()
+	gzWriter.Close()
+}
+
+func TestVerifyDeep(t *testing.T) {
+	tmpDir := t.TempDir()
+	logPath := filepath.Join(tmpDir, "test.log")
+
+	log, _ := logger.New(logPath)
+	defer log.Close()
+
+	sourceDir := filepath.Join(tmpDir, "source")
+	os.MkdirAll(sourceDir, 0755)
+	os.WriteFile(filepath.Join(sourceDir, "file1.txt"), []byte("content1"), 0644)
+	os.WriteFile(filepath.Join(sourceDir, "file2.txt"), []byte("content2"), 0644)
+
+	creator := New(log, Options{})
+	backupFile := filepath.Join(tmpDir, "test_backup.tar.gz")
+	if err := creator.Create(context.Background(), backupFile, []Source{{Name: "data", Path: sourceDir}}); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+
+	result, err := creator.VerifyDeep(backupFile)
+	if err != nil {
+		t.Fatalf("VerifyDeep failed: %v", err)
+	}
+	if !result.OK() {
+		t.Errorf("Expected clean verification, got %s", result.Summary())
+	}
+	if result.Files != 2 {
+		t.Errorf("Expected 2 files, got %d", result.Files)
+	}
+}
+
+func TestVerifyDeepDetectsProblems(t *testing.T) {
+	tmpDir := t.TempDir()
+	logPath := filepath.Join(tmpDir, "test.log")
+
+	log, _ := logger.New(logPath)
+	defer log.Close()
+
+	manifest := &Manifest{}
+	good := sha256.Sum256([]byte("good"))
+	stale := sha256.Sum256([]byte("original"))
+	gone := sha256.Sum256([]byte("gone"))
+	manifest.Add("data/good.txt", good[:])
+	manifest.Add("data/changed.txt", stale[:])
+	manifest.Add("data/missing.txt", gone[:])
+
+	backupFile := filepath.Join(tmpDir, "tampered.tar.gz")
+	writeTestArchive(t, backupFile, map[string]string{
+		"data/good.txt":    "good",
+		"data/changed.txt": "modified",
+		"data/extra.txt":   "extra",
+	}, manifest)
+
+	creator := New(log, Options{})
+	result, err := creator.VerifyDeep(backupFile)
+	if err != nil {
+		t.Fatalf("VerifyDeep failed: %v", err)
+	}
+
+	if result.OK() {
+		t.Fatal("Verification should report problems")
+	}
+	if len(result.Mismatched) != 1 || result.Mismatched[0] != "data/changed.txt" {
+		t.Errorf("Expected data/changed.txt mismatched, got %v", result.Mismatched)
+	}
+	if len(result.Missing) != 1 || result.Missing[0] != "data/missing.txt" {
+		t.Errorf("Expected data/missing.txt missing, got %v", result.Missing)
+	}
+	if len(result.Extra) != 1 || result.Extra[0] != "data/extra.txt" {
+		t.Errorf("Expected data/extra.txt extra, got %v", result.Extra)
+	}
+}
+
+func TestVerifyDeepWithoutManifest(t *testing.T) {
+	tmpDir := t.TempDir()
+	logPath := filepath.Join(tmpDir, "test.log")
+
+	log, _ := logger.New(logPath)
+	defer log.Close()
+
+	backupFile := filepath.Join(tmpDir, "legacy.tar.gz")
+	writeTestArchive(t, backupFile, map[string]string{"data/file.txt": "content"}, nil)
+
+	creator := New(log, Options{})
+	if _, err := creator.VerifyDeep(backupFile); err == nil {
+		t.Error("VerifyDeep should fail for an archive without manifest")
+	}
+}
@@ -0,0 +1,58 @@
+package archive
+
+import (
+	"bytes"
+	"errors"
+
+	"golang.org/x/sys/unix"
+)
+
+// paxXattrPrefix is the PAX record namespace for extended attributes, as
+// used by GNU tar and bsdtar. POSIX ACLs are stored through their
+// system.posix_acl_* attributes.
+const paxXattrPrefix = "SCHILY.xattr."
+
+// readXattrs returns the extended attributes of a path without following
+// symlinks. Filesystems without xattr support yield no attributes.
+func readXattrs(path string) (map[string]string, error) {
+	size, err := unix.Llistxattr(path, nil)
+	if err != nil {
+		if errors.Is(err, unix.ENOTSUP) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if size == 0 {
+		return nil, nil
+	}
+
+	names := make([]byte, size)
+	size, err = unix.Llistxattr(path, names)
+	if err != nil {
+		return nil, err
+	}
+
+	xattrs := make(map[string]string)
+	for _, name := range bytes.Split(names[:size], []byte{0}) {
+		if len(name) == 0 {
+			continue
+		}
+
+		valueSize, err := unix.Lgetxattr(path, string(name), nil)
+		if err != nil {
+			if errors.Is(err, unix.ENODATA) {
+				continue // Removed since listing
+			}
+			return nil, err
+		}
+		value := make([]byte, valueSize)
+		if valueSize > 0 {
+			if valueSize, err = unix.Lgetxattr(path, string(name), value); err != nil {
+				return nil, err
+			}
+		}
+		xattrs[string(name)] = string(value[:valueSize])
+	}
+
+	return xattrs, nil
+}
@@ -1,17 +1,19 @@
 package backup
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"os"
-	"os/exec"
 	"path/filepath"
-	"strings"
 	"time"
 
 	"paperless-backup/internal/archive"
 	"paperless-backup/internal/checks"
 	"paperless-backup/internal/config"
+	"paperless-backup/internal/lock"
 	"paperless-backup/internal/logger"
+	"paperless-backup/internal/repository"
 	"paperless-backup/internal/service"
 )
 
@@ -22,9 +24,12 @@ type Backup struct {
 	checker        *checks.Checker
 	serviceManager *service.Manager
 	archiver       *archive.Creator
+	repository     *repository.Repository // Set for the repository backend
 	lockPath       string
+	lock           *lock.Lock // Held by this run once checkLock succeeded
 	logPath        string
 	backupFile     string
+	warnings       []archive.Warning // Files tolerated by the read error policy
 }
 
 // New creates a new Backup instance with the given configuration
@@ -58,102 +63,230 @@ func (b *Backup) Setup() error {
 	b.serviceManager = service.New(b.logger, b.config.PaperlessService)
 
 	// Initialize archiver
-	b.archiver = archive.New(b.logger)
+	codec, err := archive.CodecByName(b.config.Compression)
+	if err != nil {
+		return err
+	}
+	encryptor, err := archive.NewEncryptor(
+		b.config.EncryptionRecipients,
+		b.config.EncryptionPassphraseFile,
+		b.config.EncryptionIdentityFile,
+	)
+	if err != nil {
+		return err
+	}
+	// Reject bad source definitions before anything is stopped
+	if err := validateSources(b.config.Sources); err != nil {
+		return err
+	}
+
+	if err := archive.ValidateParityPercent(b.config.ParityPercent); err != nil {
+		return err
+	}
+	if err := b.validatePriority(); err != nil {
+		return err
+	}
+
+	switch b.config.Backend {
+	case "", "archive":
+	case "repository":
+		// Chunks are addressed by the hash of their plaintext
+		if encryptor != nil {
+			return fmt.Errorf("encryption is not supported by the repository backend")
+		}
+		if b.config.ParityPercent > 0 {
+			return fmt.Errorf("parity data is not supported by the repository backend")
+		}
+		b.repository, err = repository.Open(filepath.Join(b.config.BackupDir, b.config.RepositoryDir), repository.Options{
+			CompressionLevel: b.config.CompressionLevel,
+			Verify:           b.config.DeepVerify,
+		})
+		if err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unknown backend %q", b.config.Backend)
+	}
+
+	b.archiver = archive.New(b.logger, archive.Options{
+		Codec:            codec,
+		CompressionLevel: b.config.CompressionLevel,
+		Workers:          b.config.CompressionWorkers,
+		DeepVerify:       b.config.DeepVerify,
+		Encryptor:        encryptor,
+		PreserveMetadata: b.config.PreserveMetadata,
+		PartSize:         b.config.MaxPartSizeMB * 1024 * 1024,
+		ProgressInterval: time.Duration(b.config.ProgressInterval) * time.Second,
+		ReadLimit:        b.config.ReadLimitMB * 1024 * 1024,
+		DropPageCache:    b.config.DropPageCache,
+		ParityPercent:    b.config.ParityPercent,
+	})
 
 	return nil
 }
 
-// Cleanup removes lock file and restores service state
-func (b *Backup) Cleanup() {
+// Cleanup restores service state and removes the lock file. It fails when
+// paperless could not be restarted.
+func (b *Backup) Cleanup() error {
+	var err error
 	if b.serviceManager != nil {
-		b.serviceManager.Restore()
+		if err = b.serviceManager.Restore(); err != nil {
+			b.logger.Log("ERROR", err.Error())
+			err = stepError("restore", ExitService, err)
+		}
 	}
 
-	os.Remove(b.lockPath)
+	// A lock held by another run is left alone
+	if releaseErr := b.lock.Release(); releaseErr != nil && b.logger != nil {
+		b.logger.Logf("WARN", "%v", releaseErr)
+	}
+	b.lock = nil
 
 	if b.logger != nil {
 		b.logger.Close()
 	}
+	return err
 }
 
-// checkLock checks for existing lock file and creates one
-func (b *Backup) checkLock() {
-	if _, err := os.Stat(b.lockPath); err == nil {
-		b.logger.ErrorExit(fmt.Sprintf("Backup already running (lock file exists: %s)", b.lockPath))
+// checkLock takes the lock, waiting for a running backup up to the
+// configured time. The lock of a run that crashed is taken over.
+func (b *Backup) checkLock(ctx context.Context) error {
+	wait := time.Duration(b.config.LockWait) * time.Second
+	l, err := lock.Acquire(ctx, b.lockPath, wait)
+	if errors.Is(err, lock.ErrHeld) {
+		return fmt.Errorf("%w: %w", ErrLocked, err)
+	}
+	if err != nil {
+		return err
 	}
 
-	// Create lock file
-	if err := os.WriteFile(b.lockPath, []byte{}, 0644); err != nil {
-		b.logger.ErrorExit("Failed to create lock file")
+	if l.Stale != nil && l.Stale.SameBoot() {
+		b.logger.Logf("WARN", "Recovered the lock of a backup that did not finish (%s)", l.Stale)
+	} else if l.Stale != nil {
+		b.logger.Logf("WARN", "Recovered the lock of a backup interrupted by a reboot (%s)", l.Stale)
 	}
+	b.lock = l
+	return nil
 }
 
-// getVolumePath inspects docker volume and returns mount point
-func (b *Backup) getVolumePath(volume string) string {
-	cmd := exec.Command("docker", "volume", "inspect", volume, "--format", "{{ .Mountpoint }}")
-	output, err := cmd.Output()
-	if err != nil {
-		b.logger.ErrorExit(fmt.Sprintf("Failed to inspect %s volume", volume))
+// createBackup creates a timestamped backup archive, incremental when a
+// usable base exists
+func (b *Backup) createBackup(ctx context.Context, sources []archive.Source) error {
+	if b.repository != nil {
+		return b.createSnapshot(ctx, sources)
 	}
+	level := b.planIncremental(sources)
 
-	path := strings.TrimSpace(string(output))
+	b.describeRun()
 
-	// Validate path exists
-	if _, err := os.Stat(path); os.IsNotExist(err) {
-		b.logger.ErrorExit(fmt.Sprintf("Volume path does not exist: %s", path))
+	timestamp := time.Now().Format(timestampFormat)
+	b.backupFile = filepath.Join(b.config.BackupDir, timestamp+levelSuffix(level)+b.archiver.Extension())
+	if err := b.archiver.Create(ctx, b.backupFile, sources); err != nil {
+		return err
 	}
+	b.warnings = b.archiver.Warnings()
 
-	return path
-}
-
-// createBackup creates a timestamped backup archive
-func (b *Backup) createBackup(dataPath, mediaPath, redisPath string) error {
-	timestamp := time.Now().Format("20060102_150405")
-	b.backupFile = filepath.Join(b.config.BackupDir, fmt.Sprintf("%s.tar.gz", timestamp))
-
-	sourcePaths := []string{dataPath, mediaPath, redisPath}
-	return b.archiver.Create(b.backupFile, sourcePaths)
+	// The snapshots are the base of the next incremental
+	if b.config.FullBackupDays > 0 {
+		if err := b.saveSnapshots(level, b.archiver.Snapshots()); err != nil {
+			b.logger.Logf("WARN", "Failed to save snapshots, the next backup will be a full one: %v", err)
+		}
+	}
+	return nil
 }
 
-// Run executes the complete backup process
-func (b *Backup) Run() {
+// Run executes the complete backup process. Cancelling ctx stops it at the
+// next step, or while archiving, without publishing a partial archive.
+func (b *Backup) Run(ctx context.Context) error {
 	b.logger.Log("INFO", "Starting paperless-ngx backup")
 
 	// Pre-flight checks (root check is done in main before we get here)
-	b.checkLock()
-	b.checker.RequiredTools()
-	b.checker.Docker()
+	if err := runStep(ctx, "lock", ExitLocked, func() error { return b.checkLock(ctx) }); err != nil {
+		return err
+	}
+	b.removeOrphanedPartials()
+	if err := runStep(ctx, "preflight", ExitPreflight, b.checker.RequiredTools); err != nil {
+		return err
+	}
+	if err := runStep(ctx, "preflight", ExitPreflight, b.checker.Docker); err != nil {
+		return err
+	}
 
 	// Stop service if running
-	b.serviceManager.Stop()
+	if err := runStep(ctx, "service", ExitService, b.serviceManager.Stop); err != nil {
+		return err
+	}
 
-	// Get volume paths
-	b.logger.Log("INFO", "Inspecting docker volumes...")
-	dataPath := b.getVolumePath(b.config.DataVolume)
-	mediaPath := b.getVolumePath(b.config.MediaVolume)
-	redisPath := b.getVolumePath(b.config.RedisVolume)
+	// Locate the sources on the host
+	b.logger.Log("INFO", "Locating backup sources...")
+	var sources []archive.Source
+	var skipped []error
+	err := runStep(ctx, "sources", ExitSource, func() (err error) {
+		sources, skipped, err = resolveSources(b.config.Sources)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	for _, err := range skipped {
+		b.logger.Logf("WARN", "Skipping optional source: %v", err)
+	}
 
-	b.logger.Log("INFO", "Volume locations:")
-	b.logger.Logf("INFO", "  - Data: %s", dataPath)
-	b.logger.Logf("INFO", "  - Media: %s", mediaPath)
-	b.logger.Logf("INFO", "  - Redis: %s", redisPath)
+	b.logger.Log("INFO", "Source locations:")
+	for _, source := range sources {
+		b.logger.Logf("INFO", "  - %s: %s", source.Name, source.Path)
+	}
 
 	// Check available disk space
-	b.checker.DiskSpace()
-
-	// Create compressed backup archive
-	if err := b.createBackup(dataPath, mediaPath, redisPath); err != nil {
-		b.logger.ErrorExit(err.Error())
+	if err := runStep(ctx, "preflight", ExitPreflight, b.checker.DiskSpace); err != nil {
+		return err
 	}
 
-	// Verify backup integrity
-	if err := b.archiver.Verify(b.backupFile); err != nil {
-		b.logger.ErrorExit(err.Error())
+	// Keep the host responsive while reading the volumes
+	b.applyPriority()
+
+	// Create, verify and publish the compressed backup archive
+	if err := runStep(ctx, "backup", ExitFailure, func() error { return b.createBackup(ctx, sources) }); err != nil {
+		return err
 	}
 
 	// Remove old backups per retention policy
-	b.cleanupOldBackups()
+	if b.repository != nil {
+		b.pruneRepository()
+	} else {
+		b.cleanupOldBackups()
+	}
 
+	b.reportWarnings()
 	b.logger.Log("INFO", "Backup completed successfully")
+	return nil
 }
 
+// Execute runs a backup and returns its exit code. Whichever step fails,
+// and when ctx is cancelled or Run panics, the service is restored and the
+// lock released before it returns.
+func (b *Backup) Execute(ctx context.Context) (code int) {
+	defer func() {
+		if err := b.Cleanup(); err != nil && code == ExitOK {
+			code = ExitCode(err)
+		}
+	}()
+
+	if err := b.Run(ctx); err != nil {
+		b.logger.Log("ERROR", err.Error())
+		return ExitCode(err)
+	}
+	return ExitOK
+}
+
+// reportWarnings lists the files the read error policy tolerated, so they
+// can be checked in the backup
+func (b *Backup) reportWarnings() {
+	if len(b.warnings) == 0 {
+		return
+	}
+	b.logger.Logf("WARN", "Warnings (%d files not archived as found):", len(b.warnings))
+	for _, warning := range b.warnings {
+		b.logger.Logf("WARN", "  - %s: %s", warning.Path, warning.Problem)
+	}
+}
@@ -1,11 +1,18 @@
 package backup
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 
+	"paperless-backup/internal/archive"
+	"paperless-backup/internal/checks"
 	"paperless-backup/internal/config"
+	"paperless-backup/internal/lock"
 	"paperless-backup/internal/logger"
 )
 
@@ -43,36 +50,125 @@ func TestCheckLock(t *testing.T) {
 	}
 
 	// First call should succeed (no lock exists)
-	backup.checkLock()
+	if err := backup.checkLock(context.Background()); err != nil {
+		t.Fatalf("checkLock failed: %v", err)
+	}
 
 	// Verify lock was created
 	if _, err := os.Stat(lockPath); os.IsNotExist(err) {
 		t.Error("Lock file should be created")
 	}
 
+	// A second run finds the lock held
+	other := &Backup{config: cfg, lockPath: lockPath, logger: log}
+	if err := other.checkLock(context.Background()); !errors.Is(err, ErrLocked) {
+		t.Errorf("Expected ErrLocked, got %v", err)
+	}
+
 	// Cleanup for next test
-	os.Remove(lockPath)
+	backup.lock.Release()
+
+	// The lock of a run lost in a reboot is taken over
+	os.WriteFile(lockPath, []byte(`{"pid":4242,"boot_id":"previous-boot","started":"2024-01-01T03:00:00Z"}`), 0644)
+	if err := other.checkLock(context.Background()); err != nil {
+		t.Fatalf("checkLock should recover a stale lock: %v", err)
+	}
+	other.lock.Release()
+	content, _ := os.ReadFile(logPath)
+	if !strings.Contains(string(content), "Recovered the lock of a backup interrupted by a reboot (PID 4242") {
+		t.Errorf("Log should report the recovered lock:\n%s", content)
+	}
 }
 
-func TestGetVolumePath(t *testing.T) {
-	// Note: This test requires Docker to be available
-	t.Skip("Skipping GetVolumePath test - requires Docker")
-
-	// This is how you would test it with Docker available:
-	// tmpDir := t.TempDir()
-	// logPath := filepath.Join(tmpDir, "test.log")
-	// logger, _ := NewLogger(logPath)
-	// defer logger.Close()
-	// backup := &Backup{workDir: tmpDir, logger: logger}
-	// path := backup.GetVolumePath("some_volume")
-	// if path == "" {
-	//     t.Error("Expected non-empty path")
-	// }
+func TestExecuteWhileLocked(t *testing.T) {
+	tmpDir := t.TempDir()
+	lockPath := filepath.Join(tmpDir, "test.lock")
+	logPath := filepath.Join(tmpDir, "test.log")
+	log, _ := logger.New(logPath)
+
+	held, err := lock.Acquire(context.Background(), lockPath, 0)
+	if err != nil {
+		t.Fatalf("Acquire failed: %v", err)
+	}
+	defer held.Release()
+	backup := &Backup{config: config.Default(), lockPath: lockPath, logger: log}
+
+	if code := backup.Execute(context.Background()); code != ExitLocked {
+		t.Errorf("Expected exit code %d, got %d", ExitLocked, code)
+	}
+	// The lock belongs to the other run
+	if _, err := os.Stat(lockPath); err != nil {
+		t.Error("Lock file of another run should be kept")
+	}
+	content, _ := os.ReadFile(logPath)
+	if !strings.Contains(string(content), "[ERROR] lock: backup already running") {
+		t.Errorf("Log should report the held lock:\n%s", content)
+	}
+}
+
+func TestExecuteInterrupted(t *testing.T) {
+	tmpDir := t.TempDir()
+	lockPath := filepath.Join(tmpDir, "test.lock")
+	logPath := filepath.Join(tmpDir, "test.log")
+	log, _ := logger.New(logPath)
+
+	backup := &Backup{
+		config:   config.Default(),
+		lockPath: lockPath,
+		logger:   log,
+		checker:  checks.New(log, tmpDir, 0),
+	}
+	ctx, cancel := context.WithCancelCause(context.Background())
+	cancel(fmt.Errorf("%w (signal terminated)", ErrInterrupted))
+
+	if code := backup.Execute(ctx); code != ExitInterrupted {
+		t.Errorf("Expected exit code %d, got %d", ExitInterrupted, code)
+	}
+	if _, err := os.Stat(lockPath); !os.IsNotExist(err) {
+		t.Error("Lock file should be released after an interrupted run")
+	}
+	content, _ := os.ReadFile(logPath)
+	if !strings.Contains(string(content), "backup interrupted (signal terminated)") {
+		t.Errorf("Log should report the interruption:\n%s", content)
+	}
+}
+
+func TestExitCode(t *testing.T) {
+	tests := []struct {
+		err  error
+		want int
+	}{
+		{nil, ExitOK},
+		{errors.New("archive failed"), ExitFailure},
+		{stepError("lock", ExitLocked, ErrLocked), ExitLocked},
+		{fmt.Errorf("run: %w", stepError("sources", ExitSource, errors.New("no such volume"))), ExitSource},
+	}
+	for _, tt := range tests {
+		if got := ExitCode(tt.err); got != tt.want {
+			t.Errorf("ExitCode(%v) = %d, want %d", tt.err, got, tt.want)
+		}
+	}
+	ctx, cancel := context.WithCancelCause(context.Background())
+	cancel(ErrInterrupted)
+	if err := checkStep(ctx, "backup", ExitFailure, fmt.Errorf("failed to add data: %w", context.Canceled)); ExitCode(err) != ExitInterrupted || !errors.Is(err, ErrInterrupted) {
+		t.Errorf("Cancelled step should be interrupted, got %v", err)
+	}
+
+	// A step that finished or failed on its own keeps its result
+	if err := checkStep(ctx, "backup", ExitFailure, nil); err != nil {
+		t.Errorf("Completed step should not be reported as interrupted, got %v", err)
+	}
+	if err := checkStep(ctx, "service", ExitService, errors.New("restart failed")); ExitCode(err) != ExitService {
+		t.Errorf("Failed step should keep its code, got %v", err)
+	}
+	if stepError("backup", ExitFailure, nil) != nil {
+		t.Error("stepError should pass nil through")
+	}
 }
 
 func TestBackupSetup(t *testing.T) {
 	tmpDir := t.TempDir()
-	
+
 	cfg := &config.Config{
 		BackupDir:        tmpDir,
 		LogFile:          "test.log",
@@ -80,11 +176,9 @@ func TestBackupSetup(t *testing.T) {
 		MaxBackupAgeDays: 30,
 		RequiredSpaceMB:  1000,
 		PaperlessService: "paperless-ngx.service",
-		DataVolume:       "paperless-ngx_data",
-		MediaVolume:      "paperless-ngx_media",
-		RedisVolume:      "paperless-ngx_redisdata",
+		Sources:          config.Default().Sources,
 	}
-	
+
 	backup, _ := New(cfg)
 
 	err := backup.Setup()
@@ -104,11 +198,108 @@ func TestBackupSetup(t *testing.T) {
 	}
 }
 
+func TestBackupSetupRejectsUnknownCompression(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	cfg := config.Default()
+	cfg.BackupDir = tmpDir
+	cfg.Compression = "lz4"
+
+	backup, _ := New(cfg)
+	if err := backup.Setup(); err == nil {
+		t.Error("Setup should fail for unknown compression")
+	}
+	if backup.logger != nil {
+		backup.logger.Close()
+	}
+}
+
+func TestBackupSetupRejectsInvalidSources(t *testing.T) {
+	tests := map[string][]config.Source{
+		"filter":       {{Name: "media", Volume: "media", Filter: config.Filter{Exclude: []string{"documents/[thumbnails"}}}},
+		"absolute":     {{Name: "data", Volume: "data", Filter: config.Filter{Include: []string{"/absolute"}}}},
+		"read errors":  {{Name: "media", Volume: "media", ReadErrors: "lenient"}},
+		"no location":  {{Name: "photos"}},
+		"two":          {{Name: "pgdata", Volume: "pgdata", Path: "/srv/pgdata"}},
+		"relative":     {{Name: "consume", Path: "srv/consume"}},
+		"container":    {{Name: "export", Container: "paperless", ContainerPath: "export"}},
+		"duplicate":    {{Name: "media", Volume: "media"}, {Name: "media", Path: "/srv/media"}},
+		"name":         {{Name: "media/documents", Volume: "media"}},
+		"metadata dir": {{Name: archive.MetaDir, Path: "/srv"}},
+		"none":         {},
+	}
+
+	for name, sources := range tests {
+		cfg := config.Default()
+		cfg.BackupDir = t.TempDir()
+		cfg.Sources = sources
+
+		backup, _ := New(cfg)
+		if err := backup.Setup(); err == nil {
+			t.Errorf("Setup should reject sources (%s): %+v", name, sources)
+		}
+		if backup.logger != nil {
+			backup.logger.Close()
+		}
+	}
+}
+
+func TestBackupSetupRejectsInvalidParity(t *testing.T) {
+	tests := []struct {
+		backend string
+		percent int
+	}{
+		{"archive", -1},
+		{"archive", 101},
+		{"repository", 10},
+	}
+
+	for _, tt := range tests {
+		cfg := config.Default()
+		cfg.BackupDir = t.TempDir()
+		cfg.Backend = tt.backend
+		cfg.ParityPercent = tt.percent
+
+		backup, _ := New(cfg)
+		if err := backup.Setup(); err == nil {
+			t.Errorf("Setup should reject %d%% parity with the %s backend", tt.percent, tt.backend)
+		}
+		if backup.logger != nil {
+			backup.logger.Close()
+		}
+	}
+}
+
+func TestReportWarnings(t *testing.T) {
+	tmpDir := t.TempDir()
+	logPath := filepath.Join(tmpDir, "test.log")
+	log, _ := logger.New(logPath)
+	defer log.Close()
+
+	backup := &Backup{config: config.Default(), logger: log}
+	backup.warnings = []archive.Warning{
+		{Path: "data/log/paperless.log", Problem: archive.ProblemChanged},
+		{Path: "media/tmp/upload.pdf", Problem: archive.ProblemVanished},
+	}
+	backup.reportWarnings()
+
+	content, _ := os.ReadFile(logPath)
+	for _, want := range []string{
+		"Warnings (2 files not archived as found)",
+		"data/log/paperless.log: " + archive.ProblemChanged,
+		"media/tmp/upload.pdf: " + archive.ProblemVanished,
+	} {
+		if !strings.Contains(string(content), want) {
+			t.Errorf("Log should contain %q:\n%s", want, content)
+		}
+	}
+}
+
 func TestCleanup(t *testing.T) {
 	tmpDir := t.TempDir()
 	lockPath := filepath.Join(tmpDir, "test.lock")
 	logPath := filepath.Join(tmpDir, "test.log")
-	
+
 	log, _ := logger.New(logPath)
 
 	cfg := config.Default()
@@ -119,10 +310,14 @@ func TestCleanup(t *testing.T) {
 	}
 
 	// Create lock file
-	os.WriteFile(lockPath, []byte{}, 0644)
+	if err := backup.checkLock(context.Background()); err != nil {
+		t.Fatalf("checkLock failed: %v", err)
+	}
 
 	// Run cleanup
-	backup.Cleanup()
+	if err := backup.Cleanup(); err != nil {
+		t.Errorf("Cleanup failed: %v", err)
+	}
 
 	// Verify lock file was removed
 	if _, err := os.Stat(lockPath); !os.IsNotExist(err) {
@@ -136,3 +331,41 @@ func TestCleanupWithServiceRestore(t *testing.T) {
 	t.Skip("Skipping service restore test - requires systemd")
 }
 
+func TestBackupSetupRepositoryBackend(t *testing.T) {
+	cfg := config.Default()
+	cfg.BackupDir = t.TempDir()
+	cfg.Backend = "repository"
+
+	backup, _ := New(cfg)
+	if err := backup.Setup(); err != nil {
+		t.Fatalf("Setup failed: %v", err)
+	}
+	defer backup.logger.Close()
+	if backup.repository == nil {
+		t.Fatal("Repository should be opened")
+	}
+	if _, err := os.Stat(filepath.Join(cfg.BackupDir, "repository", "config.json")); err != nil {
+		t.Errorf("Repository should be created: %v", err)
+	}
+
+	// Unknown backends and encryption with the repository are rejected
+	unknown := config.Default()
+	unknown.BackupDir = t.TempDir()
+	unknown.Backend = "borg"
+
+	encrypted := config.Default()
+	encrypted.BackupDir = t.TempDir()
+	encrypted.Backend = "repository"
+	encrypted.EncryptionPassphraseFile = filepath.Join(encrypted.BackupDir, "passphrase")
+	os.WriteFile(encrypted.EncryptionPassphraseFile, []byte("secret passphrase"), 0600)
+
+	for _, cfg := range []*config.Config{unknown, encrypted} {
+		backup, _ := New(cfg)
+		if err := backup.Setup(); err == nil {
+			t.Errorf("Setup should fail for backend %q", cfg.Backend)
+		}
+		if backup.logger != nil {
+			backup.logger.Close()
+		}
+	}
+}
@@ -5,6 +5,8 @@ import (
 	"path/filepath"
 	"sort"
 	"time"
+
+	"paperless-backup/internal/archive"
 )
 
 // FileInfo holds backup file metadata
@@ -32,10 +34,11 @@ func (b *Backup) cleanupOldBackups() {
 			continue
 		}
 
-		// Check if it's a tar.gz file
+		// Only backups written by this tool count: timestamp-named
+		// archives of any codec, or the index of a split archive (its
+		// parts are not counted separately)
 		name := entry.Name()
-		matched, _ := filepath.Match("*.tar.gz", name)
-		if !matched {
+		if _, ok := parseBackupName(name); !ok {
 			continue
 		}
 
@@ -81,13 +84,13 @@ func (b *Backup) cleanupOldBackups() {
 		}
 	}
 
+	oldBackups = b.keepRequiredBackups(oldBackups)
+
 	// Delete old backups
 	deletedCount := 0
 	for _, backup := range oldBackups {
 		b.logger.Logf("INFO", "Deleting old backup: %s", filepath.Base(backup.Path))
-		if err := os.Remove(backup.Path); err != nil {
-			b.logger.Logf("WARN", "Failed to delete %s: %v", backup.Path, err)
-		} else {
+		if b.deleteBackupSet(backup.Path) {
 			deletedCount++
 		}
 	}
@@ -103,3 +106,73 @@ func (b *Backup) cleanupOldBackups() {
 	b.logger.Logf("INFO", "Total backups: %d", remainingBackups)
 }
 
+// keepRequiredBackups drops backups from the deletion list that a kept
+// incremental backup still builds on
+func (b *Backup) keepRequiredBackups(oldBackups []FileInfo) []FileInfo {
+	names, err := sortedBackupNames(b.config.BackupDir)
+	if err != nil {
+		return oldBackups
+	}
+
+	deleting := make(map[string]bool)
+	for _, backup := range oldBackups {
+		deleting[filepath.Base(backup.Path)] = true
+	}
+	kept := make(map[string]bool)
+	for _, name := range names {
+		if !deleting[name] {
+			kept[name] = true
+		}
+	}
+	required := requiredBackups(names, kept)
+
+	var remaining []FileInfo
+	for _, backup := range oldBackups {
+		if required[filepath.Base(backup.Path)] {
+			b.logger.Logf("INFO", "Keeping %s: required by a newer incremental backup", filepath.Base(backup.Path))
+			continue
+		}
+		remaining = append(remaining, backup)
+	}
+	return remaining
+}
+
+// deleteBackupSet removes all files of a backup: the archive or its parts,
+// the part index and the checksum sidecar. The index of a split archive is
+// removed last, so an interrupted deletion leaves it listed for the next run.
+func (b *Backup) deleteBackupSet(backupPath string) bool {
+	ok := true
+	for _, path := range archive.SetFiles(backupPath) {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			b.logger.Logf("WARN", "Failed to delete %s: %v", path, err)
+			ok = false
+		}
+	}
+	return ok
+}
+
+// removeOrphanedPartials deletes unfinished files left behind by crashed
+// runs. It must only be called while holding the lock.
+func (b *Backup) removeOrphanedPartials() {
+	entries, err := os.ReadDir(b.config.BackupDir)
+	if err != nil {
+		b.logger.Log("WARN", "Failed to read backup directory")
+		return
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+
+		path := filepath.Join(b.config.BackupDir, entry.Name())
+		if !archive.IsPartialName(entry.Name()) && !archive.IsOrphanedPart(path) {
+			continue
+		}
+
+		b.logger.Logf("WARN", "Removing incomplete file from an earlier run: %s", entry.Name())
+		if err := os.Remove(path); err != nil {
+			b.logger.Logf("WARN", "Failed to delete %s: %v", path, err)
+		}
+	}
+}
@@ -13,7 +13,7 @@ import (
 func TestCleanupOldBackups(t *testing.T) {
 	tmpDir := t.TempDir()
 	logPath := filepath.Join(tmpDir, "test.log")
-	
+
 	log, _ := logger.New(logPath)
 	defer log.Close()
 
@@ -26,13 +26,13 @@ func TestCleanupOldBackups(t *testing.T) {
 
 	// Create backups with different ages
 	now := time.Now()
-	
+
 	// Recent backup (should be kept)
-	recentFile := filepath.Join(tmpDir, "recent.tar.gz")
+	recentFile := filepath.Join(tmpDir, "20240110_030000.tar.gz")
 	os.WriteFile(recentFile, []byte("recent"), 0644)
-	
+
 	// Old backup (should be deleted)
-	oldFile := filepath.Join(tmpDir, "old.tar.gz")
+	oldFile := filepath.Join(tmpDir, "20240101_030000.tar.gz")
 	os.WriteFile(oldFile, []byte("old"), 0644)
 	oldTime := now.Add(-time.Duration(cfg.MaxBackupAgeDays+1) * 24 * time.Hour)
 	os.Chtimes(oldFile, oldTime, oldTime)
@@ -54,7 +54,7 @@ func TestCleanupOldBackups(t *testing.T) {
 func TestCleanupKeepsAtLeastOne(t *testing.T) {
 	tmpDir := t.TempDir()
 	logPath := filepath.Join(tmpDir, "test.log")
-	
+
 	log, _ := logger.New(logPath)
 	defer log.Close()
 
@@ -68,12 +68,12 @@ func TestCleanupKeepsAtLeastOne(t *testing.T) {
 	// Create only old backups
 	now := time.Now()
 	oldTime := now.Add(-time.Duration(cfg.MaxBackupAgeDays+1) * 24 * time.Hour)
-	
-	oldest := filepath.Join(tmpDir, "oldest.tar.gz")
+
+	oldest := filepath.Join(tmpDir, "20231201_030000.tar.gz")
 	os.WriteFile(oldest, []byte("oldest"), 0644)
 	os.Chtimes(oldest, oldTime.Add(-48*time.Hour), oldTime.Add(-48*time.Hour))
 
-	lessOld := filepath.Join(tmpDir, "less_old.tar.gz")
+	lessOld := filepath.Join(tmpDir, "20231215_030000.tar.gz")
 	os.WriteFile(lessOld, []byte("less old"), 0644)
 	os.Chtimes(lessOld, oldTime, oldTime)
 
@@ -102,7 +102,7 @@ func TestCleanupKeepsAtLeastOne(t *testing.T) {
 func TestCleanupNoBackups(t *testing.T) {
 	tmpDir := t.TempDir()
 	logPath := filepath.Join(tmpDir, "test.log")
-	
+
 	log, _ := logger.New(logPath)
 	defer log.Close()
 
@@ -120,7 +120,7 @@ func TestCleanupNoBackups(t *testing.T) {
 func TestCleanupIgnoresNonBackupFiles(t *testing.T) {
 	tmpDir := t.TempDir()
 	logPath := filepath.Join(tmpDir, "test.log")
-	
+
 	log, _ := logger.New(logPath)
 	defer log.Close()
 
@@ -134,20 +134,248 @@ func TestCleanupIgnoresNonBackupFiles(t *testing.T) {
 	// Create non-backup files
 	os.WriteFile(filepath.Join(tmpDir, "readme.txt"), []byte("readme"), 0644)
 	os.WriteFile(filepath.Join(tmpDir, "backup.log"), []byte("log"), 0644)
-	
+
 	// Create old backup
 	now := time.Now()
 	oldTime := now.Add(-time.Duration(cfg.MaxBackupAgeDays+1) * 24 * time.Hour)
-	oldBackup := filepath.Join(tmpDir, "old.tar.gz")
+	oldBackup := filepath.Join(tmpDir, "20240101_030000.tar.gz")
 	os.WriteFile(oldBackup, []byte("old"), 0644)
 	os.Chtimes(oldBackup, oldTime, oldTime)
 
+	// Archives this tool did not write
+	foreign := []string{"manual.tar.gz", "export.tar", "20240101_030000-copy.tar.zst", "20240101_030000.old.tar.gz"}
+	for _, name := range foreign {
+		os.WriteFile(filepath.Join(tmpDir, name), []byte("foreign"), 0644)
+		os.Chtimes(filepath.Join(tmpDir, name), oldTime, oldTime)
+	}
+
 	// Run cleanup
 	backup.cleanupOldBackups()
 
 	// Non-backup files should still exist
-	if _, err := os.Stat(filepath.Join(tmpDir, "readme.txt")); os.IsNotExist(err) {
-		t.Error("Non-backup files should not be deleted")
+	for _, name := range append([]string{"readme.txt"}, foreign...) {
+		if _, err := os.Stat(filepath.Join(tmpDir, name)); os.IsNotExist(err) {
+			t.Errorf("Non-backup file %s should not be deleted", name)
+		}
+	}
+}
+
+func TestCleanupRemovesChecksumFiles(t *testing.T) {
+	tmpDir := t.TempDir()
+	logPath := filepath.Join(tmpDir, "test.log")
+
+	log, _ := logger.New(logPath)
+	defer log.Close()
+
+	cfg := config.Default()
+	cfg.BackupDir = tmpDir
+	backup := &Backup{
+		config: cfg,
+		logger: log,
+	}
+
+	now := time.Now()
+	oldTime := now.Add(-time.Duration(cfg.MaxBackupAgeDays+1) * 24 * time.Hour)
+
+	recentFile := filepath.Join(tmpDir, "20240110_030000.tar.gz")
+	os.WriteFile(recentFile, []byte("recent"), 0644)
+	os.WriteFile(recentFile+".sha256", []byte("sum"), 0644)
+
+	oldFile := filepath.Join(tmpDir, "20240101_030000.tar.gz")
+	os.WriteFile(oldFile, []byte("old"), 0644)
+	os.WriteFile(oldFile+".sha256", []byte("sum"), 0644)
+	os.Chtimes(oldFile, oldTime, oldTime)
+
+	backup.cleanupOldBackups()
+
+	if _, err := os.Stat(oldFile + ".sha256"); !os.IsNotExist(err) {
+		t.Error("Checksum file of deleted backup should be removed")
+	}
+	if _, err := os.Stat(recentFile + ".sha256"); os.IsNotExist(err) {
+		t.Error("Checksum file of kept backup should not be removed")
+	}
+}
+
+func TestCleanupRecognisesAllCodecs(t *testing.T) {
+	tmpDir := t.TempDir()
+	logPath := filepath.Join(tmpDir, "test.log")
+
+	log, _ := logger.New(logPath)
+	defer log.Close()
+
+	cfg := config.Default()
+	cfg.BackupDir = tmpDir
+	backup := &Backup{
+		config: cfg,
+		logger: log,
+	}
+
+	now := time.Now()
+	oldTime := now.Add(-time.Duration(cfg.MaxBackupAgeDays+1) * 24 * time.Hour)
+
+	recentFile := filepath.Join(tmpDir, "20240110_030000.tar.zst")
+	os.WriteFile(recentFile, []byte("recent"), 0644)
+
+	var oldFiles []string
+	for _, name := range []string{"20240101_030000.tar.gz", "20240101_030000.tar.zst", "20240101_030000.tar.xz", "20240101_030000.tar"} {
+		oldFile := filepath.Join(tmpDir, name)
+		os.WriteFile(oldFile, []byte("old"), 0644)
+		os.Chtimes(oldFile, oldTime, oldTime)
+		oldFiles = append(oldFiles, oldFile)
+	}
+
+	backup.cleanupOldBackups()
+
+	for _, oldFile := range oldFiles {
+		if _, err := os.Stat(oldFile); !os.IsNotExist(err) {
+			t.Errorf("Old backup %s should be deleted", filepath.Base(oldFile))
+		}
+	}
+	if _, err := os.Stat(recentFile); os.IsNotExist(err) {
+		t.Error("Recent backup should not be deleted")
+	}
+}
+
+func TestRemoveOrphanedPartials(t *testing.T) {
+	tmpDir := t.TempDir()
+	logPath := filepath.Join(tmpDir, "test.log")
+
+	log, _ := logger.New(logPath)
+	defer log.Close()
+
+	cfg := config.Default()
+	cfg.BackupDir = tmpDir
+	backup := &Backup{
+		config: cfg,
+		logger: log,
+	}
+
+	complete := filepath.Join(tmpDir, "20240101_030000.tar.gz")
+	os.WriteFile(complete, []byte("complete"), 0600)
+	partial := filepath.Join(tmpDir, "20240102_030000.tar.gz.partial")
+	os.WriteFile(partial, []byte("trunc"), 0600)
+	partialSum := filepath.Join(tmpDir, "20240101_030000.tar.gz.sha256.partial")
+	os.WriteFile(partialSum, []byte("sum"), 0644)
+
+	backup.removeOrphanedPartials()
+
+	if _, err := os.Stat(partial); !os.IsNotExist(err) {
+		t.Error("Partial archive should be removed")
+	}
+	if _, err := os.Stat(partialSum); !os.IsNotExist(err) {
+		t.Error("Partial checksum file should be removed")
+	}
+	if _, err := os.Stat(complete); err != nil {
+		t.Error("Complete archive should not be removed")
 	}
 }
 
+func TestCleanupRemovesSplitArchives(t *testing.T) {
+	tmpDir := t.TempDir()
+	logPath := filepath.Join(tmpDir, "test.log")
+
+	log, _ := logger.New(logPath)
+	defer log.Close()
+
+	cfg := config.Default()
+	cfg.BackupDir = tmpDir
+	backup := &Backup{
+		config: cfg,
+		logger: log,
+	}
+
+	now := time.Now()
+	oldTime := now.Add(-time.Duration(cfg.MaxBackupAgeDays+1) * 24 * time.Hour)
+
+	recentFile := filepath.Join(tmpDir, "20240110_030000.tar.gz")
+	os.WriteFile(recentFile, []byte("recent"), 0644)
+
+	// A split archive counts as one backup and is removed as a whole
+	oldFile := filepath.Join(tmpDir, "20240101_030000.tar.gz")
+	index := `{"archive":"20240101_030000.tar.gz","parts":[{"name":"20240101_030000.tar.gz.part001"},{"name":"20240101_030000.tar.gz.part002"}]}`
+	os.WriteFile(oldFile+".part001", []byte("part1"), 0600)
+	os.WriteFile(oldFile+".part002", []byte("part2"), 0600)
+	os.WriteFile(oldFile+".sha256", []byte("sums"), 0644)
+	os.WriteFile(oldFile+".parts", []byte(index), 0600)
+	os.Chtimes(oldFile+".parts", oldTime, oldTime)
+
+	backup.cleanupOldBackups()
+
+	for _, suffix := range []string{".part001", ".part002", ".sha256", ".parts"} {
+		if _, err := os.Stat(oldFile + suffix); !os.IsNotExist(err) {
+			t.Errorf("%s of deleted split backup should be removed", suffix)
+		}
+	}
+	if _, err := os.Stat(recentFile); os.IsNotExist(err) {
+		t.Error("Recent backup should not be deleted")
+	}
+}
+
+func TestRemoveOrphanedParts(t *testing.T) {
+	tmpDir := t.TempDir()
+	logPath := filepath.Join(tmpDir, "test.log")
+
+	log, _ := logger.New(logPath)
+	defer log.Close()
+
+	cfg := config.Default()
+	cfg.BackupDir = tmpDir
+	backup := &Backup{
+		config: cfg,
+		logger: log,
+	}
+
+	// Parts published before a crash, without their index
+	orphan := filepath.Join(tmpDir, "20240102_030000.tar.gz.part001")
+	os.WriteFile(orphan, []byte("part"), 0600)
+
+	complete := filepath.Join(tmpDir, "20240101_030000.tar.gz")
+	os.WriteFile(complete+".part001", []byte("part"), 0600)
+	os.WriteFile(complete+".parts", []byte("{}"), 0600)
+
+	backup.removeOrphanedPartials()
+
+	if _, err := os.Stat(orphan); !os.IsNotExist(err) {
+		t.Error("Part without an index should be removed")
+	}
+	if _, err := os.Stat(complete + ".part001"); err != nil {
+		t.Error("Part of a complete split archive should not be removed")
+	}
+}
+
+func TestCleanupKeepsBaseOfIncrementals(t *testing.T) {
+	tmpDir := t.TempDir()
+	log, _ := logger.New(filepath.Join(tmpDir, "test.log"))
+	defer log.Close()
+
+	cfg := config.Default()
+	cfg.BackupDir = tmpDir
+	backup := &Backup{
+		config: cfg,
+		logger: log,
+	}
+
+	oldTime := time.Now().Add(-time.Duration(cfg.MaxBackupAgeDays+1) * 24 * time.Hour)
+	oldFull := filepath.Join(tmpDir, "20240101_020000.tar.gz")
+	oldIncremental := filepath.Join(tmpDir, "20240102_020000_L1.tar.gz")
+	olderFull := filepath.Join(tmpDir, "20231201_020000.tar.gz")
+	recentIncremental := filepath.Join(tmpDir, "20240103_020000_L2.tar.gz")
+	for _, path := range []string{olderFull, oldFull, oldIncremental, recentIncremental} {
+		os.WriteFile(path, []byte("backup"), 0644)
+	}
+	for _, path := range []string{olderFull, oldFull, oldIncremental} {
+		os.Chtimes(path, oldTime, oldTime)
+	}
+
+	backup.cleanupOldBackups()
+
+	// The recent level 2 backup builds on both old backups of its chain
+	for _, path := range []string{oldFull, oldIncremental, recentIncremental} {
+		if _, err := os.Stat(path); err != nil {
+			t.Errorf("%s is part of a kept chain and should not be deleted", filepath.Base(path))
+		}
+	}
+	if _, err := os.Stat(olderFull); !os.IsNotExist(err) {
+		t.Error("Unreferenced old full backup should be deleted")
+	}
+}
@@ -0,0 +1,82 @@
+package backup
+
+import (
+	"context"
+	"errors"
+)
+
+// Exit codes of a backup run
+const (
+	ExitOK          = 0
+	ExitFailure     = 1 // Archive creation, verification or publishing failed
+	ExitConfig      = 2 // Invalid configuration or environment
+	ExitLocked      = 3 // Another backup holds the lock
+	ExitPreflight   = 4 // Missing tools, docker unavailable or too little disk space
+	ExitService     = 5 // Paperless could not be stopped or restarted
+	ExitSource      = 6 // A required source could not be found
+	ExitInterrupted = 7 // Stopped by a signal and rolled back
+)
+
+var (
+	// ErrLocked is returned when another backup holds the lock file
+	ErrLocked = errors.New("backup already running")
+
+	// ErrInterrupted is the cause of a run cancelled by a signal
+	ErrInterrupted = errors.New("backup interrupted")
+)
+
+// Error is a failed step of a backup run
+type Error struct {
+	Step string // lock, preflight, service, sources, backup or restore
+	Code int    // One of the Exit* codes
+	Err  error
+}
+
+func (e *Error) Error() string {
+	return e.Step + ": " + e.Err.Error()
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// stepError wraps err as a failure of step, or returns nil
+func stepError(step string, code int, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &Error{Step: step, Code: code, Err: err}
+}
+
+// runStep runs a step unless ctx is already cancelled and returns its
+// error as checkStep does
+func runStep(ctx context.Context, step string, code int, fn func() error) error {
+	if ctx.Err() != nil {
+		return &Error{Step: step, Code: ExitInterrupted, Err: context.Cause(ctx)}
+	}
+	return checkStep(ctx, step, code, fn())
+}
+
+// checkStep returns the error of a finished step: an interruption when the
+// step failed because ctx was cancelled, otherwise err as a failure with
+// code, or nil. A step that completed despite a signal keeps its result, so
+// a published archive is never reported as rolled back.
+func checkStep(ctx context.Context, step string, code int, err error) error {
+	if err != nil && ctx.Err() != nil && (errors.Is(err, ctx.Err()) || errors.Is(err, context.Cause(ctx))) {
+		return &Error{Step: step, Code: ExitInterrupted, Err: context.Cause(ctx)}
+	}
+	return stepError(step, code, err)
+}
+
+// ExitCode maps the error of a run to its exit code. Errors that are not
+// a step failure count as a failed backup.
+func ExitCode(err error) int {
+	if err == nil {
+		return ExitOK
+	}
+	var stepErr *Error
+	if errors.As(err, &stepErr) {
+		return stepErr.Code
+	}
+	return ExitFailure
+}
@@ -0,0 +1,281 @@
+package backup
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"paperless-backup/internal/archive"
+)
+
+// timestampFormat starts every backup file name
+const timestampFormat = "20060102_150405"
+
+// backupName is the parsed name of a backup: <timestamp>[_L<level>]<ext>
+type backupName struct {
+	Time  time.Time
+	Level int
+}
+
+// parseBackupName extracts timestamp and level from a backup file name.
+// Names without a level suffix are full backups. Only names this tool
+// writes are accepted, so retention never touches other files.
+func parseBackupName(name string) (backupName, bool) {
+	name = filepath.Base(name)
+	if len(name) < len(timestampFormat) {
+		return backupName{}, false
+	}
+
+	t, err := time.ParseInLocation(timestampFormat, name[:len(timestampFormat)], time.Local)
+	if err != nil {
+		return backupName{}, false
+	}
+
+	parsed := backupName{Time: t}
+	rest := name[len(timestampFormat):]
+	if strings.HasPrefix(rest, "_L") {
+		i := strings.IndexByte(rest, '.')
+		if i < 0 {
+			return backupName{}, false
+		}
+		level, err := strconv.Atoi(rest[2:i])
+		if err != nil || level < 0 {
+			return backupName{}, false
+		}
+		parsed.Level = level
+		rest = rest[i:]
+	}
+	if !isBackupExt(rest) {
+		return backupName{}, false
+	}
+	return parsed, true
+}
+
+// isBackupExt reports whether ext is exactly the extension of a backup: an
+// archive extension, optionally encrypted, or the index of a split archive
+func isBackupExt(ext string) bool {
+	ext = strings.TrimSuffix(ext, archive.IndexExt)
+	ext = strings.TrimSuffix(ext, archive.EncryptedExt)
+	return slices.Contains(archive.Extensions(), ext)
+}
+
+// levelSuffix returns the name suffix of a backup level
+func levelSuffix(level int) string {
+	if level == 0 {
+		return ""
+	}
+	return fmt.Sprintf("_L%d", level)
+}
+
+// sortedBackupNames returns the file names of all backups in dir in
+// chronological order
+func sortedBackupNames(dir string) ([]string, error) {
+	backups, err := ListBackups(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, backup := range backups {
+		name := filepath.Base(backup.Path)
+		if _, ok := parseBackupName(name); ok {
+			names = append(names, name)
+		}
+	}
+	// Timestamps sort lexically
+	sort.Strings(names)
+	return names, nil
+}
+
+// baseIndex returns the index of the backup names[i] builds on: the most
+// recent earlier backup of a lower level. It returns -1 for full backups
+// and an error when the base is missing.
+func baseIndex(names []string, i int) (int, error) {
+	current, _ := parseBackupName(names[i])
+	if current.Level == 0 {
+		return -1, nil
+	}
+	for j := i - 1; j >= 0; j-- {
+		if parsed, _ := parseBackupName(names[j]); parsed.Level < current.Level {
+			return j, nil
+		}
+	}
+	return -1, fmt.Errorf("base of incremental backup %s is missing", names[i])
+}
+
+// Chain returns the backups needed to restore a backup, starting with its
+// full backup and ending with the backup itself
+func Chain(backupPath string) ([]string, error) {
+	dir := filepath.Dir(backupPath)
+	names, err := sortedBackupNames(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	// Split archives are listed by their index
+	target := filepath.Base(backupPath)
+	if i := sort.SearchStrings(names, target+archive.IndexExt); i < len(names) && names[i] == target+archive.IndexExt {
+		target = names[i]
+	}
+
+	i := sort.SearchStrings(names, target)
+	if i == len(names) || names[i] != target {
+		// Not a backup of this tool, restore it on its own
+		return []string{backupPath}, nil
+	}
+
+	chain := []string{filepath.Join(dir, names[i])}
+	for {
+		base, err := baseIndex(names, i)
+		if err != nil {
+			return nil, err
+		}
+		if base < 0 {
+			break
+		}
+		chain = append([]string{filepath.Join(dir, names[base])}, chain...)
+		i = base
+	}
+	return chain, nil
+}
+
+// requiredBackups returns the names of all backups the kept backups depend
+// on, directly or through other incrementals
+func requiredBackups(names []string, kept map[string]bool) map[string]bool {
+	required := make(map[string]bool)
+	for i, name := range names {
+		if !kept[name] {
+			continue
+		}
+		for j := i; ; {
+			base, err := baseIndex(names, j)
+			if err != nil || base < 0 || required[names[base]] {
+				break
+			}
+			required[names[base]] = true
+			j = base
+		}
+	}
+	return required
+}
+
+// stateDir returns the directory holding the snapshots of a level
+func (b *Backup) stateDir(level int) string {
+	return filepath.Join(b.config.BackupDir, b.config.StateDir, fmt.Sprintf("L%d", level))
+}
+
+// planIncremental decides the level of this run and attaches the base
+// snapshots to the sources. Any missing or inconsistent state falls back
+// to a lower level, ultimately a full backup.
+func (b *Backup) planIncremental(sources []archive.Source) int {
+	if b.config.FullBackupDays <= 0 {
+		return 0
+	}
+
+	names, err := sortedBackupNames(b.config.BackupDir)
+	if err != nil {
+		b.logger.Logf("WARN", "Failed to list backups, creating a full backup: %v", err)
+		return 0
+	}
+
+	lastFull := -1
+	for i, name := range names {
+		if parsed, _ := parseBackupName(name); parsed.Level == 0 {
+			lastFull = i
+		}
+	}
+	if lastFull < 0 {
+		b.logger.Log("INFO", "No full backup found - creating a full backup")
+		return 0
+	}
+	full, _ := parseBackupName(names[lastFull])
+	if time.Since(full.Time) >= time.Duration(b.config.FullBackupDays)*24*time.Hour {
+		b.logger.Logf("INFO", "Last full backup is older than %d days - creating a full backup", b.config.FullBackupDays)
+		return 0
+	}
+
+	last, _ := parseBackupName(names[len(names)-1])
+	maxLevel := b.config.IncrementalLevels
+	if maxLevel < 1 {
+		maxLevel = 1
+	}
+	level := last.Level + 1
+	if level > maxLevel {
+		level = maxLevel
+	}
+
+	for ; level > 0; level-- {
+		// The base is the most recent backup of a lower level
+		base := ""
+		for i := len(names) - 1; i >= 0; i-- {
+			if parsed, _ := parseBackupName(names[i]); parsed.Level < level {
+				base = names[i]
+				break
+			}
+		}
+
+		snapshots, err := b.loadSnapshots(level-1, base, sources)
+		if err != nil {
+			b.logger.Logf("WARN", "Cannot create level %d backup: %v", level, err)
+			continue
+		}
+		for i := range sources {
+			sources[i].Base = snapshots[sources[i].Name]
+		}
+		b.logger.Logf("INFO", "Creating level %d incremental backup based on %s", level, base)
+		return level
+	}
+
+	b.logger.Log("INFO", "No usable snapshot found - creating a full backup")
+	return 0
+}
+
+// loadSnapshots reads the snapshots of a level and checks that they belong
+// to the expected base backup
+func (b *Backup) loadSnapshots(level int, base string, sources []archive.Source) (map[string]*archive.Snapshot, error) {
+	snapshots := make(map[string]*archive.Snapshot)
+	for _, source := range sources {
+		snapshot, err := archive.LoadSnapshot(filepath.Join(b.stateDir(level), source.Name+".json"))
+		if err != nil {
+			return nil, fmt.Errorf("no level %d snapshot of %s: %w", level, source.Name, err)
+		}
+		if snapshot.Archive != strings.TrimSuffix(base, archive.IndexExt) || snapshot.Level != level {
+			return nil, fmt.Errorf("level %d snapshot of %s belongs to %s, not %s", level, source.Name, snapshot.Archive, base)
+		}
+		snapshots[source.Name] = snapshot
+	}
+	return snapshots, nil
+}
+
+// saveSnapshots stores the snapshots of a published backup as the state of
+// its level. Snapshots of this and higher levels are removed first, so a
+// failed write never leaves a stale base behind.
+func (b *Backup) saveSnapshots(level int, snapshots []*archive.Snapshot) error {
+	stateRoot := filepath.Join(b.config.BackupDir, b.config.StateDir)
+	entries, _ := os.ReadDir(stateRoot)
+	for _, entry := range entries {
+		n, err := strconv.Atoi(strings.TrimPrefix(entry.Name(), "L"))
+		if err == nil && strings.HasPrefix(entry.Name(), "L") && n >= level {
+			if err := os.RemoveAll(filepath.Join(stateRoot, entry.Name())); err != nil {
+				return fmt.Errorf("failed to remove outdated snapshots: %w", err)
+			}
+		}
+	}
+
+	if err := os.MkdirAll(b.stateDir(level), 0700); err != nil {
+		return fmt.Errorf("failed to create state directory: %w", err)
+	}
+	for _, snapshot := range snapshots {
+		snapshot.Archive = filepath.Base(b.backupFile)
+		snapshot.Level = level
+		if err := snapshot.Save(filepath.Join(b.stateDir(level), snapshot.Source+".json")); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,188 @@
+package backup
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"paperless-backup/internal/archive"
+	"paperless-backup/internal/config"
+	"paperless-backup/internal/logger"
+)
+
+func TestParseBackupName(t *testing.T) {
+	tests := []struct {
+		name  string
+		ok    bool
+		level int
+	}{
+		{"20240101_020000.tar.gz", true, 0},
+		{"20240101_020000_L1.tar.zst.age", true, 1},
+		{"20240101_020000_L2.tar.gz.parts", true, 2},
+		{"20240101_020000_Lx.tar.gz", false, 0},
+		{"backup.tar.gz", false, 0},
+		{"20240101_020000.txt", false, 0},
+		{"20240101_020000-copy.tar.gz", false, 0},
+		{"20240101_020000.old.tar.gz", false, 0},
+		{"20240101_020000_L1", false, 0},
+	}
+
+	for _, tt := range tests {
+		parsed, ok := parseBackupName(tt.name)
+		if ok != tt.ok || parsed.Level != tt.level {
+			t.Errorf("parseBackupName(%q) = %+v, %v; want level %d, %v", tt.name, parsed, ok, tt.level, tt.ok)
+		}
+	}
+}
+
+func TestChain(t *testing.T) {
+	tmpDir := t.TempDir()
+	for _, name := range []string{
+		"20240101_020000.tar.gz",
+		"20240102_020000_L1.tar.gz",
+		"20240103_020000_L2.tar.gz",
+		"20240104_020000_L1.tar.gz",
+		"20240105_020000.tar.gz",
+	} {
+		os.WriteFile(filepath.Join(tmpDir, name), []byte("backup"), 0644)
+	}
+
+	tests := []struct {
+		name  string
+		chain []string
+	}{
+		{"20240101_020000.tar.gz", []string{"20240101_020000.tar.gz"}},
+		{"20240103_020000_L2.tar.gz", []string{"20240101_020000.tar.gz", "20240102_020000_L1.tar.gz", "20240103_020000_L2.tar.gz"}},
+		{"20240104_020000_L1.tar.gz", []string{"20240101_020000.tar.gz", "20240104_020000_L1.tar.gz"}},
+	}
+
+	for _, tt := range tests {
+		chain, err := Chain(filepath.Join(tmpDir, tt.name))
+		if err != nil {
+			t.Fatalf("Chain(%s) failed: %v", tt.name, err)
+		}
+		if len(chain) != len(tt.chain) {
+			t.Fatalf("Chain(%s) = %v, want %v", tt.name, chain, tt.chain)
+		}
+		for i := range chain {
+			if filepath.Base(chain[i]) != tt.chain[i] {
+				t.Errorf("Chain(%s) = %v, want %v", tt.name, chain, tt.chain)
+				break
+			}
+		}
+	}
+
+	// Incrementals without their full backup cannot be restored
+	os.Remove(filepath.Join(tmpDir, "20240101_020000.tar.gz"))
+	if _, err := Chain(filepath.Join(tmpDir, "20240103_020000_L2.tar.gz")); err == nil {
+		t.Error("Expected error for a missing base")
+	}
+}
+
+func TestRequiredBackups(t *testing.T) {
+	names := []string{
+		"20240101_020000.tar.gz",
+		"20240102_020000_L1.tar.gz",
+		"20240103_020000_L2.tar.gz",
+		"20240104_020000.tar.gz",
+	}
+
+	required := requiredBackups(names, map[string]bool{"20240103_020000_L2.tar.gz": true, "20240104_020000.tar.gz": true})
+	if len(required) != 2 || !required["20240101_020000.tar.gz"] || !required["20240102_020000_L1.tar.gz"] {
+		t.Errorf("Unexpected required backups: %v", required)
+	}
+
+	required = requiredBackups(names, map[string]bool{"20240104_020000.tar.gz": true})
+	if len(required) != 0 {
+		t.Errorf("A full backup requires nothing, got %v", required)
+	}
+}
+
+func TestPlanIncremental(t *testing.T) {
+	tmpDir := t.TempDir()
+	log, _ := logger.New(filepath.Join(tmpDir, "test.log"))
+	defer log.Close()
+
+	cfg := config.Default()
+	cfg.BackupDir = tmpDir
+	cfg.FullBackupDays = 7
+	cfg.IncrementalLevels = 2
+	b := &Backup{config: cfg, logger: log}
+
+	sources := func() []archive.Source {
+		return []archive.Source{{Name: "data"}, {Name: "media"}}
+	}
+	snapshots := func() []*archive.Snapshot {
+		return []*archive.Snapshot{
+			{Source: "data", Files: map[string]archive.FileState{}},
+			{Source: "media", Files: map[string]archive.FileState{}},
+		}
+	}
+	publish := func(name string, level int) {
+		os.WriteFile(filepath.Join(tmpDir, name), []byte("backup"), 0644)
+		b.backupFile = filepath.Join(tmpDir, name)
+		if err := b.saveSnapshots(level, snapshots()); err != nil {
+			t.Fatalf("saveSnapshots failed: %v", err)
+		}
+	}
+	now := time.Now()
+	stamp := func(offset time.Duration) string {
+		return now.Add(offset).Format(timestampFormat)
+	}
+
+	// No backup yet
+	if level := b.planIncremental(sources()); level != 0 {
+		t.Errorf("Expected a full backup without any backups, got level %d", level)
+	}
+
+	full := stamp(-3*time.Hour) + ".tar.gz"
+	publish(full, 0)
+	s := sources()
+	if level := b.planIncremental(s); level != 1 {
+		t.Fatalf("Expected level 1, got %d", level)
+	}
+	if s[0].Base == nil || s[1].Base == nil || s[1].Base.Archive != full {
+		t.Errorf("Sources should be based on the full backup: %+v", s)
+	}
+
+	publish(stamp(-2*time.Hour)+"_L1.tar.gz", 1)
+	if level := b.planIncremental(sources()); level != 2 {
+		t.Errorf("Expected level 2, got %d", level)
+	}
+
+	// The level is capped, and a level 2 backup replaces the older level 2 state
+	publish(stamp(-time.Hour)+"_L2.tar.gz", 2)
+	if level := b.planIncremental(sources()); level != 2 {
+		t.Errorf("Expected level 2 at the cap, got %d", level)
+	}
+
+	// A missing snapshot falls back to a lower level
+	os.Remove(filepath.Join(b.stateDir(1), "media.json"))
+	if level := b.planIncremental(sources()); level != 1 {
+		t.Errorf("Expected fallback to level 1, got %d", level)
+	}
+
+	// Saving a full backup discards all incremental state
+	publish(stamp(0)+".tar.gz", 0)
+	if _, err := os.Stat(b.stateDir(1)); !os.IsNotExist(err) {
+		t.Error("Level 1 state should be removed by a full backup")
+	}
+
+	// An old full backup forces a new one
+	cfg.FullBackupDays = 1
+	entries, _ := os.ReadDir(tmpDir)
+	for _, entry := range entries {
+		os.Remove(filepath.Join(tmpDir, entry.Name()))
+	}
+	publish(stamp(-48*time.Hour)+".tar.gz", 0)
+	if level := b.planIncremental(sources()); level != 0 {
+		t.Errorf("Expected a full backup after FullBackupDays, got level %d", level)
+	}
+
+	// Disabled incrementals always create full backups
+	cfg.FullBackupDays = 0
+	if level := b.planIncremental(sources()); level != 0 {
+		t.Errorf("Expected a full backup when disabled, got level %d", level)
+	}
+}
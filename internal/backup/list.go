@@ -0,0 +1,59 @@
+package backup
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// ListBackups returns the timestamp-named backups in dir, newest first.
+// Split archives are represented by their index file.
+func ListBackups(dir string) ([]FileInfo, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read backup directory: %w", err)
+	}
+
+	var backups []FileInfo
+	for _, entry := range entries {
+		if _, ok := parseBackupName(entry.Name()); entry.IsDir() || !ok {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		backups = append(backups, FileInfo{
+			Path:    filepath.Join(dir, entry.Name()),
+			ModTime: info.ModTime(),
+		})
+	}
+
+	sort.Slice(backups, func(i, j int) bool {
+		return backups[i].ModTime.After(backups[j].ModTime)
+	})
+	return backups, nil
+}
+
+// ResolveBackup turns a command line argument into a backup path: "latest"
+// selects the newest backup in dir, bare names are looked up in dir
+func ResolveBackup(dir, name string) (string, error) {
+	if name == "latest" {
+		backups, err := ListBackups(dir)
+		if err != nil {
+			return "", err
+		}
+		if len(backups) == 0 {
+			return "", fmt.Errorf("no backups found in %s", dir)
+		}
+		return backups[0].Path, nil
+	}
+
+	if filepath.Base(name) == name {
+		if _, err := os.Stat(name); os.IsNotExist(err) {
+			return filepath.Join(dir, name), nil
+		}
+	}
+	return name, nil
+}
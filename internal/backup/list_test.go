@@ -0,0 +1,51 @@
+package backup
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestListBackups(t *testing.T) {
+	tmpDir := t.TempDir()
+	now := time.Now()
+
+	files := map[string]time.Duration{
+		"20240101_030000.tar.gz":       -48 * time.Hour,
+		"20240102_030000.tar.zst.age":  -24 * time.Hour,
+		"20240103_030000.tar.gz.parts": 0,
+	}
+	for name, age := range files {
+		path := filepath.Join(tmpDir, name)
+		os.WriteFile(path, []byte("x"), 0600)
+		os.Chtimes(path, now.Add(age), now.Add(age))
+	}
+	// Not backups
+	os.WriteFile(filepath.Join(tmpDir, "20240103_030000.tar.gz.part001"), []byte("x"), 0600)
+	os.WriteFile(filepath.Join(tmpDir, "20240101_030000.tar.gz.sha256"), []byte("x"), 0600)
+	os.WriteFile(filepath.Join(tmpDir, "backup.log"), []byte("x"), 0600)
+
+	backups, err := ListBackups(tmpDir)
+	if err != nil {
+		t.Fatalf("ListBackups failed: %v", err)
+	}
+	if len(backups) != 3 {
+		t.Fatalf("Expected 3 backups, got %v", backups)
+	}
+	if filepath.Base(backups[0].Path) != "20240103_030000.tar.gz.parts" {
+		t.Errorf("Newest backup should come first, got %s", backups[0].Path)
+	}
+
+	latest, err := ResolveBackup(tmpDir, "latest")
+	if err != nil || latest != backups[0].Path {
+		t.Errorf("ResolveBackup(latest) = %s, %v", latest, err)
+	}
+	named, _ := ResolveBackup(tmpDir, "20240101_030000.tar.gz")
+	if named != filepath.Join(tmpDir, "20240101_030000.tar.gz") {
+		t.Errorf("Bare names should resolve in the backup directory, got %s", named)
+	}
+	if _, err := ResolveBackup(t.TempDir(), "latest"); err == nil {
+		t.Error("ResolveBackup(latest) should fail without backups")
+	}
+}
@@ -0,0 +1,101 @@
+package backup
+
+import (
+	"encoding/json"
+	"os/exec"
+	"regexp"
+	"strings"
+
+	"paperless-backup/internal/archive"
+	"paperless-backup/internal/config"
+)
+
+// Version is the version of the tool recorded in archives, set at build time
+// with -ldflags "-X paperless-backup/internal/backup.Version=..."
+var Version = "dev"
+
+// describeRun sets the metadata the next archive records about this run
+func (b *Backup) describeRun() {
+	metadata := archive.Metadata{Tool: Version}
+
+	config, err := json.Marshal(b.config)
+	if err != nil {
+		b.logger.Logf("WARN", "Failed to record configuration in the archive: %v", err)
+	} else {
+		metadata.Config = config
+	}
+
+	metadata.Container = b.inspectContainer()
+	b.archiver.SetMetadata(metadata)
+}
+
+// inspectContainer identifies the paperless container by the first source
+// read from a container or, failing that, a docker volume. The container is
+// stopped during the backup, so stopped containers count.
+func (b *Backup) inspectContainer() *archive.ContainerInfo {
+	filter := containerFilter(b.config.Sources)
+	if filter == "" {
+		b.logger.Log("WARN", "No source is read from docker, not recording the container image")
+		return nil
+	}
+	cmd := exec.Command("docker", "ps", "--all", "--filter", filter, "--format", "{{.Names}}\t{{.Image}}")
+	output, err := cmd.Output()
+	if err != nil {
+		b.logger.Logf("WARN", "Failed to find the paperless container: %v", err)
+		return nil
+	}
+	container := parseContainer(string(output))
+	if container == nil {
+		b.logger.Logf("WARN", "No container matches %s, not recording its image", filter)
+		return nil
+	}
+
+	cmd = exec.Command("docker", "image", "inspect", container.Image, "--format", "{{.Id}}{{range .RepoDigests}} {{.}}{{end}}")
+	if output, err = cmd.Output(); err != nil {
+		b.logger.Logf("WARN", "Failed to inspect image %s: %v", container.Image, err)
+		return container
+	}
+	container.Digest = imageDigest(string(output))
+	return container
+}
+
+// containerFilter returns the docker ps filter finding the container of the
+// sources, empty when none is read from docker
+func containerFilter(sources []config.Source) string {
+	for _, source := range sources {
+		if source.Container != "" {
+			return "name=^/?" + regexp.QuoteMeta(source.Container) + "$"
+		}
+	}
+	for _, source := range sources {
+		if source.Volume != "" {
+			return "volume=" + source.Volume
+		}
+	}
+	return ""
+}
+
+// parseContainer reads the first container of `docker ps` output formatted
+// as name<TAB>image
+func parseContainer(output string) *archive.ContainerInfo {
+	line, _, _ := strings.Cut(strings.TrimSpace(output), "\n")
+	name, image, ok := strings.Cut(line, "\t")
+	if !ok || name == "" || image == "" {
+		return nil
+	}
+	return &archive.ContainerInfo{Name: name, Image: image}
+}
+
+// imageDigest picks the repository digest from `docker image inspect`
+// output formatted as the image ID followed by the repository digests.
+// Locally built images have no repository digest; their ID is used.
+func imageDigest(output string) string {
+	fields := strings.Fields(output)
+	switch len(fields) {
+	case 0:
+		return ""
+	case 1:
+		return fields[0]
+	}
+	return fields[1]
+}
@@ -0,0 +1,29 @@
+package backup
+
+import "testing"
+
+func TestParseContainer(t *testing.T) {
+	container := parseContainer("paperless-webserver-1\tghcr.io/paperless-ngx/paperless-ngx:2.7\npaperless-worker-1\tother\n")
+	if container == nil || container.Name != "paperless-webserver-1" || container.Image != "ghcr.io/paperless-ngx/paperless-ngx:2.7" {
+		t.Errorf("Unexpected container: %+v", container)
+	}
+
+	for _, output := range []string{"", "\n", "no-image"} {
+		if container := parseContainer(output); container != nil {
+			t.Errorf("parseContainer(%q) = %+v, want nil", output, container)
+		}
+	}
+}
+
+func TestImageDigest(t *testing.T) {
+	tests := map[string]string{
+		"sha256:1111 ghcr.io/paperless-ngx/paperless-ngx@sha256:2222\n": "ghcr.io/paperless-ngx/paperless-ngx@sha256:2222",
+		"sha256:1111\n": "sha256:1111",
+		"":              "",
+	}
+	for output, want := range tests {
+		if got := imageDigest(output); got != want {
+			t.Errorf("imageDigest(%q) = %q, want %q", output, got, want)
+		}
+	}
+}
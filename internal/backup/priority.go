@@ -0,0 +1,93 @@
+package backup
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+
+	"golang.org/x/sys/unix"
+)
+
+// I/O scheduling constants of ioprio_set(2), not exported by x/sys
+const (
+	ioprioWhoProcess = 1
+	ioprioClassShift = 13
+)
+
+// ioprioClasses maps configured class names to kernel class values
+var ioprioClasses = map[string]int{
+	"realtime":    1,
+	"best-effort": 2,
+	"idle":        3,
+}
+
+// validatePriority rejects unusable priority settings before anything is
+// stopped
+func (b *Backup) validatePriority() error {
+	if class := b.config.IOPriorityClass; class != "" {
+		if _, ok := ioprioClasses[class]; !ok {
+			return fmt.Errorf("unknown I/O priority class %q", class)
+		}
+		if level := b.config.IOPriorityLevel; level < 0 || level > 7 {
+			return fmt.Errorf("I/O priority level %d out of range 0-7", level)
+		}
+	}
+	if nice := b.config.Nice; nice < -20 || nice > 19 {
+		return fmt.Errorf("nice value %d out of range -20..19", nice)
+	}
+	if b.config.ReadLimitMB < 0 {
+		return fmt.Errorf("negative read limit %dMB/s", b.config.ReadLimitMB)
+	}
+	return nil
+}
+
+// applyPriority sets the configured I/O and CPU priority. Linux keeps both
+// per thread, so every thread of the process is changed; threads started
+// later inherit the priority of their creator. Failures only warn, the
+// backup still works at the inherited priority.
+func (b *Backup) applyPriority() {
+	class, setIO := ioprioClasses[b.config.IOPriorityClass]
+	if !setIO && b.config.Nice == 0 {
+		return
+	}
+
+	tasks, err := os.ReadDir("/proc/self/task")
+	if err != nil {
+		b.logger.Logf("WARN", "Failed to list threads, priority unchanged: %v", err)
+		return
+	}
+
+	ioprio := class<<ioprioClassShift | b.config.IOPriorityLevel
+	var ioErr, niceErr error
+	for _, task := range tasks {
+		tid, err := strconv.Atoi(task.Name())
+		if err != nil {
+			continue
+		}
+		if setIO {
+			if _, _, errno := unix.Syscall(unix.SYS_IOPRIO_SET, ioprioWhoProcess, uintptr(tid), uintptr(ioprio)); errno != 0 {
+				ioErr = errno
+			}
+		}
+		if b.config.Nice != 0 {
+			if err := unix.Setpriority(unix.PRIO_PROCESS, tid, b.config.Nice); err != nil {
+				niceErr = err
+			}
+		}
+	}
+
+	if setIO {
+		if ioErr != nil {
+			b.logger.Logf("WARN", "Failed to set I/O priority %s/%d: %v", b.config.IOPriorityClass, b.config.IOPriorityLevel, ioErr)
+		} else {
+			b.logger.Logf("INFO", "I/O priority: %s, level %d", b.config.IOPriorityClass, b.config.IOPriorityLevel)
+		}
+	}
+	if b.config.Nice != 0 {
+		if niceErr != nil {
+			b.logger.Logf("WARN", "Failed to set nice value %d: %v", b.config.Nice, niceErr)
+		} else {
+			b.logger.Logf("INFO", "CPU priority: nice %d", b.config.Nice)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package backup
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"paperless-backup/internal/config"
+	"paperless-backup/internal/logger"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestValidatePriority(t *testing.T) {
+	tests := []struct {
+		name    string
+		modify  func(*config.Config)
+		wantErr bool
+	}{
+		{"defaults", func(c *config.Config) {}, false},
+		{"idle", func(c *config.Config) { c.IOPriorityClass = "idle" }, false},
+		{"best-effort lowest", func(c *config.Config) { c.IOPriorityClass, c.IOPriorityLevel = "best-effort", 7 }, false},
+		{"unknown class", func(c *config.Config) { c.IOPriorityClass = "background" }, true},
+		{"level out of range", func(c *config.Config) { c.IOPriorityClass, c.IOPriorityLevel = "best-effort", 8 }, true},
+		{"nice", func(c *config.Config) { c.Nice = 19 }, false},
+		{"nice out of range", func(c *config.Config) { c.Nice = 20 }, true},
+		{"negative read limit", func(c *config.Config) { c.ReadLimitMB = -1 }, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config.Default()
+			tt.modify(cfg)
+			b := &Backup{config: cfg}
+			if err := b.validatePriority(); (err != nil) != tt.wantErr {
+				t.Errorf("validatePriority() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestApplyPriority(t *testing.T) {
+	tmpDir := t.TempDir()
+	logPath := filepath.Join(tmpDir, "test.log")
+	log, _ := logger.New(logPath)
+	defer log.Close()
+
+	// Lowering the own I/O priority needs no privileges
+	cfg := config.Default()
+	cfg.IOPriorityClass = "best-effort"
+	cfg.IOPriorityLevel = 7
+	b := &Backup{config: cfg, logger: log}
+	b.applyPriority()
+
+	ioprio, _, errno := unix.Syscall(unix.SYS_IOPRIO_GET, ioprioWhoProcess, 0, 0)
+	if errno != 0 {
+		t.Fatalf("ioprio_get failed: %v", errno)
+	}
+	if want := uintptr(2<<ioprioClassShift | 7); ioprio != want {
+		t.Errorf("I/O priority = %#x, want %#x", ioprio, want)
+	}
+
+	content, _ := os.ReadFile(logPath)
+	if !strings.Contains(string(content), "I/O priority: best-effort, level 7") {
+		t.Errorf("Applied priority should be logged:\n%s", content)
+	}
+}
@@ -0,0 +1,51 @@
+package backup
+
+import (
+	"context"
+	"time"
+
+	"paperless-backup/internal/archive"
+)
+
+// createSnapshot stores the sources as a new snapshot in the repository
+func (b *Backup) createSnapshot(ctx context.Context, sources []archive.Source) error {
+	b.logger.Logf("INFO", "Creating snapshot in repository %s", b.repository.Path())
+
+	snapshot, err := b.repository.Backup(ctx, sources)
+	if err != nil {
+		return err
+	}
+
+	for _, source := range snapshot.Sources {
+		b.logger.Logf("INFO", "  - %s: %d files (%.2fMB), %d unchanged",
+			source.Name, source.Stats.Files, float64(source.Stats.Bytes)/1024/1024, source.Stats.Unchanged)
+		b.warnings = append(b.warnings, source.Warnings...)
+	}
+	total := snapshot.Total()
+	b.logger.Logf("INFO", "Snapshot %s created: %d new objects, %.2fMB added to the repository",
+		snapshot.ID, total.NewObjects, float64(total.StoredBytes)/1024/1024)
+	return nil
+}
+
+// pruneRepository removes snapshots older than the retention period (always
+// keeps at least one) and deletes the chunks no snapshot references anymore
+func (b *Backup) pruneRepository() {
+	b.logger.Logf("INFO", "Pruning snapshots older than %d days...", b.config.MaxBackupAgeDays)
+
+	removed, err := b.repository.Prune(time.Duration(b.config.MaxBackupAgeDays) * 24 * time.Hour)
+	for _, id := range removed {
+		b.logger.Logf("INFO", "Deleted old snapshot: %s", id)
+	}
+	if err != nil {
+		b.logger.Logf("WARN", "Failed to prune snapshots: %v", err)
+		return
+	}
+
+	result, err := b.repository.GC()
+	if err != nil {
+		b.logger.Logf("WARN", "Garbage collection failed: %v", err)
+		return
+	}
+	b.logger.Logf("INFO", "Garbage collection: %d objects in use, %d removed (%.2fMB freed)",
+		result.Objects, result.Removed, float64(result.FreedBytes)/1024/1024)
+}
@@ -0,0 +1,185 @@
+package backup
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"paperless-backup/internal/archive"
+	"paperless-backup/internal/config"
+)
+
+// mount is a volume or bind mount of a container as listed by docker inspect
+type mount struct {
+	Source      string `json:"Source"`
+	Destination string `json:"Destination"`
+}
+
+// validateSources checks the configured sources without touching docker or
+// the file system
+func validateSources(sources []config.Source) error {
+	if len(sources) == 0 {
+		return fmt.Errorf("no sources configured")
+	}
+
+	// Names, filters and read error policies follow the archive's rules
+	archiveSources := make([]archive.Source, len(sources))
+	for i, source := range sources {
+		archiveSources[i] = archive.Source{Name: source.Name, Filter: archive.Filter(source.Filter), ReadErrors: source.ReadErrors}
+	}
+	if err := archive.ValidateSources(archiveSources); err != nil {
+		return err
+	}
+
+	for _, source := range sources {
+		locations := 0
+		for _, set := range []bool{source.Volume != "", source.Path != "", source.Container != ""} {
+			if set {
+				locations++
+			}
+		}
+		switch {
+		case locations != 1:
+			return fmt.Errorf("source %q: exactly one of volume, path and container must be set", source.Name)
+		case source.Path != "" && !filepath.IsAbs(source.Path):
+			return fmt.Errorf("source %q: path %q is not absolute", source.Name, source.Path)
+		case source.Container != "" && !path.IsAbs(source.ContainerPath):
+			return fmt.Errorf("source %q: container path %q is not absolute", source.Name, source.ContainerPath)
+		case source.Container == "" && source.ContainerPath != "":
+			return fmt.Errorf("source %q: container path without a container", source.Name)
+		}
+	}
+	return nil
+}
+
+// resolveSources locates the sources on the host and returns them as
+// archive sources with their filters and read error policies. Optional
+// sources that cannot be found are left out and returned as skipped.
+func resolveSources(sources []config.Source) ([]archive.Source, []error, error) {
+	var resolved []archive.Source
+	var skipped []error
+	for _, source := range sources {
+		hostPath, err := sourcePath(source)
+		if err != nil && source.Optional {
+			skipped = append(skipped, fmt.Errorf("%s: %w", source.Name, err))
+			continue
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("source %s: %w", source.Name, err)
+		}
+
+		// Each source is stored under a logical root so archives do not
+		// depend on the docker storage layout of this host
+		resolved = append(resolved, archive.Source{
+			Name:       source.Name,
+			Path:       hostPath,
+			Filter:     archive.Filter(source.Filter),
+			ReadErrors: source.ReadErrors,
+		})
+	}
+	if len(resolved) == 0 {
+		return nil, nil, fmt.Errorf("none of the sources was found")
+	}
+	return resolved, skipped, nil
+}
+
+// LiveSources returns the sources a backup would read now, for comparing
+// backups with the live volumes. Optional sources that cannot be found are
+// left out.
+func LiveSources(cfg *config.Config) ([]archive.Source, error) {
+	if err := validateSources(cfg.Sources); err != nil {
+		return nil, err
+	}
+	sources, _, err := resolveSources(cfg.Sources)
+	return sources, err
+}
+
+// sourcePath returns the host directory of a source
+func sourcePath(source config.Source) (string, error) {
+	var hostPath string
+	var err error
+	switch {
+	case source.Volume != "":
+		hostPath, err = volumePath(source.Volume)
+	case source.Container != "":
+		hostPath, err = containerPath(source.Container, source.ContainerPath)
+	default:
+		hostPath = source.Path
+	}
+	if err != nil {
+		return "", err
+	}
+
+	info, err := os.Stat(hostPath)
+	if err != nil {
+		return "", err
+	}
+	if !info.IsDir() {
+		return "", fmt.Errorf("%s is not a directory", hostPath)
+	}
+	return hostPath, nil
+}
+
+// volumePath returns the mount point of a docker volume
+func volumePath(volume string) (string, error) {
+	cmd := exec.Command("docker", "volume", "inspect", volume, "--format", "{{ .Mountpoint }}")
+	output, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("failed to inspect %s volume: %w", volume, err)
+	}
+
+	mountpoint := strings.TrimSpace(string(output))
+
+	// Validate path exists
+	if _, err := os.Stat(mountpoint); os.IsNotExist(err) {
+		return "", fmt.Errorf("volume path does not exist: %s", mountpoint)
+	}
+
+	return mountpoint, nil
+}
+
+// containerPath returns the host directory of a path inside a container.
+// The container is stopped during the backup, so the path must lie on one
+// of its volume or bind mounts.
+func containerPath(container, target string) (string, error) {
+	cmd := exec.Command("docker", "container", "inspect", container, "--format", "{{ json .Mounts }}")
+	output, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("failed to inspect container %s: %w", container, err)
+	}
+
+	var mounts []mount
+	if err := json.Unmarshal(output, &mounts); err != nil {
+		return "", fmt.Errorf("failed to read mounts of container %s: %w", container, err)
+	}
+	hostPath, ok := mountedPath(mounts, target)
+	if !ok {
+		return "", fmt.Errorf("%s is not on a mount of container %s", target, container)
+	}
+	return hostPath, nil
+}
+
+// mountedPath maps a container path to the host through the innermost
+// mount containing it
+func mountedPath(mounts []mount, target string) (string, bool) {
+	target = path.Clean(target)
+	var best *mount
+	for i, m := range mounts {
+		destination := path.Clean(m.Destination)
+		if target != destination && !strings.HasPrefix(target, strings.TrimSuffix(destination, "/")+"/") {
+			continue
+		}
+		if best == nil || len(destination) > len(path.Clean(best.Destination)) {
+			best = &mounts[i]
+		}
+	}
+	if best == nil || best.Source == "" {
+		return "", false
+	}
+	rel := strings.TrimPrefix(target, path.Clean(best.Destination))
+	return filepath.Join(best.Source, filepath.FromSlash(rel)), true
+}
@@ -0,0 +1,97 @@
+package backup
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"paperless-backup/internal/archive"
+	"paperless-backup/internal/config"
+)
+
+func TestValidateSources(t *testing.T) {
+	sources := append(config.Default().Sources,
+		config.Source{Name: "pgdata", Path: "/srv/paperless/pgdata", Optional: true},
+		config.Source{Name: "export", Container: "paperless-webserver-1", ContainerPath: "/usr/src/paperless/export"},
+	)
+	if err := validateSources(sources); err != nil {
+		t.Errorf("validateSources failed: %v", err)
+	}
+}
+
+func TestResolveSources(t *testing.T) {
+	tmpDir := t.TempDir()
+	consume := filepath.Join(tmpDir, "consume")
+	os.MkdirAll(consume, 0755)
+	file := filepath.Join(tmpDir, "file")
+	os.WriteFile(file, []byte("not a directory"), 0644)
+
+	sources := []config.Source{
+		{Name: "consume", Path: consume, Filter: config.Filter{Exclude: []string{"*.tmp"}}, ReadErrors: archive.ReadErrorsTolerant},
+		{Name: "pgdata", Path: filepath.Join(tmpDir, "pgdata"), Optional: true},
+	}
+	resolved, skipped, err := resolveSources(sources)
+	if err != nil {
+		t.Fatalf("resolveSources failed: %v", err)
+	}
+	if len(resolved) != 1 || resolved[0].Name != "consume" || resolved[0].Path != consume ||
+		resolved[0].Filter.Exclude[0] != "*.tmp" || !resolved[0].Tolerant() {
+		t.Errorf("Unexpected sources: %+v", resolved)
+	}
+	if len(skipped) != 1 {
+		t.Errorf("Missing optional source should be skipped: %v", skipped)
+	}
+
+	// Required sources must exist and be directories
+	for _, path := range []string{filepath.Join(tmpDir, "export"), file} {
+		if _, _, err := resolveSources([]config.Source{{Name: "export", Path: path}}); err == nil {
+			t.Errorf("resolveSources should fail for %s", path)
+		}
+	}
+	if _, _, err := resolveSources(sources[1:]); err == nil {
+		t.Error("resolveSources should fail when no source is found")
+	}
+}
+
+func TestMountedPath(t *testing.T) {
+	mounts := []mount{
+		{Source: "/var/lib/docker/volumes/paperless_data/_data", Destination: "/usr/src/paperless/data"},
+		{Source: "/srv/paperless", Destination: "/usr/src/paperless"},
+		{Source: "/srv/root", Destination: "/"},
+	}
+	tests := map[string]string{
+		"/usr/src/paperless/data":        "/var/lib/docker/volumes/paperless_data/_data",
+		"/usr/src/paperless/data/index/": "/var/lib/docker/volumes/paperless_data/_data/index",
+		"/usr/src/paperless/export":      "/srv/paperless/export",
+		"/usr/src/paperless/database":    "/srv/paperless/database",
+		"/tmp":                           "/srv/root/tmp",
+	}
+	for target, want := range tests {
+		if got, ok := mountedPath(mounts, target); !ok || got != want {
+			t.Errorf("mountedPath(%s) = %s, %v, want %s", target, got, ok, want)
+		}
+	}
+
+	if _, ok := mountedPath(mounts[:1], "/usr/src/paperless/export"); ok {
+		t.Error("Paths outside of the mounts should not be mapped")
+	}
+}
+
+func TestContainerFilter(t *testing.T) {
+	tests := []struct {
+		sources []config.Source
+		want    string
+	}{
+		{config.Default().Sources, "volume=paperless-ngx_data"},
+		{[]config.Source{
+			{Name: "data", Volume: "paperless-ngx_data"},
+			{Name: "export", Container: "paperless.web", ContainerPath: "/export"},
+		}, `name=^/?paperless\.web$`},
+		{[]config.Source{{Name: "consume", Path: "/srv/consume"}}, ""},
+	}
+	for _, tt := range tests {
+		if got := containerFilter(tt.sources); got != tt.want {
+			t.Errorf("containerFilter = %q, want %q", got, tt.want)
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package checks
 
 import (
+	"errors"
 	"fmt"
 	"os/exec"
 	"strings"
@@ -10,10 +11,17 @@ import (
 	"golang.org/x/sys/unix"
 )
 
+// Errors returned by the checks
+var (
+	ErrMissingTools      = errors.New("missing required tools")
+	ErrDockerUnavailable = errors.New("docker daemon is not running or not accessible")
+	ErrInsufficientSpace = errors.New("insufficient disk space")
+)
+
 // Checker performs pre-flight validation checks
 type Checker struct {
-	logger    *logger.Logger
-	workDir   string
+	logger     *logger.Logger
+	workDir    string
 	requiredMB int64
 }
 
@@ -27,7 +35,7 @@ func New(logger *logger.Logger, workDir string, requiredMB int64) *Checker {
 }
 
 // RequiredTools verifies required system tools are available
-func (c *Checker) RequiredTools() {
+func (c *Checker) RequiredTools() error {
 	c.logger.Log("INFO", "Checking required system tools...")
 	requiredTools := []string{"docker", "systemctl"}
 	var missing []string
@@ -39,34 +47,36 @@ func (c *Checker) RequiredTools() {
 	}
 
 	if len(missing) > 0 {
-		c.logger.ErrorExit(fmt.Sprintf("Missing required tools: %s", strings.Join(missing, ", ")))
+		return fmt.Errorf("%w: %s", ErrMissingTools, strings.Join(missing, ", "))
 	}
 
 	c.logger.Log("INFO", "All required system tools available")
+	return nil
 }
 
 // Docker verifies docker is running
-func (c *Checker) Docker() {
+func (c *Checker) Docker() error {
 	cmd := exec.Command("docker", "info")
 	if err := cmd.Run(); err != nil {
-		c.logger.ErrorExit("Docker daemon is not running or not accessible")
+		return fmt.Errorf("%w: %v", ErrDockerUnavailable, err)
 	}
+	return nil
 }
 
 // DiskSpace verifies sufficient disk space is available for backup
-func (c *Checker) DiskSpace() {
+func (c *Checker) DiskSpace() error {
 	var stat unix.Statfs_t
 	if err := unix.Statfs(c.workDir, &stat); err != nil {
-		c.logger.ErrorExit("Failed to check disk space")
+		return fmt.Errorf("failed to check disk space: %w", err)
 	}
 
 	// Available blocks * block size / 1024 / 1024 = Available MB
 	availableMB := int64(stat.Bavail) * int64(stat.Bsize) / 1024 / 1024
 
 	if availableMB < c.requiredMB {
-		c.logger.ErrorExit(fmt.Sprintf("Insufficient disk space. Available: %dMB, Required: %dMB", availableMB, c.requiredMB))
+		return fmt.Errorf("%w: available %dMB, required %dMB", ErrInsufficientSpace, availableMB, c.requiredMB)
 	}
 
 	c.logger.Logf("INFO", "Available disk space: %dMB", availableMB)
+	return nil
 }
-
@@ -2,29 +2,130 @@ package config
 
 // Config holds all configuration for the paperless backup tool
 type Config struct {
-	BackupDir        string
-	LogFile          string
-	LockFile         string
-	MaxBackupAgeDays int
-	RequiredSpaceMB  int64
-	PaperlessService string
-	DataVolume       string
-	MediaVolume      string
-	RedisVolume      string
+	BackupDir          string
+	LogFile            string
+	LockFile           string
+	LockWait           int // Seconds to wait for a running backup to finish, 0 fails immediately
+	MaxBackupAgeDays   int
+	RequiredSpaceMB    int64
+	PaperlessService   string
+	DeepVerify         bool   // Re-hash every file against the manifest before publishing
+	Compression        string // Archive codec: gzip, zstd, xz or none
+	CompressionLevel   int    // Codec specific level, 0 selects the codec default
+	CompressionWorkers int    // Parallel compression workers, 0 uses all CPU cores
+	ProgressInterval   int    // Seconds between progress lines while archiving, 0 disables
+
+	// Encryption (age): either public key recipients or a passphrase file
+	EncryptionRecipients     []string // age1... public keys archives are encrypted to
+	EncryptionPassphraseFile string   // File holding the passphrase for scrypt mode
+	EncryptionIdentityFile   string   // age identity file used to verify encrypted archives
+
+	// PreserveMetadata keeps real timestamps, ownership, xattrs and ACLs for
+	// faithful restores. When false timestamps are zeroed and the host and
+	// run times are not recorded, so identical content produces
+	// byte-identical unencrypted archives.
+	PreserveMetadata bool
+
+	// MaxPartSizeMB splits archives into numbered parts of at most this
+	// size plus an index file. 0 writes a single archive file.
+	MaxPartSizeMB int64
+
+	// ParityPercent writes Reed-Solomon parity sidecars of this size in
+	// percent of the archive, so bit rot can be repaired with the repair
+	// command. Rounded up to steps of 5%; 0 writes no parity data.
+	ParityPercent int
+
+	// Sources are the directories backed up, each stored below its logical
+	// name in the archive
+	Sources []Source
+
+	// Incremental backups: a full backup (level 0) at least every
+	// FullBackupDays and incrementals up to level IncrementalLevels in
+	// between, each storing the changes since the last backup of a lower
+	// level. FullBackupDays 0 makes every backup a full one.
+	FullBackupDays    int
+	IncrementalLevels int    // 1 bases every incremental on the last full backup
+	StateDir          string // Snapshots per level and volume, relative to BackupDir
+
+	// Backend selects the storage format: "archive" writes one archive per
+	// run, "repository" stores deduplicated chunks and a snapshot per run
+	// in RepositoryDir, relative to BackupDir
+	Backend       string
+	RepositoryDir string
+
+	// Resource usage of backup runs, so other services on the host stay
+	// responsive. IOPriorityClass is "idle", "best-effort" or "realtime",
+	// the latter two with IOPriorityLevel 0 (highest) to 7; empty keeps the
+	// inherited I/O priority. Nice 0 keeps the inherited CPU priority.
+	IOPriorityClass string
+	IOPriorityLevel int
+	Nice            int
+	ReadLimitMB     int64 // Source read bandwidth cap in MB/s, 0 is unlimited
+	DropPageCache   bool  // Evict archived files from the page cache
+}
+
+// Source is a directory to back up. Exactly one of Volume, Path and
+// Container locates it on the host.
+type Source struct {
+	Name string // Logical root inside the archive, e.g. "media"
+
+	Volume        string // Docker volume, read at its mount point
+	Path          string // Absolute host directory, e.g. the host side of a bind mount
+	Container     string // Container with a volume or bind mount holding ContainerPath
+	ContainerPath string // Absolute directory inside Container
+
+	// Optional sources that cannot be found are skipped with a warning,
+	// required ones fail the backup
+	Optional bool
+
+	// Filter holds include and exclude patterns relative to the source root
+	Filter Filter
+
+	// ReadErrors is the policy for files that vanish or change while they
+	// are read: "strict" (the default) fails the backup, "tolerant" skips
+	// vanished files and re-reads or marks changed ones
+	ReadErrors string
+}
+
+// Filter selects the content archived from a source. Patterns are relative
+// to the source root; "**" matches any number of directories.
+type Filter struct {
+	Include []string // When set, only matching paths are archived
+	Exclude []string // Matching paths are skipped, directories are not descended
 }
 
 // Default returns a Config with default values
 func Default() *Config {
 	return &Config{
-		BackupDir:        "/var/local/paperless-ngx/backups",
-		LogFile:          "backup.log",
-		LockFile:         "backup.lock",
-		MaxBackupAgeDays: 3,
-		RequiredSpaceMB:  10000,
-		PaperlessService: "paperless-ngx.service",
-		DataVolume:       "paperless-ngx_data",
-		MediaVolume:      "paperless-ngx_media",
-		RedisVolume:      "paperless-ngx_redisdata",
+		BackupDir:          "/var/local/paperless-ngx/backups",
+		LogFile:            "backup.log",
+		LockFile:           "backup.lock",
+		LockWait:           0,
+		MaxBackupAgeDays:   3,
+		RequiredSpaceMB:    10000,
+		PaperlessService:   "paperless-ngx.service",
+		DeepVerify:         true,
+		Compression:        "gzip",
+		CompressionLevel:   0,
+		CompressionWorkers: 0,
+		ProgressInterval:   30,
+		PreserveMetadata:   false,
+		MaxPartSizeMB:      0,
+		ParityPercent:      0,
+		FullBackupDays:     0,
+		IncrementalLevels:  1,
+		StateDir:           "state",
+		Backend:            "archive",
+		RepositoryDir:      "repository",
+		IOPriorityClass:    "",
+		IOPriorityLevel:    4,
+		Nice:               0,
+		ReadLimitMB:        0,
+		DropPageCache:      true,
+		Sources: []Source{
+			{Name: "data", Volume: "paperless-ngx_data"},
+			{Name: "media", Volume: "paperless-ngx_media"},
+			{Name: "redis", Volume: "paperless-ngx_redisdata"},
+		},
 	}
 }
-
@@ -15,12 +15,30 @@ func TestDefaultConfig(t *testing.T) {
 		{"BackupDir", cfg.BackupDir, "/var/local/paperless-ngx/backups"},
 		{"LogFile", cfg.LogFile, "backup.log"},
 		{"LockFile", cfg.LockFile, "backup.lock"},
+		{"LockWait", cfg.LockWait, 0},
 		{"MaxBackupAgeDays", cfg.MaxBackupAgeDays, 3},
 		{"RequiredSpaceMB", cfg.RequiredSpaceMB, int64(10000)},
 		{"PaperlessService", cfg.PaperlessService, "paperless-ngx.service"},
-		{"DataVolume", cfg.DataVolume, "paperless-ngx_data"},
-		{"MediaVolume", cfg.MediaVolume, "paperless-ngx_media"},
-		{"RedisVolume", cfg.RedisVolume, "paperless-ngx_redisdata"},
+		{"DeepVerify", cfg.DeepVerify, true},
+		{"Compression", cfg.Compression, "gzip"},
+		{"CompressionLevel", cfg.CompressionLevel, 0},
+		{"CompressionWorkers", cfg.CompressionWorkers, 0},
+		{"EncryptionPassphraseFile", cfg.EncryptionPassphraseFile, ""},
+		{"EncryptionIdentityFile", cfg.EncryptionIdentityFile, ""},
+		{"PreserveMetadata", cfg.PreserveMetadata, false},
+		{"MaxPartSizeMB", cfg.MaxPartSizeMB, int64(0)},
+		{"ParityPercent", cfg.ParityPercent, 0},
+		{"FullBackupDays", cfg.FullBackupDays, 0},
+		{"IncrementalLevels", cfg.IncrementalLevels, 1},
+		{"StateDir", cfg.StateDir, "state"},
+		{"ProgressInterval", cfg.ProgressInterval, 30},
+		{"Backend", cfg.Backend, "archive"},
+		{"RepositoryDir", cfg.RepositoryDir, "repository"},
+		{"IOPriorityClass", cfg.IOPriorityClass, ""},
+		{"IOPriorityLevel", cfg.IOPriorityLevel, 4},
+		{"Nice", cfg.Nice, 0},
+		{"ReadLimitMB", cfg.ReadLimitMB, int64(0)},
+		{"DropPageCache", cfg.DropPageCache, true},
 	}
 
 	for _, tt := range tests {
@@ -30,6 +48,29 @@ func TestDefaultConfig(t *testing.T) {
 			}
 		})
 	}
+
+	if len(cfg.EncryptionRecipients) != 0 {
+		t.Errorf("EncryptionRecipients = %v, want none", cfg.EncryptionRecipients)
+	}
+
+	// The volumes of the paperless-ngx docker compose setup, unfiltered
+	// and strict
+	volumes := map[string]string{
+		"data":  "paperless-ngx_data",
+		"media": "paperless-ngx_media",
+		"redis": "paperless-ngx_redisdata",
+	}
+	if len(cfg.Sources) != len(volumes) {
+		t.Fatalf("Sources = %v, want %d", cfg.Sources, len(volumes))
+	}
+	for _, source := range cfg.Sources {
+		if source.Volume != volumes[source.Name] || source.Path != "" || source.Container != "" || source.Optional {
+			t.Errorf("Unexpected default source %+v", source)
+		}
+		if len(source.Filter.Include) != 0 || len(source.Filter.Exclude) != 0 || source.ReadErrors != "" {
+			t.Errorf("Default source %s should be unfiltered and strict: %+v", source.Name, source)
+		}
+	}
 }
 
 func TestConfigValuesReasonable(t *testing.T) {
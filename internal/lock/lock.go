@@ -0,0 +1,181 @@
+package lock
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// bootIDPath identifies the current boot, telling a holder lost in a reboot
+// from one that crashed
+const bootIDPath = "/proc/sys/kernel/random/boot_id"
+
+// pollInterval is the time between attempts while waiting for a lock
+const pollInterval = time.Second
+
+// ErrHeld is returned when a live process holds the lock
+var ErrHeld = errors.New("lock held by another process")
+
+// Info identifies the process holding a lock. It is written to the lock
+// file for diagnosis; the lock itself is the flock on the file.
+type Info struct {
+	PID     int       `json:"pid"`
+	BootID  string    `json:"boot_id"`
+	Started time.Time `json:"started"`
+}
+
+// String describes the holder for log and error messages
+func (i *Info) String() string {
+	return fmt.Sprintf("PID %d, started %s", i.PID, i.Started.Format("2006-01-02 15:04:05"))
+}
+
+// SameBoot reports whether the holder was started since the last reboot
+func (i *Info) SameBoot() bool {
+	return i.BootID != "" && i.BootID == bootID()
+}
+
+// Lock is an exclusive advisory lock on a file, held until Release or the
+// end of the process. A crashed holder never blocks later runs: the kernel
+// drops its flock, and the recorded PID is only used for messages.
+type Lock struct {
+	path string
+	file *os.File
+
+	// Stale is the holder recorded in a lock file left behind by a run
+	// that ended without releasing it, nil when there was none
+	Stale *Info
+}
+
+// Acquire takes the lock at path. When another process holds it, Acquire
+// retries for up to wait and then fails with ErrHeld; 0 fails immediately.
+// Cancelling ctx stops waiting.
+func Acquire(ctx context.Context, path string, wait time.Duration) (*Lock, error) {
+	deadline := time.Now().Add(wait)
+	for {
+		lock, holder, err := tryAcquire(path)
+		if err != nil || lock != nil {
+			return lock, err
+		}
+		if !time.Now().Before(deadline) {
+			if holder == nil {
+				return nil, fmt.Errorf("%w (lock file: %s)", ErrHeld, path)
+			}
+			return nil, fmt.Errorf("%w (%s, lock file: %s)", ErrHeld, holder, path)
+		}
+		timer := time.NewTimer(min(pollInterval, time.Until(deadline)))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// tryAcquire takes the lock without blocking. A held lock returns neither
+// a lock nor an error, but the recorded holder if it can be read.
+func tryAcquire(path string) (*Lock, *Info, error) {
+	for {
+		file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to open lock file: %w", err)
+		}
+
+		if err := unix.Flock(int(file.Fd()), unix.LOCK_EX|unix.LOCK_NB); err != nil {
+			holder, _ := readInfo(file)
+			file.Close()
+			if errors.Is(err, unix.EWOULDBLOCK) {
+				return nil, holder, nil
+			}
+			return nil, nil, fmt.Errorf("failed to lock %s: %w", path, err)
+		}
+
+		// The previous holder may have removed the file between our open
+		// and flock; the lock is only valid on the file at path
+		if !samePath(file, path) {
+			file.Close()
+			continue
+		}
+
+		// A holder recorded in a file nobody holds the flock on is gone
+		lock := &Lock{path: path, file: file}
+		lock.Stale, _ = readInfo(file)
+		if err := lock.record(); err != nil {
+			file.Close()
+			return nil, nil, err
+		}
+		return lock, nil, nil
+	}
+}
+
+// samePath reports whether file is still the file at path
+func samePath(file *os.File, path string) bool {
+	opened, err := file.Stat()
+	if err != nil {
+		return false
+	}
+	current, err := os.Stat(path)
+	return err == nil && os.SameFile(opened, current)
+}
+
+// readInfo reads the holder recorded in a lock file, nil when it is empty
+// or was written by a version that recorded nothing
+func readInfo(file *os.File) (*Info, error) {
+	content, err := io.ReadAll(io.NewSectionReader(file, 0, 4096))
+	if err != nil || len(strings.TrimSpace(string(content))) == 0 {
+		return nil, err
+	}
+	info := &Info{}
+	if err := json.Unmarshal(content, info); err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+// record writes the holder information of this process to the lock file
+func (l *Lock) record() error {
+	content, err := json.Marshal(&Info{PID: os.Getpid(), BootID: bootID(), Started: time.Now()})
+	if err != nil {
+		return err
+	}
+	if err := l.file.Truncate(0); err != nil {
+		return fmt.Errorf("failed to write lock file: %w", err)
+	}
+	if _, err := l.file.WriteAt(append(content, '\n'), 0); err != nil {
+		return fmt.Errorf("failed to write lock file: %w", err)
+	}
+	return l.file.Sync()
+}
+
+// Release removes the lock file and drops the lock. It is safe to call
+// more than once.
+func (l *Lock) Release() error {
+	if l == nil || l.file == nil {
+		return nil
+	}
+	// Removing before unlocking keeps waiting processes from locking a
+	// file that is about to disappear
+	err := os.Remove(l.path)
+	l.file.Close()
+	l.file = nil
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to remove lock file: %w", err)
+	}
+	return nil
+}
+
+// bootID returns the ID of the running boot, empty when unknown
+func bootID() string {
+	content, err := os.ReadFile(bootIDPath)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(content))
+}
@@ -0,0 +1,119 @@
+package lock
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAcquire(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "backup.lock")
+
+	lock, err := Acquire(context.Background(), path, 0)
+	if err != nil {
+		t.Fatalf("Acquire failed: %v", err)
+	}
+	if lock.Stale != nil {
+		t.Errorf("Fresh lock should not be stale: %+v", lock.Stale)
+	}
+
+	content, _ := os.ReadFile(path)
+	info := &Info{}
+	if err := json.Unmarshal(content, info); err != nil {
+		t.Fatalf("Lock file should hold the holder: %v", err)
+	}
+	if info.PID != os.Getpid() || info.Started.IsZero() {
+		t.Errorf("Unexpected holder: %+v", info)
+	}
+	if bootID() != "" && !info.SameBoot() {
+		t.Error("Holder should be from this boot")
+	}
+
+	// The flock is held per open file, so a second open conflicts
+	_, err = Acquire(context.Background(), path, 0)
+	if !errors.Is(err, ErrHeld) || !strings.Contains(err.Error(), "PID "+strconv.Itoa(os.Getpid())) {
+		t.Errorf("Expected ErrHeld naming the holder, got %v", err)
+	}
+
+	if err := lock.Release(); err != nil {
+		t.Fatalf("Release failed: %v", err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Error("Lock file should be removed")
+	}
+	if err := lock.Release(); err != nil {
+		t.Errorf("Second Release failed: %v", err)
+	}
+}
+
+func TestAcquireWait(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "backup.lock")
+	lock, err := Acquire(context.Background(), path, 0)
+	if err != nil {
+		t.Fatalf("Acquire failed: %v", err)
+	}
+
+	// A short wait gives up
+	start := time.Now()
+	if _, err := Acquire(context.Background(), path, 200*time.Millisecond); !errors.Is(err, ErrHeld) {
+		t.Errorf("Expected ErrHeld, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 200*time.Millisecond {
+		t.Errorf("Acquire gave up after %s", elapsed)
+	}
+
+	// Cancelling stops waiting
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	if _, err := Acquire(ctx, path, time.Minute); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+
+	// A longer one gets the lock once it is released
+	time.AfterFunc(100*time.Millisecond, func() { lock.Release() })
+	next, err := Acquire(context.Background(), path, 5*time.Second)
+	if err != nil {
+		t.Fatalf("Acquire should succeed after release: %v", err)
+	}
+	defer next.Release()
+	if next.Stale != nil {
+		t.Errorf("Released lock should not be stale: %+v", next.Stale)
+	}
+}
+
+func TestAcquireStale(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	// A holder that crashed, or ran before a reboot
+	path := filepath.Join(tmpDir, "backup.lock")
+	started := time.Date(2024, 1, 1, 3, 0, 0, 0, time.UTC)
+	content, _ := json.Marshal(&Info{PID: 4242, BootID: "previous-boot", Started: started})
+	os.WriteFile(path, content, 0644)
+
+	lock, err := Acquire(context.Background(), path, 0)
+	if err != nil {
+		t.Fatalf("Acquire should recover a stale lock: %v", err)
+	}
+	defer lock.Release()
+	if lock.Stale == nil || lock.Stale.PID != 4242 || !lock.Stale.Started.Equal(started) || lock.Stale.SameBoot() {
+		t.Errorf("Unexpected stale holder: %+v", lock.Stale)
+	}
+
+	// Empty lock files of earlier versions are taken over silently
+	legacy := filepath.Join(tmpDir, "legacy.lock")
+	os.WriteFile(legacy, []byte{}, 0644)
+	lock, err = Acquire(context.Background(), legacy, 0)
+	if err != nil {
+		t.Fatalf("Acquire should take over an empty lock file: %v", err)
+	}
+	defer lock.Release()
+	if lock.Stale != nil {
+		t.Errorf("Empty lock file has no holder: %+v", lock.Stale)
+	}
+}
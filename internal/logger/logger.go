@@ -42,16 +42,9 @@ func (l *Logger) Logf(level, format string, args ...interface{}) {
 	l.Log(level, fmt.Sprintf(format, args...))
 }
 
-// ErrorExit logs an error and exits
-func (l *Logger) ErrorExit(message string) {
-	l.Log("ERROR", message)
-	os.Exit(1)
-}
-
 // Close closes the log file handle
 func (l *Logger) Close() {
 	if l.fileHandle != nil {
 		l.fileHandle.Close()
 	}
 }
-
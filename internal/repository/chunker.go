@@ -0,0 +1,127 @@
+package repository
+
+import (
+	"errors"
+	"io"
+)
+
+// ChunkerParams bound the size of content-defined chunks. They are stored
+// in the repository, as changing them would change every chunk boundary.
+type ChunkerParams struct {
+	MinSize int `json:"min_size"`
+	AvgBits int `json:"avg_bits"` // Average chunk size is 1<<AvgBits
+	MaxSize int `json:"max_size"`
+}
+
+// DefaultChunkerParams give chunks of 512KB to 8MB, 1MB on average
+var DefaultChunkerParams = ChunkerParams{MinSize: 512 << 10, AvgBits: 20, MaxSize: 8 << 20}
+
+// validate checks that the parameters describe a usable chunker
+func (p ChunkerParams) validate() error {
+	if p.MinSize <= 0 || p.AvgBits <= 0 || p.AvgBits > 30 || p.MaxSize < p.MinSize {
+		return errors.New("invalid chunker parameters")
+	}
+	return nil
+}
+
+// gear maps each byte to a pseudo-random value for the rolling hash. The
+// table is derived from a fixed seed so chunk boundaries never change
+// between versions.
+var gear = func() [256]uint64 {
+	var table [256]uint64
+	state := uint64(0x70617065726c6573) // "paperles"
+	for i := range table {
+		// splitmix64
+		state += 0x9e3779b97f4a7c15
+		z := state
+		z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+		z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+		table[i] = z ^ (z >> 31)
+	}
+	return table
+}()
+
+// chunker splits a stream at content-defined boundaries using a gear
+// rolling hash, so an insertion only changes the chunks around it
+type chunker struct {
+	reader io.Reader
+	params ChunkerParams
+	mask   uint64
+	buf    []byte
+	start  int // Offset of the unread data in buf
+	end    int // End of the data in buf
+	eof    bool
+}
+
+// newChunker returns a chunker reading from r
+func newChunker(r io.Reader, params ChunkerParams) *chunker {
+	return &chunker{
+		reader: r,
+		params: params,
+		// The top bits of the hash depend on the most bytes
+		mask: (uint64(1)<<params.AvgBits - 1) << (64 - params.AvgBits),
+		buf:  make([]byte, params.MaxSize),
+	}
+}
+
+// Next returns the next chunk, or io.EOF after the last one. The chunk is
+// only valid until the following call.
+func (c *chunker) Next() ([]byte, error) {
+	if err := c.fill(); err != nil {
+		return nil, err
+	}
+	if c.start == c.end {
+		return nil, io.EOF
+	}
+
+	data := c.buf[c.start:c.end]
+	cut := c.cutPoint(data)
+	c.start += cut
+	return data[:cut], nil
+}
+
+// fill reads until the buffer holds MaxSize bytes or the stream ended
+func (c *chunker) fill() error {
+	if c.eof || c.end-c.start >= c.params.MaxSize {
+		return nil
+	}
+
+	// Move the remainder to the front
+	c.end = copy(c.buf, c.buf[c.start:c.end])
+	c.start = 0
+
+	for c.end < len(c.buf) {
+		n, err := c.reader.Read(c.buf[c.end:])
+		c.end += n
+		if err == io.EOF {
+			c.eof = true
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// cutPoint returns the length of the chunk at the start of data
+func (c *chunker) cutPoint(data []byte) int {
+	if len(data) <= c.params.MinSize {
+		return len(data)
+	}
+	limit := len(data)
+	if limit > c.params.MaxSize {
+		limit = c.params.MaxSize
+	}
+
+	// Boundaries below the minimum size are never used, so hashing starts
+	// just before it
+	var hash uint64
+	for i := max(c.params.MinSize-64, 0); i < limit; i++ {
+		hash = hash<<1 + gear[data[i]]
+		if i >= c.params.MinSize && hash&c.mask == 0 {
+			return i + 1
+		}
+	}
+	return limit
+}
@@ -0,0 +1,80 @@
+package repository
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"testing"
+)
+
+// testParams keep chunks small so tests need little data
+var testParams = ChunkerParams{MinSize: 1 << 10, AvgBits: 12, MaxSize: 16 << 10}
+
+func splitChunks(t *testing.T, data []byte, params ChunkerParams) [][]byte {
+	t.Helper()
+
+	var chunks [][]byte
+	c := newChunker(bytes.NewReader(data), params)
+	for {
+		chunk, err := c.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Next failed: %v", err)
+		}
+		chunks = append(chunks, append([]byte(nil), chunk...))
+	}
+	return chunks
+}
+
+func TestChunkerBounds(t *testing.T) {
+	data := make([]byte, 1<<20)
+	rand.New(rand.NewSource(1)).Read(data)
+
+	chunks := splitChunks(t, data, testParams)
+	if !bytes.Equal(bytes.Join(chunks, nil), data) {
+		t.Fatal("Chunks do not reassemble to the input")
+	}
+	for i, chunk := range chunks {
+		if len(chunk) > testParams.MaxSize {
+			t.Errorf("Chunk %d exceeds the maximum: %d", i, len(chunk))
+		}
+		if len(chunk) < testParams.MinSize && i != len(chunks)-1 {
+			t.Errorf("Chunk %d is below the minimum: %d", i, len(chunk))
+		}
+	}
+
+	// Roughly 1<<AvgBits on average, well below the maximum
+	if average := len(data) / len(chunks); average < 2<<10 || average > 12<<10 {
+		t.Errorf("Unexpected average chunk size %d", average)
+	}
+
+	// Without content there are no chunks
+	if chunks := splitChunks(t, nil, testParams); len(chunks) != 0 {
+		t.Errorf("Expected no chunks for empty input, got %d", len(chunks))
+	}
+}
+
+func TestChunkerResynchronizes(t *testing.T) {
+	data := make([]byte, 512<<10)
+	rand.New(rand.NewSource(2)).Read(data)
+
+	// An insertion near the start only changes the chunks around it
+	shifted := append([]byte("inserted"), data...)
+
+	original := make(map[string]bool)
+	for _, chunk := range splitChunks(t, data, testParams) {
+		original[string(chunk)] = true
+	}
+	chunks := splitChunks(t, shifted, testParams)
+	var shared int
+	for _, chunk := range chunks {
+		if original[string(chunk)] {
+			shared++
+		}
+	}
+	if shared < len(chunks)-3 {
+		t.Errorf("Only %d of %d chunks survived an insertion", shared, len(chunks))
+	}
+}
@@ -0,0 +1,128 @@
+package repository
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"paperless-backup/internal/archive"
+)
+
+// Prune removes snapshots older than maxAge, always keeping the newest one.
+// The chunks they referenced stay until GC runs.
+func (r *Repository) Prune(maxAge time.Duration) ([]string, error) {
+	ids, err := r.Snapshots()
+	if err != nil {
+		return nil, err
+	}
+	if len(ids) <= 1 {
+		return nil, nil
+	}
+
+	cutoff := now().Add(-maxAge)
+	var removed []string
+	for _, id := range ids[:len(ids)-1] {
+		snapshot, err := r.LoadSnapshot(id)
+		if err != nil {
+			return removed, err
+		}
+		if !snapshot.Time.Before(cutoff) {
+			continue
+		}
+		if err := os.Remove(r.snapshotPath(id)); err != nil {
+			return removed, fmt.Errorf("failed to remove snapshot %s: %w", id, err)
+		}
+		removed = append(removed, id)
+	}
+	if len(removed) > 0 {
+		if err := syncDir(filepath.Join(r.path, snapshotsDir)); err != nil {
+			return removed, err
+		}
+	}
+	return removed, nil
+}
+
+// GCResult summarizes a garbage collection
+type GCResult struct {
+	Objects    int   // Objects still referenced
+	Removed    int   // Unreferenced objects deleted
+	FreedBytes int64 // Size of the deleted objects
+}
+
+// GC deletes all objects no snapshot references, including leftovers of
+// interrupted backups. It must not run concurrently with Backup. Nothing is
+// deleted unless every snapshot could be read completely.
+func (r *Repository) GC() (*GCResult, error) {
+	ids, err := r.Snapshots()
+	if err != nil {
+		return nil, err
+	}
+
+	// Mark
+	referenced := make(map[string]bool)
+	for _, id := range ids {
+		snapshot, err := r.LoadSnapshot(id)
+		if err != nil {
+			return nil, err
+		}
+		for _, source := range snapshot.Sources {
+			if err := r.mark(source.Root.Subtree, referenced); err != nil {
+				return nil, fmt.Errorf("snapshot %s is damaged, not collecting garbage: %w", id, err)
+			}
+		}
+	}
+
+	// Sweep
+	result := &GCResult{Objects: len(referenced)}
+	objectsRoot := filepath.Join(r.path, objectsDir)
+	err = filepath.Walk(objectsRoot, func(objectPath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		if referenced[info.Name()] && !archive.IsPartialName(info.Name()) {
+			return nil
+		}
+		if err := os.Remove(objectPath); err != nil {
+			return err
+		}
+		result.Removed++
+		result.FreedBytes += info.Size()
+		return nil
+	})
+	if err != nil {
+		return result, fmt.Errorf("failed to remove unreferenced objects: %w", err)
+	}
+	return result, nil
+}
+
+// mark adds a tree and everything it references to referenced
+func (r *Repository) mark(treeID string, referenced map[string]bool) error {
+	if referenced[treeID] {
+		// Shared subtrees are only walked once
+		return nil
+	}
+	tree, err := r.loadTree(treeID)
+	if err != nil {
+		return err
+	}
+	referenced[treeID] = true
+
+	for _, node := range tree.Nodes {
+		for _, id := range node.Content {
+			if !r.hasObject(id) {
+				return fmt.Errorf("chunk %s of %s is missing", id, node.Name)
+			}
+			referenced[id] = true
+		}
+		if node.Type == archive.EntryDir {
+			if err := r.mark(node.Subtree, referenced); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,95 @@
+package repository
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"paperless-backup/internal/archive"
+)
+
+func TestPruneAndGC(t *testing.T) {
+	tmpDir := t.TempDir()
+	r := openTestRepository(t, filepath.Join(tmpDir, "repository"))
+
+	sourceDir := filepath.Join(tmpDir, "source")
+	os.MkdirAll(sourceDir, 0755)
+	sources := []archive.Source{{Name: "media", Path: sourceDir}}
+
+	os.WriteFile(filepath.Join(sourceDir, "deleted.pdf"), randomContent(1, 50<<10), 0644)
+	os.WriteFile(filepath.Join(sourceDir, "kept.pdf"), randomContent(2, 50<<10), 0644)
+	old, err := r.Backup(context.Background(), sources)
+	if err != nil {
+		t.Fatalf("Backup failed: %v", err)
+	}
+	os.Remove(filepath.Join(sourceDir, "deleted.pdf"))
+	if _, err := r.Backup(context.Background(), sources); err != nil {
+		t.Fatalf("Backup failed: %v", err)
+	}
+
+	// Leftover of an interrupted backup
+	os.MkdirAll(filepath.Join(r.path, objectsDir, "ab"), 0700)
+	os.WriteFile(filepath.Join(r.path, objectsDir, "ab", "abcd.partial"), []byte("partial"), 0600)
+
+	// Nothing is unreferenced while both snapshots exist
+	result, err := r.GC()
+	if err != nil {
+		t.Fatalf("GC failed: %v", err)
+	}
+	if result.Removed != 1 {
+		t.Errorf("Only the partial object should be removed: %+v", result)
+	}
+
+	// The test clock advances a minute per snapshot
+	removed, err := r.Prune(time.Minute)
+	if err != nil || len(removed) != 1 || removed[0] != old.ID {
+		t.Fatalf("Prune = %v, %v", removed, err)
+	}
+	result, err = r.GC()
+	if err != nil {
+		t.Fatalf("GC failed: %v", err)
+	}
+	if result.Removed == 0 || result.FreedBytes == 0 {
+		t.Errorf("Chunks of the deleted file should be removed: %+v", result)
+	}
+
+	// The remaining snapshot is complete
+	if _, err := r.Restore("", filepath.Join(tmpDir, "bad"), RestoreOptions{}); err == nil {
+		t.Error("Expected error for an empty snapshot ID")
+	}
+	id, _ := r.ResolveSnapshot("latest")
+	restored, err := r.Restore(id, filepath.Join(tmpDir, "restore"), RestoreOptions{})
+	if err != nil || restored.Files != 1 {
+		t.Errorf("Restore after GC = %+v, %v", restored, err)
+	}
+
+	// The newest snapshot is always kept
+	if removed, _ := r.Prune(0); len(removed) != 0 {
+		t.Errorf("Newest snapshot was pruned: %v", removed)
+	}
+}
+
+func TestGCRefusesDamagedSnapshots(t *testing.T) {
+	tmpDir := t.TempDir()
+	r := openTestRepository(t, filepath.Join(tmpDir, "repository"))
+
+	sourceDir := filepath.Join(tmpDir, "source")
+	os.MkdirAll(sourceDir, 0755)
+	os.WriteFile(filepath.Join(sourceDir, "a.pdf"), randomContent(1, 50<<10), 0644)
+	snapshot, err := r.Backup(context.Background(), []archive.Source{{Name: "media", Path: sourceDir}})
+	if err != nil {
+		t.Fatalf("Backup failed: %v", err)
+	}
+
+	// A lost tree must not cause the chunks it referenced to be deleted
+	os.Remove(r.objectPath(snapshot.Sources[0].Root.Subtree))
+	if _, err := r.GC(); err == nil {
+		t.Error("Expected GC to refuse a damaged snapshot")
+	}
+	entries, _ := os.ReadDir(filepath.Join(r.path, objectsDir))
+	if len(entries) == 0 {
+		t.Error("No objects should be deleted")
+	}
+}
@@ -0,0 +1,313 @@
+package repository
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"paperless-backup/internal/archive"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Repository layout below its root directory
+const (
+	configName   = "config.json"
+	objectsDir   = "objects"   // Compressed chunks and trees, by SHA-256 of their content
+	snapshotsDir = "snapshots" // One JSON file per backup
+	version      = 1
+)
+
+// SnapshotIDFormat names snapshots by their creation time
+const SnapshotIDFormat = "20060102_150405"
+
+// now returns the time of new snapshots, replaced in tests
+var now = time.Now
+
+// ErrNoSnapshot is returned when a requested snapshot does not exist
+var ErrNoSnapshot = errors.New("snapshot not found")
+
+// Options configures a Repository
+type Options struct {
+	CompressionLevel int  // zstd level, 0 selects the default
+	Verify           bool // Re-read new objects after a backup
+}
+
+// repositoryConfig is stored in the repository root
+type repositoryConfig struct {
+	Version int           `json:"version"`
+	Chunker ChunkerParams `json:"chunker"`
+}
+
+// Repository stores backups as snapshots of content-defined chunks. Each
+// chunk is stored once, compressed, under the hash of its content, so files
+// shared between snapshots take space only once.
+type Repository struct {
+	path    string
+	options Options
+	config  repositoryConfig
+	encoder *zstd.Encoder
+	decoder *zstd.Decoder
+	dirty   map[string]bool // Object directories with unsynced entries
+}
+
+// Open opens the repository at path, creating it if it does not exist
+func Open(path string, options Options) (*Repository, error) {
+	for _, dir := range []string{objectsDir, snapshotsDir} {
+		if err := os.MkdirAll(filepath.Join(path, dir), 0700); err != nil {
+			return nil, fmt.Errorf("failed to create repository: %w", err)
+		}
+	}
+
+	r := &Repository{path: path, options: options, dirty: make(map[string]bool)}
+
+	content, err := os.ReadFile(filepath.Join(path, configName))
+	switch {
+	case os.IsNotExist(err):
+		r.config = repositoryConfig{Version: version, Chunker: DefaultChunkerParams}
+		content, _ := json.MarshalIndent(r.config, "", "  ")
+		if err := writeFileAtomic(filepath.Join(path, configName), content); err != nil {
+			return nil, fmt.Errorf("failed to write repository config: %w", err)
+		}
+	case err != nil:
+		return nil, fmt.Errorf("failed to read repository config: %w", err)
+	default:
+		if err := json.Unmarshal(content, &r.config); err != nil {
+			return nil, fmt.Errorf("invalid repository config: %w", err)
+		}
+	}
+	if r.config.Version != version {
+		return nil, fmt.Errorf("unsupported repository version %d", r.config.Version)
+	}
+	if err := r.config.Chunker.validate(); err != nil {
+		return nil, err
+	}
+
+	level := zstd.SpeedDefault
+	if options.CompressionLevel > 0 {
+		level = zstd.EncoderLevelFromZstd(options.CompressionLevel)
+	}
+	if r.encoder, err = zstd.NewWriter(nil, zstd.WithEncoderLevel(level)); err != nil {
+		return nil, err
+	}
+	if r.decoder, err = zstd.NewReader(nil); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Path returns the root directory of the repository
+func (r *Repository) Path() string {
+	return r.path
+}
+
+// objectPath returns where an object is stored
+func (r *Repository) objectPath(id string) string {
+	return filepath.Join(r.path, objectsDir, id[:2], id)
+}
+
+// hasObject reports whether an object is stored
+func (r *Repository) hasObject(id string) bool {
+	_, err := os.Stat(r.objectPath(id))
+	return err == nil
+}
+
+// saveObject stores content unless an object with the same hash exists. It
+// returns the object ID and the number of bytes newly written.
+func (r *Repository) saveObject(content []byte) (string, int64, error) {
+	sum := sha256.Sum256(content)
+	id := hex.EncodeToString(sum[:])
+	if r.hasObject(id) {
+		return id, 0, nil
+	}
+
+	objectPath := r.objectPath(id)
+	if err := os.MkdirAll(filepath.Dir(objectPath), 0700); err != nil {
+		return "", 0, fmt.Errorf("failed to store object: %w", err)
+	}
+	compressed := r.encoder.EncodeAll(content, nil)
+	if err := writeFile(objectPath, compressed); err != nil {
+		return "", 0, fmt.Errorf("failed to store object %s: %w", id, err)
+	}
+	r.dirty[filepath.Dir(objectPath)] = true
+	return id, int64(len(compressed)), nil
+}
+
+// loadObject reads an object and checks its content against its ID
+func (r *Repository) loadObject(id string) ([]byte, error) {
+	if len(id) != sha256.Size*2 {
+		return nil, fmt.Errorf("invalid object ID %q", id)
+	}
+	compressed, err := os.ReadFile(r.objectPath(id))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read object: %w", err)
+	}
+	content, err := r.decoder.DecodeAll(compressed, nil)
+	if err != nil {
+		return nil, fmt.Errorf("object %s is corrupt: %w", id, err)
+	}
+	if sum := sha256.Sum256(content); hex.EncodeToString(sum[:]) != id {
+		return nil, fmt.Errorf("object %s is corrupt: hash mismatch", id)
+	}
+	return content, nil
+}
+
+// syncObjects makes all objects written so far durable. It must be called
+// before a snapshot referencing them is saved.
+func (r *Repository) syncObjects() error {
+	for dir := range r.dirty {
+		if err := syncDir(dir); err != nil {
+			return fmt.Errorf("failed to sync %s: %w", dir, err)
+		}
+	}
+	if len(r.dirty) > 0 {
+		if err := syncDir(filepath.Join(r.path, objectsDir)); err != nil {
+			return err
+		}
+	}
+	r.dirty = make(map[string]bool)
+	return nil
+}
+
+// Snapshots returns the IDs of all snapshots, oldest first
+func (r *Repository) Snapshots() ([]string, error) {
+	entries, err := os.ReadDir(filepath.Join(r.path, snapshotsDir))
+	if err != nil {
+		return nil, err
+	}
+
+	var ids []string
+	for _, entry := range entries {
+		if id, ok := strings.CutSuffix(entry.Name(), ".json"); ok && !entry.IsDir() {
+			ids = append(ids, id)
+		}
+	}
+	// IDs are timestamps and sort lexically
+	sort.Strings(ids)
+	return ids, nil
+}
+
+// ResolveSnapshot turns "latest" into the ID of the newest snapshot and
+// checks that other IDs exist
+func (r *Repository) ResolveSnapshot(id string) (string, error) {
+	if id != "latest" {
+		if _, err := r.LoadSnapshot(id); err != nil {
+			return "", err
+		}
+		return id, nil
+	}
+
+	ids, err := r.Snapshots()
+	if err != nil {
+		return "", err
+	}
+	if len(ids) == 0 {
+		return "", fmt.Errorf("repository is empty: %w", ErrNoSnapshot)
+	}
+	return ids[len(ids)-1], nil
+}
+
+// snapshotPath returns where a snapshot is stored
+func (r *Repository) snapshotPath(id string) string {
+	return filepath.Join(r.path, snapshotsDir, id+".json")
+}
+
+// LoadSnapshot reads a snapshot
+func (r *Repository) LoadSnapshot(id string) (*Snapshot, error) {
+	if strings.ContainsAny(id, "/\\") || id == "" {
+		return nil, fmt.Errorf("invalid snapshot ID %q", id)
+	}
+	content, err := os.ReadFile(r.snapshotPath(id))
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("%s: %w", id, ErrNoSnapshot)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var snapshot Snapshot
+	if err := json.Unmarshal(content, &snapshot); err != nil {
+		return nil, fmt.Errorf("invalid snapshot %s: %w", id, err)
+	}
+	snapshot.ID = id
+	return &snapshot, nil
+}
+
+// saveSnapshot stores a snapshot under a new ID derived from its time
+func (r *Repository) saveSnapshot(snapshot *Snapshot) error {
+	snapshot.ID = snapshot.Time.Format(SnapshotIDFormat)
+	if _, err := os.Stat(r.snapshotPath(snapshot.ID)); err == nil {
+		return fmt.Errorf("snapshot %s already exists", snapshot.ID)
+	}
+
+	content, err := json.MarshalIndent(snapshot, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(r.snapshotPath(snapshot.ID), content); err != nil {
+		return fmt.Errorf("failed to write snapshot: %w", err)
+	}
+	return nil
+}
+
+// loadTree reads a directory listing
+func (r *Repository) loadTree(id string) (*Tree, error) {
+	content, err := r.loadObject(id)
+	if err != nil {
+		return nil, err
+	}
+	var tree Tree
+	if err := json.Unmarshal(content, &tree); err != nil {
+		return nil, fmt.Errorf("invalid tree %s: %w", id, err)
+	}
+	return &tree, nil
+}
+
+// writeFile writes a new file through a synced partial file
+func writeFile(path string, content []byte) error {
+	partialPath := archive.PartialPath(path)
+	file, err := os.OpenFile(partialPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(content); err != nil {
+		file.Close()
+		os.Remove(partialPath)
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		os.Remove(partialPath)
+		return err
+	}
+	if err := file.Close(); err != nil {
+		os.Remove(partialPath)
+		return err
+	}
+	return os.Rename(partialPath, path)
+}
+
+// writeFileAtomic writes a file and makes its directory entry durable
+func writeFileAtomic(path string, content []byte) error {
+	if err := writeFile(path, content); err != nil {
+		return err
+	}
+	return syncDir(filepath.Dir(path))
+}
+
+// syncDir flushes directory entries to disk
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
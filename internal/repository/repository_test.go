@@ -0,0 +1,288 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"paperless-backup/internal/archive"
+)
+
+// openTestRepository opens a repository with small chunks and a clock
+// advancing one minute per snapshot
+func openTestRepository(t *testing.T, dir string) *Repository {
+	t.Helper()
+
+	clock := time.Date(2024, 1, 1, 2, 0, 0, 0, time.Local)
+	now = func() time.Time {
+		clock = clock.Add(time.Minute)
+		return clock
+	}
+	t.Cleanup(func() { now = time.Now })
+
+	os.MkdirAll(dir, 0700)
+	config := `{"version":1,"chunker":{"min_size":1024,"avg_bits":12,"max_size":16384}}`
+	os.WriteFile(filepath.Join(dir, configName), []byte(config), 0600)
+
+	r, err := Open(dir, Options{Verify: true})
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	return r
+}
+
+func randomContent(seed int64, size int) []byte {
+	data := make([]byte, size)
+	rand.New(rand.NewSource(seed)).Read(data)
+	return data
+}
+
+func TestOpenCreatesRepository(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "repository")
+	if _, err := Open(dir, Options{}); err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	for _, name := range []string{configName, objectsDir, snapshotsDir} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Errorf("%s should be created: %v", name, err)
+		}
+	}
+
+	// Reopening keeps the stored chunker parameters
+	r, err := Open(dir, Options{})
+	if err != nil || r.config.Chunker != DefaultChunkerParams {
+		t.Errorf("Reopen failed: %+v, %v", r, err)
+	}
+
+	os.WriteFile(filepath.Join(dir, configName), []byte(`{"version":99}`), 0600)
+	if _, err := Open(dir, Options{}); err == nil {
+		t.Error("Expected error for an unknown repository version")
+	}
+}
+
+func TestBackupAndRestore(t *testing.T) {
+	tmpDir := t.TempDir()
+	r := openTestRepository(t, filepath.Join(tmpDir, "repository"))
+
+	sourceDir := filepath.Join(tmpDir, "source")
+	os.MkdirAll(filepath.Join(sourceDir, "documents", "thumbnails"), 0750)
+	os.WriteFile(filepath.Join(sourceDir, "documents", "0001.pdf"), randomContent(1, 100<<10), 0640)
+	os.WriteFile(filepath.Join(sourceDir, "documents", "empty.pdf"), nil, 0600)
+	os.WriteFile(filepath.Join(sourceDir, "documents", "thumbnails", "0001.webp"), []byte("thumb"), 0644)
+	os.WriteFile(filepath.Join(sourceDir, "zeros.db"), make([]byte, 64<<10), 0600)
+	os.Symlink("0001.pdf", filepath.Join(sourceDir, "documents", "latest.pdf"))
+	mtime := time.Date(2023, 5, 1, 12, 0, 0, 0, time.UTC)
+	os.Chtimes(filepath.Join(sourceDir, "documents", "0001.pdf"), mtime, mtime)
+
+	snapshot, err := r.Backup(context.Background(), []archive.Source{{
+		Name:   "media",
+		Path:   sourceDir,
+		Filter: archive.Filter{Exclude: []string{"**/thumbnails"}},
+	}})
+	if err != nil {
+		t.Fatalf("Backup failed: %v", err)
+	}
+	if total := snapshot.Total(); total.Files != 3 || total.Symlinks != 1 || total.Dirs != 1 {
+		t.Errorf("Unexpected stats: %+v", total)
+	}
+
+	dest := filepath.Join(tmpDir, "restore")
+	result, err := r.Restore(snapshot.ID, dest, RestoreOptions{})
+	if err != nil {
+		t.Fatalf("Restore failed: %v", err)
+	}
+	if result.Files != 3 || result.Symlinks != 1 || len(result.Warnings) != 0 {
+		t.Errorf("Unexpected result: %+v", result)
+	}
+
+	content, _ := os.ReadFile(filepath.Join(dest, "media", "documents", "0001.pdf"))
+	if !bytes.Equal(content, randomContent(1, 100<<10)) {
+		t.Error("Restored content differs")
+	}
+	info, err := os.Stat(filepath.Join(dest, "media", "documents", "0001.pdf"))
+	if err != nil || info.Mode().Perm() != 0640 || !info.ModTime().Equal(mtime) {
+		t.Errorf("Metadata not restored: %v, %v", info, err)
+	}
+	if info, _ := os.Stat(filepath.Join(dest, "media", "documents")); info == nil || info.Mode().Perm() != 0750 {
+		t.Errorf("Directory mode not restored: %v", info)
+	}
+	if target, _ := os.Readlink(filepath.Join(dest, "media", "documents", "latest.pdf")); target != "0001.pdf" {
+		t.Errorf("Symlink target = %q", target)
+	}
+	if info, _ := os.Stat(filepath.Join(dest, "media", "zeros.db")); info == nil || info.Size() != 64<<10 {
+		t.Errorf("Sparse file not restored: %v", info)
+	}
+	if _, err := os.Stat(filepath.Join(dest, "media", "documents", "thumbnails")); !os.IsNotExist(err) {
+		t.Error("Excluded directory should not be stored")
+	}
+
+	// Existing files are never overwritten
+	if _, err := r.Restore(snapshot.ID, dest, RestoreOptions{}); err == nil {
+		t.Error("Expected error when restoring over existing files")
+	}
+
+	// Patterns select single files
+	partial := filepath.Join(tmpDir, "partial")
+	id, err := r.ResolveSnapshot("latest")
+	if err != nil || id != snapshot.ID {
+		t.Fatalf("ResolveSnapshot = %q, %v", id, err)
+	}
+	result, err = r.Restore(id, partial, RestoreOptions{Patterns: []string{"media/documents/0001.pdf"}})
+	if err != nil || result.Files != 1 {
+		t.Fatalf("Partial restore failed: %+v, %v", result, err)
+	}
+	if _, err := os.Stat(filepath.Join(partial, "media", "zeros.db")); !os.IsNotExist(err) {
+		t.Error("Unselected file should not be restored")
+	}
+
+	entries, err := r.List(id, []string{"media/documents/*.pdf"})
+	if err != nil || len(entries) != 3 {
+		t.Errorf("List = %v, %v", entries, err)
+	}
+}
+
+func TestBackupDeduplicates(t *testing.T) {
+	tmpDir := t.TempDir()
+	r := openTestRepository(t, filepath.Join(tmpDir, "repository"))
+
+	sourceDir := filepath.Join(tmpDir, "source")
+	os.MkdirAll(sourceDir, 0755)
+	os.WriteFile(filepath.Join(sourceDir, "a.pdf"), randomContent(1, 200<<10), 0644)
+	sources := []archive.Source{{Name: "media", Path: sourceDir}}
+
+	first, err := r.Backup(context.Background(), sources)
+	if err != nil {
+		t.Fatalf("Backup failed: %v", err)
+	}
+
+	// A copy stores no new chunks, only the changed directory listing
+	os.WriteFile(filepath.Join(sourceDir, "copy.pdf"), randomContent(1, 200<<10), 0644)
+	second, err := r.Backup(context.Background(), sources)
+	if err != nil {
+		t.Fatalf("Backup failed: %v", err)
+	}
+	if total := second.Total(); total.NewObjects != 1 || total.Unchanged != 1 {
+		t.Errorf("Copy should only add a tree: %+v", total)
+	}
+	if second.Total().StoredBytes*10 > first.Total().StoredBytes {
+		t.Errorf("Second snapshot stored %d bytes, first %d", second.Total().StoredBytes, first.Total().StoredBytes)
+	}
+
+	// An unchanged source adds nothing
+	third, err := r.Backup(context.Background(), sources)
+	if err != nil {
+		t.Fatalf("Backup failed: %v", err)
+	}
+	if third.Total().NewObjects != 0 {
+		t.Errorf("Unchanged source stored %d objects", third.Total().NewObjects)
+	}
+
+	ids, _ := r.Snapshots()
+	if len(ids) != 3 || ids[2] != third.ID {
+		t.Errorf("Snapshots = %v", ids)
+	}
+}
+
+func TestBackupCancelled(t *testing.T) {
+	tmpDir := t.TempDir()
+	r := openTestRepository(t, filepath.Join(tmpDir, "repository"))
+
+	sourceDir := filepath.Join(tmpDir, "source")
+	os.MkdirAll(sourceDir, 0755)
+	os.WriteFile(filepath.Join(sourceDir, "a.pdf"), randomContent(1, 200<<10), 0644)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := r.Backup(ctx, []archive.Source{{Name: "media", Path: sourceDir}}); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected context.Canceled, got %v", err)
+	}
+	if ids, _ := r.Snapshots(); len(ids) != 0 {
+		t.Errorf("Cancelled backup should not save a snapshot: %v", ids)
+	}
+}
+
+func TestLoadObjectDetectsCorruption(t *testing.T) {
+	r := openTestRepository(t, filepath.Join(t.TempDir(), "repository"))
+
+	id, _, err := r.saveObject([]byte("document content"))
+	if err != nil {
+		t.Fatalf("saveObject failed: %v", err)
+	}
+	if content, err := r.loadObject(id); err != nil || string(content) != "document content" {
+		t.Fatalf("loadObject = %q, %v", content, err)
+	}
+
+	// A valid object stored under the wrong ID
+	other, _, _ := r.saveObject([]byte("other content"))
+	compressed, _ := os.ReadFile(r.objectPath(other))
+	os.WriteFile(r.objectPath(id), compressed, 0600)
+	if _, err := r.loadObject(id); err == nil {
+		t.Error("Expected error for a hash mismatch")
+	}
+
+	os.WriteFile(r.objectPath(id), []byte("garbage"), 0600)
+	if _, err := r.loadObject(id); err == nil {
+		t.Error("Expected error for a corrupt object")
+	}
+}
+
+func TestRestoreRefusesUnsafeTrees(t *testing.T) {
+	tmpDir := t.TempDir()
+	r := openTestRepository(t, filepath.Join(tmpDir, "repository"))
+
+	treeID, _, _ := r.saveObject([]byte(`{"nodes":[{"name":"../evil","type":"file","mode":420}]}`))
+	snapshot := &Snapshot{
+		Time:    now(),
+		Sources: []SnapshotSource{{Name: "media", Root: Node{Type: archive.EntryDir, Mode: 0755, Subtree: treeID}}},
+	}
+	if err := r.saveSnapshot(snapshot); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := r.Restore(snapshot.ID, filepath.Join(tmpDir, "restore"), RestoreOptions{})
+	if !errors.Is(err, archive.ErrUnsafePath) {
+		t.Errorf("Expected ErrUnsafePath, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(tmpDir, "evil")); !os.IsNotExist(err) {
+		t.Error("Nothing may be written outside the destination")
+	}
+}
+
+func TestSaveFileReadErrors(t *testing.T) {
+	r := openTestRepository(t, filepath.Join(t.TempDir(), "repository"))
+
+	// procfs reports size 0 for files with content, so every read looks
+	// like a change
+	strict := &walker{ctx: context.Background(), repository: r, source: archive.Source{Name: "proc"}}
+	var node Node
+	if err := strict.saveFile("/proc/self/status", "status", &node); !errors.Is(err, archive.ErrSourceChanged) {
+		t.Errorf("Expected ErrSourceChanged, got %v", err)
+	}
+
+	tolerant := &walker{ctx: context.Background(), repository: r, source: archive.Source{Name: "proc", ReadErrors: archive.ReadErrorsTolerant}}
+	node = Node{Inode: 1}
+	if err := tolerant.saveFile("/proc/self/status", "status", &node); err != nil {
+		t.Fatalf("saveFile failed: %v", err)
+	}
+	if node.Size == 0 || node.Inode != 0 || len(node.Content) == 0 {
+		t.Errorf("Changed file should be stored and marked: %+v", node)
+	}
+
+	err := tolerant.saveFile(filepath.Join(t.TempDir(), "vanished.log"), "vanished.log", &node)
+	if !tolerant.skipVanished("vanished.log", err) {
+		t.Errorf("Vanished file should be skipped: %v", err)
+	}
+
+	want := []archive.Warning{
+		{Path: "proc/status", Problem: archive.ProblemChanged},
+		{Path: "proc/vanished.log", Problem: archive.ProblemVanished},
+	}
+	if len(tolerant.warnings) != 2 || tolerant.warnings[0] != want[0] || tolerant.warnings[1] != want[1] {
+		t.Errorf("Warnings = %v, want %v", tolerant.warnings, want)
+	}
+}
@@ -0,0 +1,308 @@
+package repository
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"paperless-backup/internal/archive"
+
+	"golang.org/x/sys/unix"
+)
+
+// RestoreOptions configures Restore
+type RestoreOptions struct {
+	Patterns     []string // Restore only matching paths, as for archive extraction
+	RestoreOwner bool     // Restore ownership and setuid/setgid bits (requires root)
+}
+
+// RestoreResult summarizes a restore
+type RestoreResult struct {
+	Dirs     int
+	Files    int
+	Symlinks int
+	Bytes    int64
+	Warnings []string // Metadata that could not be restored
+}
+
+// restorer writes one snapshot below root
+type restorer struct {
+	repository *Repository
+	root       string
+	options    RestoreOptions
+	result     *RestoreResult
+	dirs       map[string]Node // Every directory seen, by path relative to root
+	created    []string        // Directories created, in creation order
+	createdSet map[string]bool
+}
+
+// Restore writes the content of a snapshot below destination, each source
+// under its name. Existing files are never overwritten and nothing is
+// written outside destination.
+func (r *Repository) Restore(id, destination string, options RestoreOptions) (*RestoreResult, error) {
+	patterns, err := archive.CleanPatterns(options.Patterns)
+	if err != nil {
+		return nil, err
+	}
+	options.Patterns = patterns
+
+	snapshot, err := r.LoadSnapshot(id)
+	if err != nil {
+		return nil, err
+	}
+
+	root, err := filepath.Abs(destination)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(root, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create destination: %w", err)
+	}
+
+	x := &restorer{
+		repository: r,
+		root:       root,
+		options:    options,
+		result:     &RestoreResult{},
+		dirs:       make(map[string]Node),
+		createdSet: map[string]bool{".": true},
+	}
+	for _, source := range snapshot.Sources {
+		if !validName(source.Name) || source.Root.Type != archive.EntryDir {
+			return x.result, fmt.Errorf("invalid source %q in snapshot", source.Name)
+		}
+		if err := x.restoreDir(source.Name, source.Root, false); err != nil {
+			return x.result, err
+		}
+	}
+
+	// Directory metadata last, deepest first, so file creation does not
+	// change the mtimes and read-only directories can be filled
+	for i := len(x.created) - 1; i >= 0; i-- {
+		x.applyMeta(x.created[i], x.dirs[x.created[i]])
+	}
+	return x.result, nil
+}
+
+// validName reports whether a node name is a single path segment
+func validName(name string) bool {
+	return name != "" && name != "." && name != ".." && !strings.Contains(name, "/")
+}
+
+// restoreDir restores a directory and its selected content. selected is set
+// when the directory or one of its parents matches the patterns.
+func (x *restorer) restoreDir(rel string, node Node, selected bool) error {
+	x.dirs[rel] = node
+	selected = selected || len(x.options.Patterns) == 0 || archive.MatchEntry(rel, x.options.Patterns)
+	if selected {
+		if err := x.mkdir(rel); err != nil {
+			return err
+		}
+	}
+
+	tree, err := x.repository.loadTree(node.Subtree)
+	if err != nil {
+		return fmt.Errorf("%s: %w", rel, err)
+	}
+
+	for _, child := range tree.Nodes {
+		if !validName(child.Name) {
+			return fmt.Errorf("%s: invalid name %q: %w", rel, child.Name, archive.ErrUnsafePath)
+		}
+		childRel := path.Join(rel, child.Name)
+
+		if child.Type == archive.EntryDir {
+			if err := x.restoreDir(childRel, child, selected); err != nil {
+				return err
+			}
+			continue
+		}
+		if !selected && !archive.MatchEntry(childRel, x.options.Patterns) {
+			continue
+		}
+		if err := x.mkdir(rel); err != nil {
+			return err
+		}
+		if err := x.restoreNode(childRel, child); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// mkdir creates a directory and its missing parents. Existing directories
+// are reused, symlinks are refused.
+func (x *restorer) mkdir(rel string) error {
+	if x.createdSet[rel] {
+		return nil
+	}
+	if err := x.mkdir(path.Dir(rel)); err != nil {
+		return err
+	}
+
+	target := filepath.Join(x.root, filepath.FromSlash(rel))
+	err := os.Mkdir(target, 0700)
+	if errors.Is(err, os.ErrExist) {
+		info, statErr := os.Lstat(target)
+		if statErr != nil || !info.IsDir() {
+			return fmt.Errorf("%s: exists and is not a directory: %w", rel, archive.ErrUnsafePath)
+		}
+		err = nil
+	}
+	if err != nil {
+		return err
+	}
+
+	x.createdSet[rel] = true
+	x.created = append(x.created, rel)
+	x.result.Dirs++
+	return nil
+}
+
+// restoreNode restores a file or symlink
+func (x *restorer) restoreNode(rel string, node Node) error {
+	target := filepath.Join(x.root, filepath.FromSlash(rel))
+
+	switch node.Type {
+	case archive.EntryFile:
+		if err := x.writeFile(target, rel, node); err != nil {
+			return err
+		}
+		x.result.Files++
+		x.result.Bytes += node.Size
+	case archive.EntrySymlink:
+		if err := os.Symlink(node.Linkname, target); err != nil {
+			return fmt.Errorf("%s: %w", rel, err)
+		}
+		x.result.Symlinks++
+	default:
+		return nil
+	}
+
+	x.applyMeta(rel, node)
+	return nil
+}
+
+// writeFile writes the chunks of a file. All-zero chunks become holes.
+func (x *restorer) writeFile(target, rel string, node Node) error {
+	file, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_EXCL|unix.O_NOFOLLOW, 0600)
+	if err != nil {
+		return fmt.Errorf("%s: %w", rel, err)
+	}
+	defer file.Close()
+
+	var offset int64
+	for _, id := range node.Content {
+		chunk, err := x.repository.loadObject(id)
+		if err != nil {
+			return fmt.Errorf("%s: %w", rel, err)
+		}
+		if !isZero(chunk) {
+			if _, err := file.WriteAt(chunk, offset); err != nil {
+				return fmt.Errorf("%s: %w", rel, err)
+			}
+		}
+		offset += int64(len(chunk))
+	}
+	if offset != node.Size {
+		return fmt.Errorf("%s: restored %d bytes, expected %d", rel, offset, node.Size)
+	}
+	if err := file.Truncate(offset); err != nil {
+		return fmt.Errorf("%s: %w", rel, err)
+	}
+	return file.Close()
+}
+
+// isZero reports whether a chunk only contains zero bytes
+func isZero(chunk []byte) bool {
+	for _, b := range chunk {
+		if b != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// applyMeta restores ownership, mode and mtime. Failures become warnings.
+func (x *restorer) applyMeta(rel string, node Node) {
+	target := filepath.Join(x.root, filepath.FromSlash(rel))
+	warn := func(what string, err error) {
+		x.result.Warnings = append(x.result.Warnings, fmt.Sprintf("%s: failed to restore %s: %v", rel, what, err))
+	}
+
+	if x.options.RestoreOwner {
+		if err := os.Lchown(target, node.UID, node.GID); err != nil {
+			warn("ownership", err)
+		}
+	}
+
+	if node.Type != archive.EntrySymlink {
+		// Ownership changes clear setuid/setgid, so the mode comes after it
+		mode := node.Mode & 0o1777
+		if x.options.RestoreOwner {
+			mode = node.Mode & 0o7777
+		}
+		if err := unix.Fchmodat(unix.AT_FDCWD, target, mode, 0); err != nil {
+			warn("mode", err)
+		}
+	}
+
+	mtime := unix.NsecToTimespec(node.ModTime)
+	if err := unix.UtimesNanoAt(unix.AT_FDCWD, target, []unix.Timespec{mtime, mtime}, unix.AT_SYMLINK_NOFOLLOW); err != nil {
+		warn("timestamps", err)
+	}
+}
+
+// List returns the entries of a snapshot matching the patterns, in the
+// order of a restore
+func (r *Repository) List(id string, patterns []string) ([]*archive.Entry, error) {
+	patterns, err := archive.CleanPatterns(patterns)
+	if err != nil {
+		return nil, err
+	}
+
+	snapshot, err := r.LoadSnapshot(id)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []*archive.Entry
+	var walk func(rel string, node Node) error
+	walk = func(rel string, node Node) error {
+		if archive.MatchEntry(rel, patterns) {
+			entries = append(entries, &archive.Entry{
+				Path:     rel,
+				Type:     node.Type,
+				Size:     node.Size,
+				Mode:     os.FileMode(node.Mode).Perm(),
+				ModTime:  time.Unix(0, node.ModTime),
+				Linkname: node.Linkname,
+			})
+		}
+		if node.Type != archive.EntryDir {
+			return nil
+		}
+
+		tree, err := r.loadTree(node.Subtree)
+		if err != nil {
+			return fmt.Errorf("%s: %w", rel, err)
+		}
+		for _, child := range tree.Nodes {
+			if err := walk(path.Join(rel, child.Name), child); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	for _, source := range snapshot.Sources {
+		if err := walk(source.Name, source.Root); err != nil {
+			return nil, err
+		}
+	}
+	return entries, nil
+}
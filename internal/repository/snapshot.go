@@ -0,0 +1,435 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"paperless-backup/internal/archive"
+)
+
+// Node is an entry of a directory listing. Types are the archive.Entry*
+// constants; hard links are stored as separate files sharing their chunks.
+type Node struct {
+	Name     string   `json:"name"`
+	Type     string   `json:"type"`
+	Mode     uint32   `json:"mode"` // Permission bits including setuid, setgid and sticky
+	UID      int      `json:"uid"`
+	GID      int      `json:"gid"`
+	ModTime  int64    `json:"mtime"` // Nanoseconds since the epoch
+	Size     int64    `json:"size,omitempty"`
+	Inode    uint64   `json:"inode,omitempty"`   // For change detection against the previous snapshot
+	Linkname string   `json:"link,omitempty"`    // Symlink target
+	Content  []string `json:"content,omitempty"` // Chunk IDs of files, in order
+	Subtree  string   `json:"subtree,omitempty"` // Tree ID of directories
+}
+
+// Tree lists the entries of a directory sorted by name. Trees are stored as
+// objects themselves, so unchanged directories are shared between snapshots.
+type Tree struct {
+	Nodes []Node `json:"nodes"`
+}
+
+// Snapshot is a complete restore point
+type Snapshot struct {
+	ID      string           `json:"-"`
+	Time    time.Time        `json:"time"`
+	Sources []SnapshotSource `json:"sources"`
+}
+
+// SnapshotSource is the state of one source in a snapshot
+type SnapshotSource struct {
+	Name       string   `json:"name"`
+	Mountpoint string   `json:"mountpoint"` // Path of the source on the original host
+	Root       Node     `json:"root"`
+	Include    []string `json:"include,omitempty"`
+	Exclude    []string `json:"exclude,omitempty"`
+	Stats      Stats    `json:"stats"`
+
+	// Files that vanished or changed while a tolerant source was read
+	Warnings []archive.Warning `json:"warnings,omitempty"`
+}
+
+// Stats counts what a backup read and stored
+type Stats struct {
+	Dirs        int   `json:"dirs"`
+	Files       int   `json:"files"`
+	Symlinks    int   `json:"symlinks"`
+	Skipped     int   `json:"skipped"`      // Sockets, FIFOs and devices
+	Unchanged   int   `json:"unchanged"`    // Files reused from the previous snapshot without reading
+	Bytes       int64 `json:"bytes"`        // Logical size of all files
+	NewObjects  int   `json:"new_objects"`  // Chunks and trees not stored before
+	StoredBytes int64 `json:"stored_bytes"` // Compressed size of the new objects
+}
+
+// add accumulates another source's counters
+func (s *Stats) add(other Stats) {
+	s.Dirs += other.Dirs
+	s.Files += other.Files
+	s.Symlinks += other.Symlinks
+	s.Skipped += other.Skipped
+	s.Unchanged += other.Unchanged
+	s.Bytes += other.Bytes
+	s.NewObjects += other.NewObjects
+	s.StoredBytes += other.StoredBytes
+}
+
+// Total returns the counters of all sources
+func (s *Snapshot) Total() Stats {
+	var total Stats
+	for _, source := range s.Sources {
+		total.add(source.Stats)
+	}
+	return total
+}
+
+// rereadAttempts limits how often a tolerant source's file that changes while
+// it is read is read again
+const rereadAttempts = 3
+
+// walker stores one source
+type walker struct {
+	ctx        context.Context // Stops the walk when cancelled
+	repository *Repository
+	source     archive.Source
+	stats      Stats
+	written    []string // Objects written by this backup, for verification
+	warnings   []archive.Warning
+}
+
+// Backup stores the sources as a new snapshot. Files whose size, mtime and
+// inode match the previous snapshot reuse its chunks without being read.
+// Cancelling ctx stops the backup before the snapshot is saved; the chunks
+// written so far are removed by the next garbage collection.
+func (r *Repository) Backup(ctx context.Context, sources []archive.Source) (*Snapshot, error) {
+	for _, source := range sources {
+		if source.Name == "" || source.Name == "." || source.Name == ".." || path.Base(source.Name) != source.Name {
+			return nil, fmt.Errorf("invalid source name %q", source.Name)
+		}
+		if err := source.Filter.Validate(); err != nil {
+			return nil, fmt.Errorf("source %s: %w", source.Name, err)
+		}
+		if err := archive.ValidateReadErrors(source.ReadErrors); err != nil {
+			return nil, fmt.Errorf("source %s: %w", source.Name, err)
+		}
+	}
+
+	parent := r.parentSnapshot()
+	snapshot := &Snapshot{Time: now()}
+	var written []string
+
+	for _, source := range sources {
+		info, err := os.Lstat(source.Path)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			return nil, fmt.Errorf("source %s is not a directory: %s", source.Name, source.Path)
+		}
+
+		var parentTree *Tree
+		if parent != nil {
+			for _, s := range parent.Sources {
+				if s.Name == source.Name {
+					parentTree, _ = r.loadTree(s.Root.Subtree)
+				}
+			}
+		}
+
+		w := &walker{ctx: ctx, repository: r, source: source}
+		root := nodeFromInfo(info)
+		if root.Subtree, err = w.saveDir(source.Path, "", parentTree); err != nil {
+			return nil, err
+		}
+		written = append(written, w.written...)
+
+		snapshot.Sources = append(snapshot.Sources, SnapshotSource{
+			Name:       source.Name,
+			Mountpoint: source.Path,
+			Root:       root,
+			Include:    source.Filter.Include,
+			Exclude:    source.Filter.Exclude,
+			Stats:      w.stats,
+			Warnings:   w.warnings,
+		})
+	}
+
+	if err := r.syncObjects(); err != nil {
+		return nil, err
+	}
+	if r.options.Verify {
+		for _, id := range written {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+			if _, err := r.loadObject(id); err != nil {
+				return nil, fmt.Errorf("verification failed: %w", err)
+			}
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if err := r.saveSnapshot(snapshot); err != nil {
+		return nil, err
+	}
+	return snapshot, nil
+}
+
+// parentSnapshot returns the newest snapshot, or nil
+func (r *Repository) parentSnapshot() *Snapshot {
+	ids, err := r.Snapshots()
+	if err != nil || len(ids) == 0 {
+		return nil
+	}
+	snapshot, err := r.LoadSnapshot(ids[len(ids)-1])
+	if err != nil {
+		return nil
+	}
+	return snapshot
+}
+
+// nodeFromInfo fills the metadata of a node
+func nodeFromInfo(info os.FileInfo) Node {
+	node := Node{
+		Name:    info.Name(),
+		Mode:    uint32(info.Mode().Perm()),
+		ModTime: info.ModTime().UnixNano(),
+	}
+	if info.Mode()&os.ModeSetuid != 0 {
+		node.Mode |= syscall.S_ISUID
+	}
+	if info.Mode()&os.ModeSetgid != 0 {
+		node.Mode |= syscall.S_ISGID
+	}
+	if info.Mode()&os.ModeSticky != 0 {
+		node.Mode |= syscall.S_ISVTX
+	}
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		node.UID = int(stat.Uid)
+		node.GID = int(stat.Gid)
+		node.Inode = stat.Ino
+	}
+
+	switch {
+	case info.IsDir():
+		node.Type = archive.EntryDir
+	case info.Mode().IsRegular():
+		node.Type = archive.EntryFile
+		node.Size = info.Size()
+	case info.Mode()&os.ModeSymlink != 0:
+		node.Type = archive.EntrySymlink
+	default:
+		node.Type = archive.EntryOther
+	}
+	return node
+}
+
+// saveDir stores a directory and everything below it and returns its tree
+// ID. rel is the slash separated path relative to the source root.
+func (w *walker) saveDir(dir, rel string, parent *Tree) (string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return "", err
+	}
+
+	previous := make(map[string]Node)
+	if parent != nil {
+		for _, node := range parent.Nodes {
+			previous[node.Name] = node
+		}
+	}
+
+	tree := Tree{Nodes: []Node{}}
+	for _, entry := range entries {
+		if err := w.ctx.Err(); err != nil {
+			return "", err
+		}
+		entryPath := filepath.Join(dir, entry.Name())
+		entryRel := path.Join(rel, entry.Name())
+		if w.source.Filter.Excludes(entryRel, entry.IsDir()) {
+			continue
+		}
+
+		info, err := os.Lstat(entryPath)
+		if err != nil {
+			if w.skipVanished(entryRel, err) {
+				continue
+			}
+			return "", err
+		}
+		node := nodeFromInfo(info)
+		old, hasOld := previous[node.Name]
+
+		switch node.Type {
+		case archive.EntryDir:
+			var parentTree *Tree
+			if hasOld && old.Type == archive.EntryDir {
+				parentTree, _ = w.repository.loadTree(old.Subtree)
+			}
+			if node.Subtree, err = w.saveDir(entryPath, entryRel, parentTree); err != nil {
+				if w.skipVanished(entryRel, err) {
+					continue
+				}
+				return "", err
+			}
+			w.stats.Dirs++
+		case archive.EntryFile:
+			if hasOld && w.unchanged(old, node) {
+				node.Content = old.Content
+				w.stats.Unchanged++
+			} else if err := w.saveFile(entryPath, entryRel, &node); err != nil {
+				if w.skipVanished(entryRel, err) {
+					continue
+				}
+				return "", err
+			}
+			w.stats.Files++
+			w.stats.Bytes += node.Size
+		case archive.EntrySymlink:
+			if node.Linkname, err = os.Readlink(entryPath); err != nil {
+				if w.skipVanished(entryRel, err) {
+					continue
+				}
+				return "", err
+			}
+			w.stats.Symlinks++
+		default:
+			w.stats.Skipped++
+			continue
+		}
+		tree.Nodes = append(tree.Nodes, node)
+	}
+
+	content, err := json.Marshal(tree)
+	if err != nil {
+		return "", err
+	}
+	return w.save(content)
+}
+
+// unchanged reports whether a file can reuse the chunks of its previous
+// version. The chunks must still be present.
+func (w *walker) unchanged(old, current Node) bool {
+	if old.Type != archive.EntryFile || old.Size != current.Size || old.ModTime != current.ModTime || old.Inode != current.Inode {
+		return false
+	}
+	for _, id := range old.Content {
+		if !w.repository.hasObject(id) {
+			return false
+		}
+	}
+	return true
+}
+
+// saveFile stores the chunks of a file and updates its node to the version
+// read. A file that changes while it is read fails the backup, unless the
+// source is tolerant: then it is read again, and marked when it does not
+// settle. Chunks of earlier attempts are deduplicated.
+func (w *walker) saveFile(filePath, rel string, node *Node) error {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	for attempt := 1; ; attempt++ {
+		before, err := file.Stat()
+		if err != nil {
+			return err
+		}
+		if _, err := file.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		content, size, err := w.saveChunks(file)
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", filePath, err)
+		}
+		after, err := file.Stat()
+		if err != nil {
+			return err
+		}
+
+		node.Content = content
+		node.Size = size
+		node.ModTime = after.ModTime().UnixNano()
+		if archive.SameVersion(before, after) && size == after.Size() {
+			if attempt > 1 {
+				w.warn(rel, archive.ProblemReread)
+			}
+			return nil
+		}
+		if !w.source.Tolerant() {
+			return fmt.Errorf("%s: %w", filePath, archive.ErrSourceChanged)
+		}
+		if attempt == rereadAttempts {
+			// No inode matches, so the next snapshot reads the file again
+			node.Inode = 0
+			w.warn(rel, archive.ProblemChanged)
+			return nil
+		}
+	}
+}
+
+// saveChunks stores the content of r and returns its chunk IDs and size
+func (w *walker) saveChunks(r io.Reader) ([]string, int64, error) {
+	var content []string
+	var size int64
+	chunks := newChunker(r, w.repository.config.Chunker)
+	for {
+		if err := w.ctx.Err(); err != nil {
+			return nil, 0, err
+		}
+		chunk, err := chunks.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+		id, err := w.save(chunk)
+		if err != nil {
+			return nil, 0, err
+		}
+		content = append(content, id)
+		size += int64(len(chunk))
+	}
+	return content, size, nil
+}
+
+// skipVanished reports whether an error for a path is tolerated because the
+// path was deleted while its source was read
+func (w *walker) skipVanished(rel string, err error) bool {
+	if !w.source.Tolerant() || !errors.Is(err, fs.ErrNotExist) {
+		return false
+	}
+	w.warn(rel, archive.ProblemVanished)
+	return true
+}
+
+// warn records a tolerated problem with a path relative to the source
+func (w *walker) warn(rel, problem string) {
+	w.warnings = append(w.warnings, archive.Warning{Path: path.Join(w.source.Name, rel), Problem: problem})
+}
+
+// save stores an object and counts it when new
+func (w *walker) save(content []byte) (string, error) {
+	id, stored, err := w.repository.saveObject(content)
+	if err != nil {
+		return "", err
+	}
+	if stored > 0 {
+		w.stats.NewObjects++
+		w.stats.StoredBytes += stored
+		w.written = append(w.written, id)
+	}
+	return id, nil
+}
@@ -25,7 +25,7 @@ func New(logger *logger.Logger, serviceName string) *Manager {
 }
 
 // Stop stops the service if it's running
-func (m *Manager) Stop() {
+func (m *Manager) Stop() error {
 	m.logger.Logf("INFO", "Checking %s state...", m.serviceName)
 
 	cmd := exec.Command("systemctl", "is-active", "--quiet", m.serviceName)
@@ -36,7 +36,7 @@ func (m *Manager) Stop() {
 
 		stopCmd := exec.Command("systemctl", "stop", m.serviceName)
 		if err := stopCmd.Run(); err != nil {
-			m.logger.ErrorExit(fmt.Sprintf("Failed to stop %s", m.serviceName))
+			return fmt.Errorf("failed to stop %s: %w", m.serviceName, err)
 		}
 
 		m.logger.Logf("INFO", "%s stopped", m.serviceName)
@@ -44,23 +44,25 @@ func (m *Manager) Stop() {
 	} else {
 		m.logger.Logf("INFO", "%s is already stopped", m.serviceName)
 	}
+	return nil
 }
 
 // Restore restarts the service if it was running before
-func (m *Manager) Restore() {
+func (m *Manager) Restore() error {
 	if !m.wasRunning {
-		return
+		return nil
 	}
 
 	m.logger.Logf("INFO", "Restoring %s to running state...", m.serviceName)
 	cmd := exec.Command("systemctl", "start", m.serviceName)
 	if err := cmd.Run(); err != nil {
-		m.logger.Logf("WARN", "Failed to restart %s", m.serviceName)
+		return fmt.Errorf("failed to restart %s: %w", m.serviceName, err)
 	}
+	m.wasRunning = false
+	return nil
 }
 
 // WasRunning returns whether the service was running before being stopped
 func (m *Manager) WasRunning() bool {
 	return m.wasRunning
 }
-